@@ -0,0 +1,48 @@
+// Package chores embeds the files the server needs at runtime, so the binary
+// works from any working directory.
+package chores
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// DevModeEnv names the environment variable that makes the server read
+// migrations and static files from disk instead of the embedded copies, so
+// edits show up without a rebuild when running under air
+const DevModeEnv = "CHORES_DEV"
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+//go:embed static
+var static embed.FS
+
+// DevMode reports whether assets are read from disk
+func DevMode() bool {
+	return os.Getenv(DevModeEnv) != ""
+}
+
+// Migrations returns the SQL migrations, rooted at the migrations directory
+func Migrations() fs.FS {
+	return assets(migrations, "migrations")
+}
+
+// Static returns the static files, rooted at the static directory
+func Static() fs.FS {
+	return assets(static, "static")
+}
+
+// assets returns dir from disk in dev mode, and from the embedded files otherwise
+func assets(embedded embed.FS, dir string) fs.FS {
+	if DevMode() {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(embedded, dir)
+	if err != nil {
+		// dir is always embedded above, so this can't happen
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bagvendt/chores"
+	"github.com/bagvendt/chores/internal/database"
+)
+
+// runCommand runs a maintenance subcommand instead of starting the server
+func runCommand(args []string) error {
+	switch args[0] {
+	case "rollback":
+		return rollbackCommand(args[1:])
+	case "status":
+		return statusCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// rollbackCommand reverts applied migrations down to the given migration ID,
+// where 0 reverts all of them
+func rollbackCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: server rollback <migration id>")
+	}
+
+	to, err := strconv.Atoi(args[0])
+	if err != nil || to < 0 {
+		return fmt.Errorf("invalid migration id %q", args[0])
+	}
+
+	store, err := database.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.Rollback(chores.Migrations(), to)
+}
+
+// statusCommand lists applied, pending, drifted and missing migrations
+func statusCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: server status")
+	}
+
+	store, err := database.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	statuses, err := store.MigrationStatus(chores.Migrations())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tAPPLIED\tFILE")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.ID, status.State, status.AppliedAt, status.Path)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return store.ValidateMigrations(chores.Migrations())
+}
+
+// restoreCommand swaps a backup in for the database, or lists the backups
+// when none is given. The server must be stopped first
+func restoreCommand(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: server restore [backup file]")
+	}
+
+	dbPath := database.URL()
+
+	if len(args) == 0 {
+		backups, err := database.ListBackups(dbPath)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			fmt.Printf("No backups of %s\n", dbPath)
+		}
+		for _, backup := range backups {
+			fmt.Println(backup)
+		}
+		return nil
+	}
+
+	safetyPath, err := database.Restore(dbPath, args[0], time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %s from %s\n", dbPath, args[0])
+	if safetyPath != "" {
+		fmt.Printf("The replaced database was saved as %s\n", safetyPath)
+	}
+	return nil
+}
@@ -4,18 +4,40 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"path/filepath"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/bagvendt/chores"
 	"github.com/bagvendt/chores/internal/contextkeys"
 	"github.com/bagvendt/chores/internal/database"
 	"github.com/bagvendt/chores/internal/handlers"
+	"github.com/bagvendt/chores/internal/models"
 	"github.com/bagvendt/chores/internal/services"
+
+	// Embedded zone data, so HOUSEHOLD_TIMEZONE works without tzdata installed
+	_ "time/tzdata"
 )
 
 // authMiddlewareHandler wraps a http.Handler with authentication logic,
 // and attaches the authenticated user to the request context.
-func authMiddlewareHandler(next http.Handler) http.Handler {
+func authMiddlewareHandler(authentication *services.AuthenticationService, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Scripts call the API with a personal access token instead of a cookie
+		if token, ok := bearerToken(r); ok && strings.HasPrefix(r.URL.Path, "/api/") {
+			user, valid := authentication.AuthenticateAPIToken(r.Context(), token)
+			if !valid {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="chores"`)
+				http.Error(w, "Invalid API token", http.StatusUnauthorized)
+				return
+			}
+
+			// Browsers never attach bearer tokens on their own, so there is no CSRF to check
+			ctx := context.WithValue(r.Context(), contextkeys.UserContextKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		// Check for session cookie
 		cookie, err := r.Cookie("session_token")
 		if err != nil {
@@ -25,32 +47,87 @@ func authMiddlewareHandler(next http.Handler) http.Handler {
 		}
 
 		// Validate the session token
-		user, valid := services.ValidateSession(cookie.Value)
+		session, valid := authentication.LookupSession(r.Context(), cookie.Value)
 		if !valid {
 			// Invalid session, redirect to login
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
-		// Attach the authenticated user to context
-		ctx := context.WithValue(r.Context(), contextkeys.UserContextKey, user)
+		// The browser must keep the cookie as long as the session now lasts
+		if session.Extended {
+			handlers.SetSessionCookie(w, r, cookie.Value)
+		}
+
+		// Reject state changing requests that don't carry the session's CSRF token
+		if !safeMethod(r.Method) && !services.ValidCSRFToken(session, csrfTokenFromRequest(r)) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		// Attach the authenticated user and CSRF token to context
+		ctx := context.WithValue(r.Context(), contextkeys.UserContextKey, session.User)
+		ctx = context.WithValue(ctx, contextkeys.CSRFTokenContextKey, session.CSRFToken)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func main() {
-	// Initialize the database first
-	if err := database.Init(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
 	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// safeMethod reports whether an HTTP method never changes state
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// csrfTokenFromRequest returns the CSRF token sent by HTMX and fetch in the
+// X-CSRF-Token header, or by plain forms in the csrf_token field
+func csrfTokenFromRequest(r *http.Request) string {
+	if token := r.Header.Get("X-CSRF-Token"); token != "" {
+		return token
+	}
+	return r.PostFormValue("csrf_token")
+}
+
+// adminMiddlewareHandler only lets administrators through to the wrapped handler.
+// It must run inside authMiddlewareHandler so the user is already in the context.
+func adminMiddlewareHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(contextkeys.UserContextKey).(*models.User)
+		if !ok || user == nil || !user.IsAdmin {
+			handlers.ForbiddenHandler(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routes builds the handler for all public and protected routes, with days
+// counted in the household's time zone and logins throttled by limiter.
+// behindFly says whether to trust the Fly proxy's client address header
+func routes(store *database.Store, limiter *services.LoginRateLimiter, scheduler *services.Scheduler, household *time.Location, behindFly bool) http.Handler {
+	authentication := services.NewAuthenticationService(store.Users, store.Sessions, store.APITokens)
+	server := handlers.NewServer(store, authentication, limiter, scheduler, household, behindFly)
 
 	// Public routes (without auth)
 	publicMux := http.NewServeMux()
-	publicMux.HandleFunc("/login", handlers.LoginHandler)   // Login route
-	publicMux.HandleFunc("/logout", handlers.LogoutHandler) // Logout route
+	publicMux.HandleFunc("/login", server.LoginHandler)         // Login route
+	publicMux.HandleFunc("/login/pin/", server.PINLoginHandler) // PIN keypad for children
+	publicMux.HandleFunc("/logout", server.LogoutHandler)       // Logout route
 
 	// Static files (should be accessible without auth)
-	fs := http.FileServer(http.Dir(filepath.Join(".", "static")))
+	fs := http.FileServer(http.FS(chores.Static()))
 	publicMux.Handle("/static/", http.StripPrefix("/static/", fs))
 
 	// Root mux for all routes (protected by auth)
@@ -62,31 +139,37 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
-		handlers.HomeHandler(w, r)
+		server.HomeHandler(w, r)
 	})
-	protectedMux.HandleFunc("/routine/", handlers.RoutineDetailHandler) // New route for routine detail view with chore cards
+	protectedMux.HandleFunc("/routine/", server.RoutineDetailHandler) // New route for routine detail view with chore cards
 
 	// API routes
-	protectedMux.HandleFunc("/api/", handlers.APIHandler)
+	protectedMux.HandleFunc("/api/", server.APIHandler)
 
 	// Admin sub-mux for structured admin routes
 	adminMux := http.NewServeMux()
 	adminMux.HandleFunc("/", handlers.MainHandler)
-	adminMux.HandleFunc("/routines/", handlers.RoutinesHandler)
-	adminMux.HandleFunc("/blueprints", handlers.BlueprintsHandler)
-	adminMux.HandleFunc("/blueprints/", handlers.BlueprintsHandler)
-	adminMux.HandleFunc("/chores", handlers.ChoresHandler)
-	adminMux.HandleFunc("/chores/", handlers.ChoresHandler)
-	protectedMux.Handle("/admin/", http.StripPrefix("/admin", adminMux))
+	adminMux.HandleFunc("/routines/", server.RoutinesHandler)
+	adminMux.HandleFunc("/blueprints", server.BlueprintsHandler)
+	adminMux.HandleFunc("/blueprints/", server.BlueprintsHandler)
+	adminMux.HandleFunc("/chores", server.ChoresHandler)
+	adminMux.HandleFunc("/chores/", server.ChoresHandler)
+	adminMux.HandleFunc("/users", server.UsersHandler)
+	adminMux.HandleFunc("/users/", server.UsersHandler)
+	adminMux.HandleFunc("/tokens", server.APITokensHandler)
+	adminMux.HandleFunc("/tokens/", server.APITokensHandler)
+	adminMux.HandleFunc("/scheduler", server.SchedulerHandler)
+	protectedMux.Handle("/admin/", http.StripPrefix("/admin", adminMiddlewareHandler(adminMux)))
 
 	// Wrap protected routes in auth middleware
-	protectedHandler := authMiddlewareHandler(protectedMux)
+	protectedHandler := authMiddlewareHandler(authentication, protectedMux)
 
 	// Main mux that combines public and protected routes
 	mainMux := http.NewServeMux()
 
 	// Add public routes first (no auth)
 	mainMux.HandleFunc("/login", publicMux.ServeHTTP)
+	mainMux.HandleFunc("/login/pin/", publicMux.ServeHTTP)
 	mainMux.HandleFunc("/logout", publicMux.ServeHTTP)
 	mainMux.Handle("/static/", publicMux)
 
@@ -96,8 +179,60 @@ func main() {
 	mainMux.HandleFunc("/api/", protectedHandler.ServeHTTP)
 	mainMux.Handle("/admin/", protectedHandler)
 
+	return mainMux
+}
+
+func main() {
+	// Maintenance commands run against the database and exit
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatalf("Command %s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	household, err := services.HouseholdLocation()
+	if err != nil {
+		log.Fatalf("Failed to load household time zone: %v", err)
+	}
+	log.Printf("Counting days in the %s time zone", household)
+
+	behindFly, err := handlers.BehindFly()
+	if err != nil {
+		log.Fatalf("Failed to read proxy settings: %v", err)
+	}
+	if behindFly {
+		log.Printf("%s is set, taking client addresses from Fly-Client-IP", handlers.BehindFlyEnv)
+	}
+
+	// Initialize the database first
+	if chores.DevMode() {
+		log.Printf("%s is set, reading migrations and static files from disk", chores.DevModeEnv)
+	}
+	store, err := database.Init(chores.Migrations())
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer store.Close()
+
+	// Purge expired sessions in the background
+	stopSessionSweeper := services.StartSessionSweeper(store.Sessions, time.Hour)
+	defer stopSessionSweeper()
+
+	// Start each day's routines at the household's midnight, checking every minute
+	scheduler := services.NewScheduler(store.Routines, store.Blueprints, store.Users, services.SystemClock{}, household)
+	stopScheduler := scheduler.Start(time.Minute)
+	defer stopScheduler()
+
+	// Back off after repeated failed logins from the same address or for the same user
+	rateLimit, err := services.LoginRateLimitConfig()
+	if err != nil {
+		log.Fatalf("Failed to read login rate limit settings: %v", err)
+	}
+	limiter := services.NewLoginRateLimiter(rateLimit, services.SystemClock{})
+
 	log.Println("Server is starting on port 8080...")
-	if err := http.ListenAndServe(":8080", mainMux); err != nil {
+	if err := http.ListenAndServe(":8080", routes(store, limiter, scheduler, household, behindFly)); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
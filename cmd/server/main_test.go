@@ -0,0 +1,903 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bagvendt/chores"
+	"github.com/bagvendt/chores/internal/database"
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
+	"github.com/bagvendt/chores/internal/services"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setupTestServer migrates a fresh database with the real migrations and
+// returns the full router and its store along with session tokens for a
+// child and a parent
+func setupTestServer(t *testing.T) (http.Handler, *database.Store, string, string) {
+	tempDir := t.TempDir()
+	db, readDB, err := database.OpenFile(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	store := database.NewStore(db, readDB)
+	t.Cleanup(func() { store.Close() })
+
+	if err := store.RunMigrations(chores.Migrations()); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// Give the seeded users a known password
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if _, err := db.Exec("UPDATE users SET password = ?", string(hashed)); err != nil {
+		t.Fatalf("Failed to set passwords: %v", err)
+	}
+
+	// poul is a child, bagvendt is the admin parent
+	authentication := authenticationFor(store)
+	_, childToken, err := authentication.AuthenticateUser(t.Context(), "poul", "secret", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in child: %v", err)
+	}
+	_, parentToken, err := authentication.AuthenticateUser(t.Context(), "bagvendt", "secret", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in parent: %v", err)
+	}
+
+	limiter := services.NewLoginRateLimiter(services.DefaultRateLimitConfig, services.SystemClock{})
+	scheduler := services.NewScheduler(store.Routines, store.Blueprints, store.Users, services.SystemClock{}, time.Local)
+	return routes(store, limiter, scheduler, time.Local, false), store, childToken, parentToken
+}
+
+// authenticationFor returns an authentication service on top of the test store
+func authenticationFor(store *database.Store) *services.AuthenticationService {
+	return services.NewAuthenticationService(store.Users, store.Sessions, store.APITokens)
+}
+
+func doRequest(handler http.Handler, method, path, token string, form url.Values) *httptest.ResponseRecorder {
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+	req := httptest.NewRequest(method, path, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	if method != http.MethodGet {
+		req.Header.Set("X-CSRF-Token", csrfTokenFor(handler, token))
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+var csrfMeta = regexp.MustCompile(`<meta name="csrf-token" content="([^"]*)"`)
+
+// csrfTokenFor returns the CSRF token of the session with the given token,
+// read from the home page the way scripts in the browser would
+func csrfTokenFor(handler http.Handler, token string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	match := csrfMeta.FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+var adminRoutes = []struct {
+	method string
+	path   string
+	form   url.Values
+}{
+	{http.MethodGet, "/admin/", nil},
+	{http.MethodGet, "/admin/routines/", nil},
+	{http.MethodGet, "/admin/blueprints", nil},
+	{http.MethodGet, "/admin/blueprints/new", nil},
+	{http.MethodGet, "/admin/blueprints/1", nil},
+	{http.MethodGet, "/admin/blueprints/1/edit", nil},
+	{http.MethodPost, "/admin/blueprints/", url.Values{
+		"name": {"Test"}, "to_be_completed_by": {"09:00"}, "recurrence": {"Daily"}, "image": {"morning.avif"}, "chores": {"1"},
+	}},
+	{http.MethodPost, "/admin/blueprints/1", url.Values{
+		"name": {"Morgen"}, "to_be_completed_by": {"08:00"}, "recurrence": {"Weekly"}, "weekdays": {"1", "2", "3", "4", "5"}, "image": {"morning.avif"}, "chores": {"1", "2"},
+	}},
+	{http.MethodDelete, "/admin/blueprints/2", nil},
+	{http.MethodGet, "/admin/chores", nil},
+	{http.MethodGet, "/admin/chores/new", nil},
+	{http.MethodGet, "/admin/chores/1", nil},
+	{http.MethodGet, "/admin/chores/1/edit", nil},
+	{http.MethodPost, "/admin/chores", url.Values{"name": {"Test"}, "default_points": {"5"}}},
+	{http.MethodPost, "/admin/chores/1/edit", url.Values{"name": {"Spis morgenmad"}, "default_points": {"10"}}},
+	{http.MethodDelete, "/admin/chores/11", nil},
+	{http.MethodGet, "/admin/users", nil},
+	{http.MethodGet, "/admin/users/new", nil},
+	{http.MethodGet, "/admin/users/1", nil},
+	{http.MethodGet, "/admin/users/1/edit", nil},
+	{http.MethodPost, "/admin/users", url.Values{"name": {"lille"}, "password": {"long-secret"}, "role": {"child"}}},
+	{http.MethodPost, "/admin/users/1", url.Values{"name": {"poul"}, "role": {"child"}}},
+	{http.MethodPost, "/admin/users/2/password", url.Values{"password": {"long-secret"}}},
+	{http.MethodPost, "/admin/users/2/pin", url.Values{"pin": {"1234"}}},
+	{http.MethodPost, "/admin/users/2/pin/clear", nil},
+	{http.MethodPost, "/admin/users/2/deactivate", nil},
+	{http.MethodPost, "/admin/users/2/activate", nil},
+	{http.MethodGet, "/admin/tokens", nil},
+	{http.MethodPost, "/admin/tokens", url.Values{"name": {"Home Assistant"}, "user_id": {"2"}}},
+	{http.MethodPost, "/admin/tokens/1/revoke", nil},
+	{http.MethodGet, "/admin/scheduler", nil},
+	{http.MethodPost, "/admin/scheduler", nil},
+}
+
+func TestAdminRoutesForbiddenForChild(t *testing.T) {
+	handler, store, childToken, _ := setupTestServer(t)
+
+	for _, route := range adminRoutes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			rec := doRequest(handler, route.method, route.path, childToken, route.form)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+			}
+		})
+	}
+
+	// Nothing the child tried should have changed the database
+	var blueprints, chores int
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM routine_blueprints").Scan(&blueprints); err != nil {
+		t.Fatalf("Failed to count blueprints: %v", err)
+	}
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM chores").Scan(&chores); err != nil {
+		t.Fatalf("Failed to count chores: %v", err)
+	}
+	if blueprints != 3 || chores != 11 {
+		t.Errorf("Expected seed data to be untouched, got %d blueprints and %d chores", blueprints, chores)
+	}
+}
+
+func TestAdminRoutesAllowedForParent(t *testing.T) {
+	handler, _, _, parentToken := setupTestServer(t)
+
+	for _, route := range adminRoutes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			rec := doRequest(handler, route.method, route.path, parentToken, route.form)
+			if rec.Code == http.StatusForbidden || rec.Header().Get("Location") == "/login" {
+				t.Errorf("Expected parent to be allowed, got %d", rec.Code)
+			}
+			if rec.Code >= http.StatusInternalServerError {
+				t.Errorf("Expected route to succeed, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminLinkOnlyShownToAdmins(t *testing.T) {
+	handler, _, childToken, parentToken := setupTestServer(t)
+
+	child := doRequest(handler, http.MethodGet, "/", childToken, nil)
+	if strings.Contains(child.Body.String(), `href="/admin/"`) {
+		t.Errorf("Expected admin link to be hidden for child")
+	}
+
+	parent := doRequest(handler, http.MethodGet, "/", parentToken, nil)
+	if !strings.Contains(parent.Body.String(), `href="/admin/"`) {
+		t.Errorf("Expected admin link to be shown for parent")
+	}
+}
+
+func TestParentActsForChild(t *testing.T) {
+	handler, store, childToken, parentToken := setupTestServer(t)
+
+	// The seeded blueprints only run on weekdays
+	if _, err := store.DB.Exec("UPDATE routine_blueprints SET recurrence = 'Daily' WHERE id = 1"); err != nil {
+		t.Fatalf("Failed to update blueprint: %v", err)
+	}
+
+	// poul (1) and ulla (2) are children of bagvendt (3)
+	home := doRequest(handler, http.MethodGet, "/?child=2", parentToken, nil)
+	if !strings.Contains(home.Body.String(), "/routine/create-from-blueprint/1?owner=2") {
+		t.Errorf("Expected home screen to offer routines for the selected child")
+	}
+
+	rec := doRequest(handler, http.MethodPost, "/routine/create-from-blueprint/1?owner=2", parentToken, nil)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect to the new routine, got %d", rec.Code)
+	}
+
+	var routineID, ownerID int64
+	if err := store.DB.QueryRow("SELECT id, owner_id FROM routines ORDER BY id DESC LIMIT 1").Scan(&routineID, &ownerID); err != nil {
+		t.Fatalf("Failed to load routine: %v", err)
+	}
+	if ownerID != 2 {
+		t.Errorf("Expected routine to be owned by the child, got owner %d", ownerID)
+	}
+
+	// Tapping the card again opens the same routine
+	rec = doRequest(handler, http.MethodPost, "/routine/create-from-blueprint/1?owner=2", parentToken, nil)
+	if location := rec.Header().Get("Location"); location != fmt.Sprintf("/routine/%d", routineID) {
+		t.Errorf("Expected to be sent back to routine %d, got %q", routineID, location)
+	}
+	if rec = doRequest(handler, http.MethodPost, "/routine/create-from-blueprint/999?owner=2", parentToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown blueprint to be not found, got %d", rec.Code)
+	}
+
+	// Completing a chore records the parent who did it
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/routine/%d/chore/1", routineID), strings.NewReader(`{"completed":true}`))
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: parentToken})
+	req.Header.Set("X-CSRF-Token", csrfTokenFor(handler, parentToken))
+	api := httptest.NewRecorder()
+	handler.ServeHTTP(api, req)
+	if api.Code != http.StatusOK {
+		t.Fatalf("Expected chore completion to succeed, got %d: %s", api.Code, api.Body.String())
+	}
+
+	var completedBy int64
+	if err := store.DB.QueryRow("SELECT completed_by FROM chore_routines WHERE routine_id = ? AND chore_id = 1", routineID).Scan(&completedBy); err != nil {
+		t.Fatalf("Failed to load chore routine: %v", err)
+	}
+	if completedBy != 3 {
+		t.Errorf("Expected chore to be completed by the parent, got %d", completedBy)
+	}
+
+	// A child can't start routines for a sibling
+	rec = doRequest(handler, http.MethodPost, "/routine/create-from-blueprint/1?owner=2", childToken, nil)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected child to be forbidden, got %d", rec.Code)
+	}
+
+	// Starting a routine changes data, so a link or a forged form can't do it
+	rec = doRequest(handler, http.MethodGet, "/routine/create-from-blueprint/1?owner=2", parentToken, nil)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be rejected, got %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/routine/create-from-blueprint/1?owner=2", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: parentToken})
+	forged := httptest.NewRecorder()
+	handler.ServeHTTP(forged, req)
+	if forged.Code != http.StatusForbidden {
+		t.Errorf("Expected a POST without the CSRF token to be rejected, got %d", forged.Code)
+	}
+}
+
+func TestSchedulerRunNow(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	if _, err := store.DB.Exec("UPDATE routine_blueprints SET recurrence = 'Daily', starts_on = '2024-01-01' WHERE id = 1"); err != nil {
+		t.Fatalf("Failed to update blueprint: %v", err)
+	}
+
+	page := doRequest(handler, http.MethodGet, "/admin/scheduler", parentToken, nil)
+	if !strings.Contains(page.Body.String(), "hasn't run yet") {
+		t.Errorf("Expected the scheduler page to say it hasn't run")
+	}
+
+	// Both children get today's routine, and running it again adds nothing.
+	// Each run redirects back, so refreshing the page doesn't run it again
+	for i := 0; i < 2; i++ {
+		rec := doRequest(handler, http.MethodPost, "/admin/scheduler", parentToken, nil)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/scheduler" {
+			t.Fatalf("Expected the run to redirect to the scheduler page, got %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+		page := doRequest(handler, http.MethodGet, "/admin/scheduler", parentToken, nil)
+		if want := fmt.Sprintf("%d routines started", 2-2*i); !strings.Contains(page.Body.String(), want) {
+			t.Errorf("Expected run %d to report %q", i+1, want)
+		}
+	}
+
+	var routines int
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM routines WHERE routine_blueprint_id = 1").Scan(&routines); err != nil {
+		t.Fatalf("Failed to count routines: %v", err)
+	}
+	if routines != 2 {
+		t.Errorf("Expected a routine for each child, got %d", routines)
+	}
+
+	// A failed run is reported on the page it redirects to. The home page the
+	// CSRF token is read from needs the routines table, so read it first
+	csrfToken := csrfTokenFor(handler, parentToken)
+	if _, err := store.DB.Exec("ALTER TABLE routines RENAME TO broken_routines"); err != nil {
+		t.Fatalf("Failed to break the routines table: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/admin/scheduler", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: parentToken})
+	req.Header.Set("X-CSRF-Token", csrfToken)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusSeeOther || location != "/admin/scheduler?failed=1" {
+		t.Fatalf("Expected the failed run to redirect with the failure, got %d to %q", rec.Code, location)
+	}
+	page = doRequest(handler, http.MethodGet, location, parentToken, nil)
+	if !strings.Contains(page.Body.String(), "The scheduler failed") {
+		t.Errorf("Expected the scheduler page to show the failure")
+	}
+}
+
+func TestPINLogin(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	// Admins can't be given a PIN
+	rec := doRequest(handler, http.MethodPost, "/admin/users/3/pin", parentToken, url.Values{"pin": {"1234"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected admin PIN to be rejected, got %d", rec.Code)
+	}
+	rec = doRequest(handler, http.MethodPost, "/admin/users/1/pin", parentToken, url.Values{"pin": {"12a4"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected malformed PIN to be rejected, got %d", rec.Code)
+	}
+	rec = doRequest(handler, http.MethodPost, "/admin/users/1/pin", parentToken, url.Values{"pin": {"1234"}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected PIN to be set, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Only poul has a PIN, so only poul gets a tile
+	login := doRequest(handler, http.MethodGet, "/login", "", nil)
+	if !strings.Contains(login.Body.String(), `href="/login/pin/1"`) {
+		t.Errorf("Expected login page to show a tile for poul")
+	}
+	if strings.Contains(login.Body.String(), `href="/login/pin/3"`) {
+		t.Errorf("Expected login page to hide the admin")
+	}
+	if rec := doRequest(handler, http.MethodGet, "/login/pin/3", "", nil); rec.Code != http.StatusSeeOther {
+		t.Errorf("Expected admin keypad to redirect to password login, got %d", rec.Code)
+	}
+
+	wrong := doRequest(handler, http.MethodPost, "/login/pin/1", "", url.Values{"pin": {"0000"}})
+	if !strings.Contains(wrong.Body.String(), "Wrong PIN") || len(wrong.Result().Cookies()) != 0 {
+		t.Errorf("Expected wrong PIN to be rejected")
+	}
+
+	right := doRequest(handler, http.MethodPost, "/login/pin/1", "", url.Values{"pin": {"1234"}})
+	if right.Code != http.StatusSeeOther {
+		t.Fatalf("Expected PIN login to redirect, got %d", right.Code)
+	}
+	var token string
+	for _, cookie := range right.Result().Cookies() {
+		if cookie.Name == "session_token" {
+			token = cookie.Value
+		}
+	}
+	if user, ok := authenticationFor(store).ValidateSession(t.Context(), token); !ok || user.ID != 1 {
+		t.Errorf("Expected PIN login to start a session for poul")
+	}
+}
+
+func TestPasswordResetEndsSessions(t *testing.T) {
+	handler, store, childToken, parentToken := setupTestServer(t)
+
+	// poul is also signed in on another device
+	authentication := authenticationFor(store)
+	_, otherToken, err := authentication.AuthenticateUser(t.Context(), "poul", "secret", "test")
+	if err != nil {
+		t.Fatalf("Failed to log in child again: %v", err)
+	}
+
+	rec := doRequest(handler, http.MethodPost, "/admin/users/1/password", parentToken, url.Values{"password": {"new-secret"}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected password reset to redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for _, token := range []string{childToken, otherToken} {
+		if _, ok := authentication.ValidateSession(t.Context(), token); ok {
+			t.Errorf("Expected poul's sessions to end when his password was reset")
+		}
+	}
+	if _, ok := authentication.ValidateSession(t.Context(), parentToken); !ok {
+		t.Errorf("Expected the parent's session to survive")
+	}
+}
+
+func TestPasswordValidated(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	for _, password := range []string{"", "short"} {
+		rec := doRequest(handler, http.MethodPost, "/admin/users/1/password", parentToken, url.Values{"password": {password}})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected password %q to be rejected, got %d", password, rec.Code)
+		}
+	}
+	rec := doRequest(handler, http.MethodPost, "/admin/users/999/password", parentToken, url.Values{"password": {"long-secret"}})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected resetting an unknown user's password to be not found, got %d", rec.Code)
+	}
+
+	rec = doRequest(handler, http.MethodPost, "/admin/users", parentToken, url.Values{"name": {"lille"}, "password": {"short"}, "role": {"child"}})
+	if !strings.Contains(rec.Body.String(), "The password must be at least 8 characters") {
+		t.Errorf("Expected a short password to be rejected on create, got %d", rec.Code)
+	}
+	var created int
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM users WHERE name = 'lille'").Scan(&created); err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if created != 0 {
+		t.Errorf("Expected no user with a short password to be created")
+	}
+}
+
+func TestUserChildrenValidated(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	if _, err := store.DB.Exec("INSERT INTO users (id, name, password, role, active) VALUES (4, 'mor', 'hash', 'parent', 1), (5, 'gammel', 'hash', 'child', 0)"); err != nil {
+		t.Fatalf("Failed to insert users: %v", err)
+	}
+	save := func(id int64, role string, children ...string) *httptest.ResponseRecorder {
+		form := url.Values{"name": {fmt.Sprintf("user%d", id)}, "is_admin": {"on"}, "role": {role}, "children": children}
+		return doRequest(handler, http.MethodPost, fmt.Sprintf("/admin/users/%d", id), parentToken, form)
+	}
+	childrenOf := func(id int64) int {
+		t.Helper()
+		var count int
+		if err := store.DB.QueryRow("SELECT COUNT(*) FROM user_children WHERE parent_id = ?", id).Scan(&count); err != nil {
+			t.Fatalf("Failed to count children: %v", err)
+		}
+		return count
+	}
+
+	// A parent, the user themselves and an inactive child can't be picked
+	for _, child := range []string{"4", "3", "5"} {
+		rec := save(3, "parent", "1", child)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Only other active children can be picked") {
+			t.Errorf("Expected child %s to be rejected, got %d", child, rec.Code)
+		}
+	}
+	if got := childrenOf(3); got != 2 {
+		t.Errorf("Expected the rejected saves to keep bagvendt's 2 children, got %d", got)
+	}
+
+	if rec := save(4, "parent", "1"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a valid child to be saved, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := childrenOf(4); got != 1 {
+		t.Errorf("Expected mor to have 1 child, got %d", got)
+	}
+
+	// Only parents have children
+	if rec := save(4, "child", "1"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the role change to be saved, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := childrenOf(4); got != 0 {
+		t.Errorf("Expected a child to have no children, got %d", got)
+	}
+}
+
+func TestLoginRateLimited(t *testing.T) {
+	handler, store, _, _ := setupTestServer(t)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		return doRequest(handler, http.MethodPost, "/login", "", url.Values{"username": {"poul"}, "password": {password}})
+	}
+
+	for i := 0; i < services.DefaultRateLimitConfig.FreeAttempts; i++ {
+		if rec := login("wrong"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected failed login %d to show the form again, got %d", i+1, rec.Code)
+		}
+	}
+
+	rec := login("wrong")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected login to be rate limited, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Too many failed login attempts") {
+		t.Errorf("Expected locked out message")
+	}
+
+	// Even the right password is refused while locked out
+	if rec := login("secret"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected correct password to be rate limited too, got %d", rec.Code)
+	}
+
+	attempts, err := database.GetLoginAttempts(t.Context(), store.DB, 100)
+	if err != nil {
+		t.Fatalf("Failed to load login attempts: %v", err)
+	}
+	if len(attempts) != services.DefaultRateLimitConfig.FreeAttempts+2 {
+		t.Errorf("Expected every rejected login to be audited, got %d", len(attempts))
+	}
+	if attempts[0].Reason != models.LoginRateLimited || attempts[0].Username != "poul" {
+		t.Errorf("Expected newest attempt to be rate limited for poul, got %+v", attempts[0])
+	}
+}
+
+func TestClientIPBehindFly(t *testing.T) {
+	handler, store, _, _ := setupTestServer(t)
+
+	limiter := services.NewLoginRateLimiter(services.DefaultRateLimitConfig, services.SystemClock{})
+	scheduler := services.NewScheduler(store.Routines, store.Blueprints, store.Users, services.SystemClock{}, time.Local)
+	behindFly := routes(store, limiter, scheduler, time.Local, true)
+
+	failLogin := func(handler http.Handler) string {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=poul&password=wrong"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Fly-Client-IP", "203.0.113.7")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		attempts, err := store.LoginAttempts.Recent(t.Context(), 1)
+		if err != nil || len(attempts) != 1 {
+			t.Fatalf("Failed to load the login attempt: %v", err)
+		}
+		return attempts[0].IP
+	}
+
+	// Without the proxy anyone could send the header, so the connection counts
+	if ip := failLogin(handler); ip != "192.0.2.1" {
+		t.Errorf("Expected the header to be ignored, got %q", ip)
+	}
+	if ip := failLogin(behindFly); ip != "203.0.113.7" {
+		t.Errorf("Expected the Fly proxy's client address, got %q", ip)
+	}
+}
+
+func TestCSRFRequired(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	send := func(method, path, body, contentType, csrfHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if csrfHeader != "" {
+			req.Header.Set("X-CSRF-Token", csrfHeader)
+		}
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: parentToken})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		contentType string
+		csrfHeader  string
+		forbidden   bool
+	}{
+		{"delete without token", http.MethodDelete, "/admin/chores/11", "", "", "", true},
+		{"delete with wrong token", http.MethodDelete, "/admin/chores/11", "", "", "wrong", true},
+		{"form without token", http.MethodPost, "/admin/chores", "name=Evil&default_points=1", "application/x-www-form-urlencoded", "", true},
+		{"api without token", http.MethodPost, "/api/routine/1/chore/1", `{"completed":true}`, "application/json", "", true},
+		{"form with token field", http.MethodPost, "/admin/chores", "name=Good&default_points=1&csrf_token=" + csrfTokenFor(handler, parentToken), "application/x-www-form-urlencoded", "", false},
+		{"delete with token header", http.MethodDelete, "/admin/chores/11", "", "", csrfTokenFor(handler, parentToken), false},
+		{"get without token", http.MethodGet, "/admin/chores", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := send(tt.method, tt.path, tt.body, tt.contentType, tt.csrfHeader)
+			if (rec.Code == http.StatusForbidden) != tt.forbidden {
+				t.Errorf("Expected forbidden to be %v, got %d: %s", tt.forbidden, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	var evil int
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM chores WHERE name = 'Evil'").Scan(&evil); err != nil {
+		t.Fatalf("Failed to count chores: %v", err)
+	}
+	if evil != 0 {
+		t.Errorf("Expected chore without CSRF token not to be created")
+	}
+
+	// Pages carry the token for HTMX and fetch
+	session, ok := authenticationFor(store).LookupSession(t.Context(), parentToken)
+	if !ok {
+		t.Fatalf("Expected the parent's session to be valid")
+	}
+	page := doRequest(handler, http.MethodGet, "/admin/chores", parentToken, nil)
+	if !strings.Contains(page.Body.String(), `<meta name="csrf-token" content="`+session.CSRFToken+`"`) {
+		t.Errorf("Expected page to include the CSRF token")
+	}
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	handler, _, _, _ := setupTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=poul&password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected a session cookie, got %d cookies", len(cookies))
+	}
+	cookie := cookies[0]
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Expected HttpOnly, Secure and SameSite=Lax, got %+v", cookie)
+	}
+}
+
+func TestSessionCookieSlides(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	sessionCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == "session_token" {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// A fresh session isn't extended, so the cookie isn't sent again
+	if cookie := sessionCookie(doRequest(handler, http.MethodGet, "/", parentToken, nil)); cookie != nil {
+		t.Errorf("Expected no cookie for a session that wasn't extended, got %+v", cookie)
+	}
+
+	// Once the session is extended the browser gets the cookie for the full TTL again
+	session, ok := authenticationFor(store).LookupSession(t.Context(), parentToken)
+	if !ok {
+		t.Fatalf("Expected the parent's session to be valid")
+	}
+	lastSeen := time.Now().Add(-time.Hour)
+	if err := store.Sessions.Touch(t.Context(), session.ID, lastSeen, lastSeen.Add(services.SessionTTL)); err != nil {
+		t.Fatalf("Failed to age session: %v", err)
+	}
+	cookie := sessionCookie(doRequest(handler, http.MethodGet, "/", parentToken, nil))
+	if cookie == nil || cookie.Value != parentToken || cookie.MaxAge != int(services.SessionTTL.Seconds()) {
+		t.Errorf("Expected the extended session's cookie to be sent again, got %+v", cookie)
+	}
+}
+
+func TestRoutineOwnership(t *testing.T) {
+	handler, store, childToken, parentToken := setupTestServer(t)
+
+	// ulla (2) owns a routine, poul (1) is her brother
+	result, err := store.DB.Exec("INSERT INTO routines (owner_id, routine_blueprint_id) VALUES (2, 1)")
+	if err != nil {
+		t.Fatalf("Failed to create routine: %v", err)
+	}
+	routineID, _ := result.LastInsertId()
+
+	api := func(token string, id int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/routine/%d/chore/1", id), strings.NewReader(`{"completed":true}`))
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		req.Header.Set("X-CSRF-Token", csrfTokenFor(handler, token))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		id       int64
+		expected int
+	}{
+		{"sibling", childToken, routineID, http.StatusForbidden},
+		{"parent", parentToken, routineID, http.StatusOK},
+		{"missing", parentToken, routineID + 100, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run("view "+tt.name, func(t *testing.T) {
+			rec := doRequest(handler, http.MethodGet, fmt.Sprintf("/routine/%d", tt.id), tt.token, nil)
+			if rec.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, rec.Code)
+			}
+		})
+		t.Run("complete "+tt.name, func(t *testing.T) {
+			rec := api(tt.token, tt.id)
+			if rec.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Only the parent's completion went through
+	var completedBy sql.NullInt64
+	if err := store.DB.QueryRow("SELECT completed_by FROM chore_routines WHERE routine_id = ? AND chore_id = 1", routineID).Scan(&completedBy); err != nil {
+		t.Fatalf("Failed to load chore routine: %v", err)
+	}
+	if completedBy.Int64 != 3 {
+		t.Errorf("Expected chore to be completed by the parent, got %v", completedBy)
+	}
+
+	// poul can't collect points for a chore that isn't part of his routine
+	result, err = store.DB.Exec("INSERT INTO routines (owner_id, routine_blueprint_id) VALUES (1, 1)")
+	if err != nil {
+		t.Fatalf("Failed to create routine: %v", err)
+	}
+	ownRoutineID, _ := result.LastInsertId()
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/routine/%d/chore/9", ownRoutineID), strings.NewReader(`{"completed":true}`))
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: childToken})
+	req.Header.Set("X-CSRF-Token", csrfTokenFor(handler, childToken))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a chore from another blueprint to be not found, got %d", rec.Code)
+	}
+	var stored int
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM chore_routines WHERE routine_id = ?", ownRoutineID).Scan(&stored); err != nil {
+		t.Fatalf("Failed to count chore routines: %v", err)
+	}
+	if stored != 0 {
+		t.Errorf("Expected no chore to be recorded, got %d", stored)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	// Mint a token for ulla (2)
+	rec := doRequest(handler, http.MethodPost, "/admin/tokens", parentToken, url.Values{"name": {"Kitchen tablet"}, "user_id": {"2"}})
+	token := regexp.MustCompile(services.APITokenPrefix + `[A-Za-z0-9_-]{43}`).FindString(rec.Body.String())
+	if token == "" {
+		t.Fatalf("Expected the new token to be shown, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	result, err := store.DB.Exec("INSERT INTO routines (owner_id, routine_blueprint_id) VALUES (2, 1)")
+	if err != nil {
+		t.Fatalf("Failed to create routine: %v", err)
+	}
+	routineID, _ := result.LastInsertId()
+
+	bearer := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"completed":true}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	apiPath := fmt.Sprintf("/api/routine/%d/chore/1", routineID)
+
+	// Bearer requests don't need a CSRF token
+	if rec := bearer(http.MethodPost, apiPath, token); rec.Code != http.StatusOK {
+		t.Fatalf("Expected API call with token to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var completedBy int64
+	if err := store.DB.QueryRow("SELECT completed_by FROM chore_routines WHERE routine_id = ?", routineID).Scan(&completedBy); err != nil {
+		t.Fatalf("Failed to load chore routine: %v", err)
+	}
+	if completedBy != 2 {
+		t.Errorf("Expected the token to act as its user, got %d", completedBy)
+	}
+
+	var lastUsed sql.NullString
+	if err := store.DB.QueryRow("SELECT last_used FROM api_tokens WHERE name = 'Kitchen tablet'").Scan(&lastUsed); err != nil {
+		t.Fatalf("Failed to load token: %v", err)
+	}
+	if !lastUsed.Valid {
+		t.Errorf("Expected last used to be recorded")
+	}
+
+	// Tokens only work for the API
+	if rec := bearer(http.MethodGet, "/admin/tokens", token); rec.Code != http.StatusSeeOther {
+		t.Errorf("Expected token to be ignored outside the API, got %d", rec.Code)
+	}
+	if rec := bearer(http.MethodPost, apiPath, token+"x"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unknown token to be rejected, got %d", rec.Code)
+	}
+
+	var tokenID int64
+	if err := store.DB.QueryRow("SELECT id FROM api_tokens WHERE name = 'Kitchen tablet'").Scan(&tokenID); err != nil {
+		t.Fatalf("Failed to load token: %v", err)
+	}
+	doRequest(handler, http.MethodPost, fmt.Sprintf("/admin/tokens/%d/revoke", tokenID), parentToken, nil)
+	if rec := bearer(http.MethodPost, apiPath, token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked token to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestBlueprintSchedule(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	rec := doRequest(handler, http.MethodPost, "/admin/blueprints/1", parentToken, url.Values{
+		"name": {"Morgen"}, "to_be_completed_by": {"08:00"}, "image": {"morning.avif"}, "chores": {"1"},
+		"recurrence": {"Weekly"}, "recurrence_interval": {"2"}, "weekdays": {"1", "4"},
+		"starts_on": {"2024-01-01"}, "ends_on": {"2024-06-30"},
+	})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after saving, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	blueprint, _, err := store.Blueprints.Get(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("Failed to load blueprint: %v", err)
+	}
+	want := schedule.Schedule{
+		Frequency: schedule.Weekly,
+		Interval:  2,
+		Weekdays:  schedule.WeekdaysOf(time.Monday, time.Thursday),
+		Start:     schedule.Date{Year: 2024, Month: time.January, Day: 1},
+		End:       schedule.Date{Year: 2024, Month: time.June, Day: 30},
+	}
+	if blueprint.Schedule != want {
+		t.Errorf("Expected schedule %+v, got %+v", want, blueprint.Schedule)
+	}
+
+	// An invalid schedule shows the form again and keeps the old one
+	rec = doRequest(handler, http.MethodPost, "/admin/blueprints/1", parentToken, url.Values{
+		"name": {"Morgen"}, "to_be_completed_by": {"08:00"}, "image": {"morning.avif"},
+		"recurrence": {"Monthly"}, "month_day": {"32"}, "starts_on": {"2024-01-01"},
+	})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Invalid schedule") {
+		t.Errorf("Expected the form with an error, got %d", rec.Code)
+	}
+	blueprint, _, err = store.Blueprints.Get(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("Failed to load blueprint: %v", err)
+	}
+	if blueprint.Schedule != want {
+		t.Errorf("Expected the schedule to be unchanged, got %+v", blueprint.Schedule)
+	}
+
+	// Without a start date the schedule starts today
+	doRequest(handler, http.MethodPost, "/admin/blueprints/1", parentToken, url.Values{
+		"name": {"Morgen"}, "to_be_completed_by": {"08:00"}, "image": {"morning.avif"}, "recurrence": {"Daily"},
+	})
+	blueprint, _, err = store.Blueprints.Get(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("Failed to load blueprint: %v", err)
+	}
+	if blueprint.Schedule.Start != schedule.DateOf(time.Now()) {
+		t.Errorf("Expected the schedule to start today, got %v", blueprint.Schedule.Start)
+	}
+}
+
+func TestBlueprintTimes(t *testing.T) {
+	handler, store, _, parentToken := setupTestServer(t)
+
+	form := func(from, by string) url.Values {
+		return url.Values{
+			"name": {"Morgen"}, "image": {"morning.avif"}, "recurrence": {"Daily"},
+			"available_from": {from}, "to_be_completed_by": {by}, "hide_when_overdue": {"on"},
+		}
+	}
+
+	rec := doRequest(handler, http.MethodPost, "/admin/blueprints/1", parentToken, form("06:30", "08:15"))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after saving, got %d: %s", rec.Code, rec.Body.String())
+	}
+	blueprint, _, err := store.Blueprints.Get(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("Failed to load blueprint: %v", err)
+	}
+	if blueprint.AvailableFrom == nil || *blueprint.AvailableFrom != schedule.NewTimeOfDay(6, 30) ||
+		blueprint.ToBeCompletedBy != schedule.NewTimeOfDay(8, 15) || !blueprint.HideWhenOverdue {
+		t.Errorf("Expected 06:30 to 08:15 hidden when overdue, got %v to %v hidden %v", blueprint.AvailableFrom, blueprint.ToBeCompletedBy, blueprint.HideWhenOverdue)
+	}
+
+	invalid := []struct {
+		name     string
+		from, by string
+	}{
+		{"missing deadline", "", ""},
+		{"not a time", "", "morning"},
+		{"window after deadline", "09:00", "08:00"},
+		{"empty window", "08:00", "08:00"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doRequest(handler, http.MethodPost, "/admin/blueprints/1", parentToken, form(tt.from, tt.by))
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Invalid times") {
+				t.Errorf("Expected the form with an error, got %d", rec.Code)
+			}
+			blueprint, _, err := store.Blueprints.Get(t.Context(), 1)
+			if err != nil {
+				t.Fatalf("Failed to load blueprint: %v", err)
+			}
+			if blueprint.ToBeCompletedBy != schedule.NewTimeOfDay(8, 15) {
+				t.Errorf("Expected the deadline to be unchanged, got %v", blueprint.ToBeCompletedBy)
+			}
+		})
+	}
+}
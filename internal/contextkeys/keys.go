@@ -5,3 +5,6 @@ type ContextKey string
 
 // UserContextKey is the key used to store the authenticated user in the request context.
 const UserContextKey = ContextKey("user")
+
+// CSRFTokenContextKey is the key used to store the session's CSRF token in the request context.
+const CSRFTokenContextKey = ContextKey("csrf_token")
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bagvendt/chores/internal/models"
+)
+
+// CreateAPIToken stores a new API token
+func CreateAPIToken(ctx context.Context, db *sql.DB, token *models.APIToken) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO api_tokens (created, user_id, name, token_hash)
+		VALUES (?, ?, ?, ?)
+	`,
+		now,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	token.ID = id
+	token.Created = now
+
+	return nil
+}
+
+// GetAPITokens returns all API tokens with their users, newest first
+func GetAPITokens(ctx context.Context, db *sql.DB) ([]models.APIToken, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.id, t.created, t.user_id, t.name, t.last_used, t.revoked, u.name
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		ORDER BY t.created DESC, t.id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var token models.APIToken
+		var user models.User
+
+		if err := rows.Scan(
+			&token.ID,
+			&token.Created,
+			&token.UserID,
+			&token.Name,
+			&token.LastUsed,
+			&token.Revoked,
+			&user.Name,
+		); err != nil {
+			return nil, err
+		}
+
+		user.ID = token.UserID
+		token.User = &user
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// GetAPITokenByHash returns the unrevoked token with the given hash along with
+// its active user, or nil if there is no such token
+func GetAPITokenByHash(ctx context.Context, db *sql.DB, tokenHash string) (*models.APIToken, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var token models.APIToken
+	var user models.User
+
+	err := db.QueryRowContext(ctx, `
+		SELECT t.id, t.created, t.user_id, t.name, t.last_used,
+		       u.id, u.name, u.is_admin, u.role, u.active
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.token_hash = ? AND t.revoked IS NULL AND u.active = 1
+	`, tokenHash).Scan(
+		&token.ID,
+		&token.Created,
+		&token.UserID,
+		&token.Name,
+		&token.LastUsed,
+		&user.ID,
+		&user.Name,
+		&user.IsAdmin,
+		&user.Role,
+		&user.Active,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token.TokenHash = tokenHash
+	token.User = &user
+
+	return &token, nil
+}
+
+// TouchAPIToken records when a token was last used
+func TouchAPIToken(ctx context.Context, db *sql.DB, id int64, lastUsed time.Time) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, "UPDATE api_tokens SET last_used = ? WHERE id = ?", models.NewTimestamp(lastUsed), id)
+	return err
+}
+
+// RevokeAPIToken revokes a token so it can no longer be used
+func RevokeAPIToken(ctx context.Context, db *sql.DB, id int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
+	_, err := db.ExecContext(ctx, "UPDATE api_tokens SET revoked = ? WHERE id = ? AND revoked IS NULL", now, id)
+	return err
+}
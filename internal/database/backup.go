@@ -0,0 +1,172 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// BackupKeepEnv names the environment variable with the number of backups to keep
+	BackupKeepEnv = "BACKUP_KEEP"
+	// DefaultBackupKeep is how many backups are kept when BACKUP_KEEP is unset
+	DefaultBackupKeep = 5
+
+	backupInfix      = ".backup-"
+	backupTimeFormat = "20060102T150405Z"
+)
+
+// BackupKeep returns the number of backups to keep from BACKUP_KEEP
+func BackupKeep() (int, error) {
+	value := os.Getenv(BackupKeepEnv)
+	if value == "" {
+		return DefaultBackupKeep, nil
+	}
+	keep, err := strconv.Atoi(value)
+	if err != nil || keep < 1 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive number", BackupKeepEnv, value)
+	}
+	return keep, nil
+}
+
+// Backup writes a consistent snapshot of the database next to dbPath, named
+// after the time it was taken, and returns the snapshot's path
+func Backup(db *sql.DB, dbPath string, now time.Time) (string, error) {
+	backupPath := dbPath + backupInfix + now.UTC().Format(backupTimeFormat)
+
+	// VACUUM INTO reads a single transaction, so writers can carry on
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return "", fmt.Errorf("error backing up database to %s: %w", backupPath, err)
+	}
+
+	return backupPath, nil
+}
+
+// ListBackups returns the backups of the database at dbPath, newest first
+func ListBackups(dbPath string) ([]string, error) {
+	backups, err := filepath.Glob(dbPath + backupInfix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	// The timestamps sort the same way as the names
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// PruneBackups deletes all but the newest keep backups of the database at dbPath
+func PruneBackups(dbPath string, keep int) error {
+	backups, err := ListBackups(dbPath)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > keep {
+		oldest := backups[len(backups)-1]
+		if err := os.Remove(oldest); err != nil {
+			return fmt.Errorf("error removing old backup %s: %w", oldest, err)
+		}
+		backups = backups[:len(backups)-1]
+	}
+
+	return nil
+}
+
+// Restore replaces the database at dbPath with the given backup. The server
+// must not be running. The current database is backed up first, and the
+// backup is checked and copied aside before it is swapped in with a rename,
+// so a failure at any point leaves a usable database behind
+func Restore(dbPath, backupPath string, now time.Time) (string, error) {
+	if err := checkIntegrity(backupPath); err != nil {
+		return "", fmt.Errorf("refusing to restore %s: %w", backupPath, err)
+	}
+
+	// Keep what is being replaced, in case the wrong backup was picked
+	var safetyPath string
+	if _, err := os.Stat(dbPath); err == nil {
+		current, err := sql.Open("sqlite3", dbPath)
+		if err != nil {
+			return "", err
+		}
+		safetyPath, err = Backup(current, dbPath, now)
+		// Closing the last connection also checkpoints and removes the WAL
+		current.Close()
+		if err != nil {
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	tmpPath := dbPath + ".restore"
+	if err := copyFile(backupPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("error copying backup: %w", err)
+	}
+
+	// Stale WAL and shared memory files belong to the old database
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmpPath)
+			return "", err
+		}
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("error swapping in backup: %w", err)
+	}
+
+	return safetyPath, nil
+}
+
+// checkIntegrity opens the SQLite file read only and runs an integrity check
+func checkIntegrity(dbPath string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
+
+// copyFile copies src to dst and syncs it to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openBackupTestDB creates a database file holding a single row
+func openBackupTestDB(t *testing.T) (*sql.DB, string) {
+	dbPath := filepath.Join(t.TempDir(), "chores.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec("CREATE TABLE names (name TEXT); INSERT INTO names VALUES ('before')"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	return db, dbPath
+}
+
+// readName returns the single name stored in the database at dbPath
+func readName(t *testing.T, dbPath string) string {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", dbPath, err)
+	}
+	defer db.Close()
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM names").Scan(&name); err != nil {
+		t.Fatalf("Failed to read %s: %v", dbPath, err)
+	}
+	return name
+}
+
+func TestBackupAndPrune(t *testing.T) {
+	db, dbPath := openBackupTestDB(t)
+	start := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+
+	var created []string
+	for i := 0; i < 4; i++ {
+		backupPath, err := Backup(db, dbPath, start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to back up: %v", err)
+		}
+		created = append(created, backupPath)
+	}
+
+	if want := dbPath + ".backup-20261017T060000Z"; created[0] != want {
+		t.Errorf("Expected backup at %s, got %s", want, created[0])
+	}
+	if name := readName(t, created[0]); name != "before" {
+		t.Errorf("Expected backup to contain the data, got %q", name)
+	}
+
+	if err := PruneBackups(dbPath, 2); err != nil {
+		t.Fatalf("Failed to prune backups: %v", err)
+	}
+	backups, err := ListBackups(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 2 || backups[0] != created[3] || backups[1] != created[2] {
+		t.Errorf("Expected the two newest backups to be kept, got %v", backups)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	db, dbPath := openBackupTestDB(t)
+	now := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+
+	backupPath, err := Backup(db, dbPath, now)
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if _, err := db.Exec("UPDATE names SET name = 'after'"); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	db.Close()
+
+	safetyPath, err := Restore(dbPath, backupPath, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	if name := readName(t, dbPath); name != "before" {
+		t.Errorf("Expected restored database to contain %q, got %q", "before", name)
+	}
+	if name := readName(t, safetyPath); name != "after" {
+		t.Errorf("Expected the replaced database to be kept, got %q", name)
+	}
+}
+
+func TestRestoreRejectsInvalidBackup(t *testing.T) {
+	db, dbPath := openBackupTestDB(t)
+	db.Close()
+
+	bogus := dbPath + ".backup-bogus"
+	if err := os.WriteFile(bogus, []byte("not a database"), 0o644); err != nil {
+		t.Fatalf("Failed to write bogus backup: %v", err)
+	}
+
+	if _, err := Restore(dbPath, bogus, time.Now()); err == nil {
+		t.Fatalf("Expected restoring an invalid backup to fail")
+	}
+	if _, err := Restore(dbPath, dbPath+".missing", time.Now()); err == nil {
+		t.Fatalf("Expected restoring a missing backup to fail")
+	}
+
+	// The database is left alone
+	if name := readName(t, dbPath); name != "before" {
+		t.Errorf("Expected database to be untouched, got %q", name)
+	}
+}
@@ -1,16 +1,22 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
 	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
 )
 
 // GetBlueprints returns all routine blueprints
-func GetBlueprints(db *sql.DB) ([]models.RoutineBlueprint, error) {
-	rows, err := db.Query(`
-		SELECT id, created, modified, name, to_be_completed_by, allow_multiple_instances_per_day, recurrence, image
+func GetBlueprints(ctx context.Context, db *sql.DB) ([]models.RoutineBlueprint, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, created, modified, name, available_from, to_be_completed_by, hide_when_overdue, allow_multiple_instances_per_day,
+		       recurrence, recurrence_interval, weekdays, month_day, starts_on, ends_on, image
 		FROM routine_blueprints
 		ORDER BY created DESC
 	`)
@@ -22,25 +28,29 @@ func GetBlueprints(db *sql.DB) ([]models.RoutineBlueprint, error) {
 	var blueprints []models.RoutineBlueprint
 	for rows.Next() {
 		var blueprint models.RoutineBlueprint
-		var createdStr, modifiedStr string
-		var recurrence string
+		var recurrence sql.NullString
 
 		if err := rows.Scan(
 			&blueprint.ID,
-			&createdStr,
-			&modifiedStr,
+			&blueprint.Created,
+			&blueprint.Modified,
 			&blueprint.Name,
+			&blueprint.AvailableFrom,
 			&blueprint.ToBeCompletedBy,
+			&blueprint.HideWhenOverdue,
 			&blueprint.AllowMultipleInstancesPerDay,
 			&recurrence,
+			&blueprint.Schedule.Interval,
+			&blueprint.Schedule.Weekdays,
+			&blueprint.Schedule.MonthDay,
+			&blueprint.Schedule.Start,
+			&blueprint.Schedule.End,
 			&blueprint.Image,
 		); err != nil {
 			return nil, err
 		}
 
-		blueprint.Created, _ = time.Parse(time.RFC3339, createdStr)
-		blueprint.Modified, _ = time.Parse(time.RFC3339, modifiedStr)
-		blueprint.Recurrence = models.RecurrenceType(recurrence)
+		blueprint.Schedule.Frequency = schedule.Frequency(recurrence.String)
 
 		blueprints = append(blueprints, blueprint)
 	}
@@ -49,36 +59,44 @@ func GetBlueprints(db *sql.DB) ([]models.RoutineBlueprint, error) {
 }
 
 // GetBlueprint returns a single routine blueprint by ID along with its chores
-func GetBlueprint(db *sql.DB, id int64) (*models.RoutineBlueprint, []models.RoutineBlueprintChore, error) {
+func GetBlueprint(ctx context.Context, db *sql.DB, id int64) (*models.RoutineBlueprint, []models.RoutineBlueprintChore, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	// Get the blueprint
 	var blueprint models.RoutineBlueprint
-	var createdStr, modifiedStr string
-	var recurrence string
+	var recurrence sql.NullString
 
-	err := db.QueryRow(`
-		SELECT id, created, modified, name, to_be_completed_by, allow_multiple_instances_per_day, recurrence, image
+	err := db.QueryRowContext(ctx, `
+		SELECT id, created, modified, name, available_from, to_be_completed_by, hide_when_overdue, allow_multiple_instances_per_day,
+		       recurrence, recurrence_interval, weekdays, month_day, starts_on, ends_on, image
 		FROM routine_blueprints
 		WHERE id = ?
 	`, id).Scan(
 		&blueprint.ID,
-		&createdStr,
-		&modifiedStr,
+		&blueprint.Created,
+		&blueprint.Modified,
 		&blueprint.Name,
+		&blueprint.AvailableFrom,
 		&blueprint.ToBeCompletedBy,
+		&blueprint.HideWhenOverdue,
 		&blueprint.AllowMultipleInstancesPerDay,
 		&recurrence,
+		&blueprint.Schedule.Interval,
+		&blueprint.Schedule.Weekdays,
+		&blueprint.Schedule.MonthDay,
+		&blueprint.Schedule.Start,
+		&blueprint.Schedule.End,
 		&blueprint.Image,
 	)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	blueprint.Created, _ = time.Parse(time.RFC3339, createdStr)
-	blueprint.Modified, _ = time.Parse(time.RFC3339, modifiedStr)
-	blueprint.Recurrence = models.RecurrenceType(recurrence)
+	blueprint.Schedule.Frequency = schedule.Frequency(recurrence.String)
 
 	// Get the chores for this blueprint
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(ctx, `
 		SELECT 
 			rbc.id, rbc.created, rbc.modified, rbc.routine_blueprint_id, rbc.chore_id,
 			c.id, c.name, c.default_points, c.image
@@ -94,14 +112,13 @@ func GetBlueprint(db *sql.DB, id int64) (*models.RoutineBlueprint, []models.Rout
 	var chores []models.RoutineBlueprintChore
 	for rows.Next() {
 		var chore models.RoutineBlueprintChore
-		var choreCreatedStr, choreModifiedStr string
 		var choreObj models.Chore
 		var choreObjImage sql.NullString
 
 		if err := rows.Scan(
 			&chore.ID,
-			&choreCreatedStr,
-			&choreModifiedStr,
+			&chore.Created,
+			&chore.Modified,
 			&chore.RoutineBlueprintID,
 			&chore.ChoreID,
 			&choreObj.ID,
@@ -112,8 +129,6 @@ func GetBlueprint(db *sql.DB, id int64) (*models.RoutineBlueprint, []models.Rout
 			return nil, nil, err
 		}
 
-		chore.Created, _ = time.Parse(time.RFC3339, choreCreatedStr)
-		chore.Modified, _ = time.Parse(time.RFC3339, choreModifiedStr)
 		if choreObjImage.Valid {
 			choreObj.Image = choreObjImage.String
 		} else {
@@ -129,8 +144,11 @@ func GetBlueprint(db *sql.DB, id int64) (*models.RoutineBlueprint, []models.Rout
 }
 
 // GetBlueprintChores retrieves all chores associated with a blueprint
-func GetBlueprintChores(db *sql.DB, blueprintID int64) ([]models.RoutineBlueprintChore, error) {
-	rows, err := db.Query(`
+func GetBlueprintChores(ctx context.Context, db *sql.DB, blueprintID int64) ([]models.RoutineBlueprintChore, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
 		SELECT 
 			rbc.id, rbc.created, rbc.modified, rbc.routine_blueprint_id, rbc.chore_id,
 			c.id, c.name, c.default_points, c.image
@@ -146,14 +164,13 @@ func GetBlueprintChores(db *sql.DB, blueprintID int64) ([]models.RoutineBlueprin
 	var chores []models.RoutineBlueprintChore
 	for rows.Next() {
 		var chore models.RoutineBlueprintChore
-		var choreCreatedStr, choreModifiedStr string
 		var choreObj models.Chore
 		var choreObjImage sql.NullString
 
 		if err := rows.Scan(
 			&chore.ID,
-			&choreCreatedStr,
-			&choreModifiedStr,
+			&chore.Created,
+			&chore.Modified,
 			&chore.RoutineBlueprintID,
 			&chore.ChoreID,
 			&choreObj.ID,
@@ -164,8 +181,6 @@ func GetBlueprintChores(db *sql.DB, blueprintID int64) ([]models.RoutineBlueprin
 			return nil, err
 		}
 
-		chore.Created, _ = time.Parse(time.RFC3339, choreCreatedStr)
-		chore.Modified, _ = time.Parse(time.RFC3339, choreModifiedStr)
 		if choreObjImage.Valid {
 			choreObj.Image = choreObjImage.String
 		} else {
@@ -181,26 +196,48 @@ func GetBlueprintChores(db *sql.DB, blueprintID int64) ([]models.RoutineBlueprin
 }
 
 // CreateBlueprint creates a new routine blueprint
-func CreateBlueprint(db *sql.DB, blueprint *models.RoutineBlueprint, choreIDs []int64) error {
-	tx, err := db.Begin()
+func CreateBlueprint(ctx context.Context, db *sql.DB, blueprint *models.RoutineBlueprint, choreIDs []int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	result, err := tx.Exec(`
+	now := models.NewTimestamp(time.Now())
+	result, err := tx.ExecContext(ctx, `
 		INSERT INTO routine_blueprints (
+			created,
+			modified,
 			name,
-			to_be_completed_by, 
+			available_from,
+			to_be_completed_by,
+			hide_when_overdue,
 			allow_multiple_instances_per_day,
 			recurrence,
+			recurrence_interval,
+			weekdays,
+			month_day,
+			starts_on,
+			ends_on,
 			image
-		) VALUES (?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
+		now,
+		now,
 		blueprint.Name,
+		blueprint.AvailableFrom,
 		blueprint.ToBeCompletedBy,
+		blueprint.HideWhenOverdue,
 		blueprint.AllowMultipleInstancesPerDay,
-		string(blueprint.Recurrence),
+		sql.NullString{String: string(blueprint.Schedule.Frequency), Valid: blueprint.Schedule.Frequency != ""},
+		max(blueprint.Schedule.Interval, 1),
+		blueprint.Schedule.Weekdays,
+		blueprint.Schedule.MonthDay,
+		blueprint.Schedule.Start,
+		blueprint.Schedule.End,
 		blueprint.Image,
 	)
 	if err != nil {
@@ -212,15 +249,21 @@ func CreateBlueprint(db *sql.DB, blueprint *models.RoutineBlueprint, choreIDs []
 		return err
 	}
 	blueprint.ID = blueprintID
+	blueprint.Created = now
+	blueprint.Modified = now
 
 	// Add chores to the blueprint
 	for _, choreID := range choreIDs {
-		_, err = tx.Exec(`
+		_, err = tx.ExecContext(ctx, `
 			INSERT INTO routine_blueprint_chores (
+				created,
+				modified,
 				routine_blueprint_id,
 				chore_id
-			) VALUES (?, ?)
+			) VALUES (?, ?, ?, ?)
 		`,
+			now,
+			now,
 			blueprintID,
 			choreID,
 		)
@@ -233,49 +276,73 @@ func CreateBlueprint(db *sql.DB, blueprint *models.RoutineBlueprint, choreIDs []
 }
 
 // UpdateBlueprint updates an existing routine blueprint
-func UpdateBlueprint(db *sql.DB, blueprint *models.RoutineBlueprint, choreIDs []int64) error {
-	tx, err := db.Begin()
+func UpdateBlueprint(ctx context.Context, db *sql.DB, blueprint *models.RoutineBlueprint, choreIDs []int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
 	// Update blueprint
-	_, err = tx.Exec(`
+	now := models.NewTimestamp(time.Now())
+	_, err = tx.ExecContext(ctx, `
 		UPDATE routine_blueprints
 		SET name = ?,
+			available_from = ?,
 			to_be_completed_by = ?,
+			hide_when_overdue = ?,
 			allow_multiple_instances_per_day = ?,
 			recurrence = ?,
+			recurrence_interval = ?,
+			weekdays = ?,
+			month_day = ?,
+			starts_on = ?,
+			ends_on = ?,
 			image = ?,
-			modified = CURRENT_TIMESTAMP
+			modified = ?
 		WHERE id = ?
 	`,
 		blueprint.Name,
+		blueprint.AvailableFrom,
 		blueprint.ToBeCompletedBy,
+		blueprint.HideWhenOverdue,
 		blueprint.AllowMultipleInstancesPerDay,
-		string(blueprint.Recurrence),
+		sql.NullString{String: string(blueprint.Schedule.Frequency), Valid: blueprint.Schedule.Frequency != ""},
+		max(blueprint.Schedule.Interval, 1),
+		blueprint.Schedule.Weekdays,
+		blueprint.Schedule.MonthDay,
+		blueprint.Schedule.Start,
+		blueprint.Schedule.End,
 		blueprint.Image,
+		now,
 		blueprint.ID,
 	)
 	if err != nil {
 		return err
 	}
+	blueprint.Modified = now
 
 	// Remove existing chores
-	_, err = tx.Exec(`DELETE FROM routine_blueprint_chores WHERE routine_blueprint_id = ?`, blueprint.ID)
+	_, err = tx.ExecContext(ctx, `DELETE FROM routine_blueprint_chores WHERE routine_blueprint_id = ?`, blueprint.ID)
 	if err != nil {
 		return err
 	}
 
 	// Add new chores
 	for _, choreID := range choreIDs {
-		_, err = tx.Exec(`
+		_, err = tx.ExecContext(ctx, `
 			INSERT INTO routine_blueprint_chores (
+				created,
+				modified,
 				routine_blueprint_id,
 				chore_id
-			) VALUES (?, ?)
+			) VALUES (?, ?, ?, ?)
 		`,
+			now,
+			now,
 			blueprint.ID,
 			choreID,
 		)
@@ -287,24 +354,28 @@ func UpdateBlueprint(db *sql.DB, blueprint *models.RoutineBlueprint, choreIDs []
 	return tx.Commit()
 }
 
-// DeleteBlueprint deletes a routine blueprint and its associated chores
-func DeleteBlueprint(db *sql.DB, id int64) error {
-	tx, err := db.Begin()
+// DeleteBlueprint deletes a routine blueprint and its associated chores.
+// It returns ErrInUse if routines were created from it
+func DeleteBlueprint(ctx context.Context, db *sql.DB, id int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
 	// Delete associated chores first
-	_, err = tx.Exec(`DELETE FROM routine_blueprint_chores WHERE routine_blueprint_id = ?`, id)
+	_, err = tx.ExecContext(ctx, `DELETE FROM routine_blueprint_chores WHERE routine_blueprint_id = ?`, id)
 	if err != nil {
 		return err
 	}
 
 	// Delete the blueprint
-	_, err = tx.Exec(`DELETE FROM routine_blueprints WHERE id = ?`, id)
+	_, err = tx.ExecContext(ctx, `DELETE FROM routine_blueprints WHERE id = ?`, id)
 	if err != nil {
-		return err
+		return inUse(err)
 	}
 
 	return tx.Commit()
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bagvendt/chores"
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
+)
+
+func TestBlueprintScheduleRoundTrip(t *testing.T) {
+	store := openStore(t)
+
+	blueprint := &models.RoutineBlueprint{
+		Name:            "Bins",
+		ToBeCompletedBy: schedule.NewTimeOfDay(18, 0),
+		Image:           "bins.avif",
+		Schedule: schedule.Schedule{
+			Frequency: schedule.Monthly,
+			Interval:  3,
+			MonthDay:  31,
+			Start:     schedule.Date{Year: 2024, Month: time.January, Day: 31},
+			End:       schedule.Date{Year: 2025, Month: time.December, Day: 31},
+		},
+	}
+	if err := store.Blueprints.Create(t.Context(), blueprint, nil); err != nil {
+		t.Fatalf("Failed to create blueprint: %v", err)
+	}
+
+	saved, _, err := store.Blueprints.Get(t.Context(), blueprint.ID)
+	if err != nil {
+		t.Fatalf("Failed to get blueprint: %v", err)
+	}
+	if saved.Schedule != blueprint.Schedule {
+		t.Errorf("Expected schedule %+v, got %+v", blueprint.Schedule, saved.Schedule)
+	}
+
+	// A blueprint without a schedule stores NULL and comes back empty
+	saved.Schedule = schedule.Schedule{}
+	if err := store.Blueprints.Update(t.Context(), saved, nil); err != nil {
+		t.Fatalf("Failed to update blueprint: %v", err)
+	}
+	saved, _, err = store.Blueprints.Get(t.Context(), blueprint.ID)
+	if err != nil {
+		t.Fatalf("Failed to get blueprint: %v", err)
+	}
+	if saved.Schedule.Frequency != "" || !saved.Schedule.Start.IsZero() {
+		t.Errorf("Expected no schedule, got %+v", saved.Schedule)
+	}
+}
+
+func TestBlueprintScheduleMigration(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, chores.Migrations())
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := manager.Rollback(10); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+
+	// 2024-01-03 is a Wednesday
+	_, err := db.Exec(`
+		INSERT INTO routine_blueprints (id, created, to_be_completed_by, name, image, recurrence) VALUES
+			(100, '2024-01-03T07:00:00Z', '08:00', 'Daily', '', 'Daily'),
+			(101, '2024-01-03T07:00:00Z', '08:00', 'Weekday', '', 'Weekday'),
+			(102, '2024-01-03T07:00:00Z', '08:00', 'Weekly', '', 'Weekly'),
+			(103, '2024-01-03T07:00:00Z', '08:00', 'None', '', NULL);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to insert old blueprints: %v", err)
+	}
+
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to re-run migrations: %v", err)
+	}
+
+	start := schedule.Date{Year: 2024, Month: time.January, Day: 3}
+	want := map[int64]schedule.Schedule{
+		100: {Frequency: schedule.Daily, Interval: 1, Start: start},
+		101: {Frequency: schedule.Weekly, Interval: 1, Weekdays: schedule.WorkDays, Start: start},
+		102: {Frequency: schedule.Weekly, Interval: 1, Weekdays: schedule.WeekdaysOf(time.Wednesday), Start: start},
+		103: {Interval: 1},
+	}
+	for id, sched := range want {
+		blueprint, _, err := GetBlueprint(t.Context(), db, id)
+		if err != nil {
+			t.Fatalf("Failed to get blueprint %d: %v", id, err)
+		}
+		if blueprint.Schedule != sched {
+			t.Errorf("Expected blueprint %d to have schedule %+v, got %+v", id, sched, blueprint.Schedule)
+		}
+	}
+
+	// Going back restores the old recurrence values
+	if err := manager.Rollback(10); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	var recurrence string
+	if err := db.QueryRow("SELECT recurrence FROM routine_blueprints WHERE id = 101").Scan(&recurrence); err != nil {
+		t.Fatalf("Failed to read recurrence: %v", err)
+	}
+	if recurrence != "Weekday" {
+		t.Errorf("Expected Weekday after rolling back, got %q", recurrence)
+	}
+}
+
+func TestBlueprintDeadlinesMigration(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, chores.Migrations())
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := manager.Rollback(11); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+
+	want := map[string]string{
+		"08:00:00":  "08:00",
+		"17:30":     "17:30",
+		"7:15":      "07:15",
+		" 9:00 ":    "09:00",
+		"Morning":   "08:00",
+		"lunch":     "12:00",
+		"afternoon": "15:00",
+		"dinner":    "18:00",
+		"bedtime":   "20:00",
+		"whenever":  "23:59",
+	}
+	ids := make(map[int64]string)
+	for old := range want {
+		result, err := db.Exec("INSERT INTO routine_blueprints (to_be_completed_by, name, image) VALUES (?, ?, '')", old, old)
+		if err != nil {
+			t.Fatalf("Failed to insert blueprint: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		ids[id] = old
+	}
+
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to re-run migrations: %v", err)
+	}
+
+	for id, old := range ids {
+		blueprint, _, err := GetBlueprint(t.Context(), db, id)
+		if err != nil {
+			t.Fatalf("Failed to get blueprint for %q: %v", old, err)
+		}
+		if got := blueprint.ToBeCompletedBy.String(); got != want[old] {
+			t.Errorf("Expected %q to become %s, got %s", old, want[old], got)
+		}
+		if blueprint.AvailableFrom != nil || blueprint.HideWhenOverdue {
+			t.Errorf("Expected no window and overdue routines shown, got %v and %v", blueprint.AvailableFrom, blueprint.HideWhenOverdue)
+		}
+	}
+}
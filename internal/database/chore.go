@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -8,8 +9,11 @@ import (
 )
 
 // GetChores returns all chores from the database
-func GetChores(db *sql.DB) ([]models.Chore, error) {
-	rows, err := db.Query(`
+func GetChores(ctx context.Context, db *sql.DB) ([]models.Chore, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
 		SELECT id, created, modified, name, default_points, image
 		FROM chores
 		ORDER BY name
@@ -22,13 +26,12 @@ func GetChores(db *sql.DB) ([]models.Chore, error) {
 	var chores []models.Chore
 	for rows.Next() {
 		var chore models.Chore
-		var createdStr, modifiedStr string
 		var image sql.NullString
 
 		if err := rows.Scan(
 			&chore.ID,
-			&createdStr,
-			&modifiedStr,
+			&chore.Created,
+			&chore.Modified,
 			&chore.Name,
 			&chore.DefaultPoints,
 			&image,
@@ -36,8 +39,6 @@ func GetChores(db *sql.DB) ([]models.Chore, error) {
 			return nil, err
 		}
 
-		chore.Created, _ = time.Parse(time.RFC3339, createdStr)
-		chore.Modified, _ = time.Parse(time.RFC3339, modifiedStr)
 		if image.Valid {
 			chore.Image = image.String
 		}
@@ -49,19 +50,21 @@ func GetChores(db *sql.DB) ([]models.Chore, error) {
 }
 
 // GetChore returns a chore by ID
-func GetChore(db *sql.DB, id int64) (*models.Chore, error) {
+func GetChore(ctx context.Context, db *sql.DB, id int64) (*models.Chore, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	var chore models.Chore
-	var createdStr, modifiedStr string
 	var image sql.NullString
 
-	err := db.QueryRow(`
+	err := db.QueryRowContext(ctx, `
 		SELECT id, created, modified, name, default_points, image
 		FROM chores
 		WHERE id = ?
 	`, id).Scan(
 		&chore.ID,
-		&createdStr,
-		&modifiedStr,
+		&chore.Created,
+		&chore.Modified,
 		&chore.Name,
 		&chore.DefaultPoints,
 		&image,
@@ -70,8 +73,6 @@ func GetChore(db *sql.DB, id int64) (*models.Chore, error) {
 		return nil, err
 	}
 
-	chore.Created, _ = time.Parse(time.RFC3339, createdStr)
-	chore.Modified, _ = time.Parse(time.RFC3339, modifiedStr)
 	if image.Valid {
 		chore.Image = image.String
 	}
@@ -80,9 +81,12 @@ func GetChore(db *sql.DB, id int64) (*models.Chore, error) {
 }
 
 // CreateChore creates a new chore in the database
-func CreateChore(db *sql.DB, chore *models.Chore) error {
-	now := time.Now().UTC().Format(time.RFC3339)
-	result, err := db.Exec(`
+func CreateChore(ctx context.Context, db *sql.DB, chore *models.Chore) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
+	result, err := db.ExecContext(ctx, `
 		INSERT INTO chores (created, modified, name, default_points, image)
 		VALUES (?, ?, ?, ?, ?)
 	`,
@@ -102,16 +106,19 @@ func CreateChore(db *sql.DB, chore *models.Chore) error {
 	}
 
 	chore.ID = id
-	chore.Created, _ = time.Parse(time.RFC3339, now)
-	chore.Modified = chore.Created
+	chore.Created = now
+	chore.Modified = now
 
 	return nil
 }
 
 // UpdateChore updates an existing chore in the database
-func UpdateChore(db *sql.DB, chore *models.Chore) error {
-	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := db.Exec(`
+func UpdateChore(ctx context.Context, db *sql.DB, chore *models.Chore) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
+	_, err := db.ExecContext(ctx, `
 		UPDATE chores
 		SET modified = ?, name = ?, default_points = ?, image = ?
 		WHERE id = ?
@@ -126,50 +133,62 @@ func UpdateChore(db *sql.DB, chore *models.Chore) error {
 		return err
 	}
 
-	chore.Modified, _ = time.Parse(time.RFC3339, now)
+	chore.Modified = now
 	return nil
 }
 
-// DeleteChore deletes a chore from the database
-func DeleteChore(db *sql.DB, id int64) error {
-	_, err := db.Exec("DELETE FROM chores WHERE id = ?", id)
-	return err
+// DeleteChore deletes a chore from the database. It returns ErrInUse if
+// a blueprint or routine still has the chore
+func DeleteChore(ctx context.Context, db *sql.DB, id int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, "DELETE FROM chores WHERE id = ?", id)
+	return inUse(err)
 }
 
 // UpsertChoreRoutine creates or updates a chore routine
 // It takes a routine ID, chore ID, and a completed flag
 // If the record exists, it updates the completion status
 // If it doesn't exist, it creates a new record
-func UpsertChoreRoutine(db *sql.DB, routineID int64, choreID int64, completed bool, userID int64) (*models.ChoreRoutine, error) {
+// The check and the write share a transaction, so two tablets ticking off
+// the same chore at once can't both insert it
+func UpsertChoreRoutine(ctx context.Context, db *sql.DB, routineID int64, choreID int64, completed bool, userID int64) (*models.ChoreRoutine, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	// First check if the record exists
 	var choreRoutine models.ChoreRoutine
-	var createdStr, modifiedStr string
-	var completedAtStr sql.NullString
 	var completedBy sql.NullInt64
 
-	err := db.QueryRow(`
+	err = tx.QueryRowContext(ctx, `
 		SELECT id, created, modified, completed_at, completed_by, points_awarded, routine_id, chore_id
 		FROM chore_routines
 		WHERE routine_id = ? AND chore_id = ?
 	`, routineID, choreID).Scan(
 		&choreRoutine.ID,
-		&createdStr,
-		&modifiedStr,
-		&completedAtStr,
+		&choreRoutine.Created,
+		&choreRoutine.Modified,
+		&choreRoutine.CompletedAt,
 		&completedBy,
 		&choreRoutine.PointsAwarded,
 		&choreRoutine.RoutineID,
 		&choreRoutine.ChoreID,
 	)
 
-	now := time.Now().UTC()
-	nowStr := now.Format(time.RFC3339)
+	now := models.NewTimestamp(time.Now())
 
 	// If record doesn't exist, create it
 	if err == sql.ErrNoRows {
 		// Get the default points from the chore
 		var defaultPoints int
-		err := db.QueryRow("SELECT default_points FROM chores WHERE id = ?", choreID).Scan(&defaultPoints)
+		err := tx.QueryRowContext(ctx, "SELECT default_points FROM chores WHERE id = ?", choreID).Scan(&defaultPoints)
 		if err != nil {
 			return nil, err
 		}
@@ -177,22 +196,22 @@ func UpsertChoreRoutine(db *sql.DB, routineID int64, choreID int64, completed bo
 		// Set completedAt and completedBy based on the completed flag
 		var completedAtParam, completedByParam interface{}
 		if completed {
-			completedAtParam = nowStr
+			completedAtParam = now
 			completedByParam = userID
 		} else {
 			completedAtParam = nil
 			completedByParam = nil
 		}
 
-		result, err := db.Exec(`
+		result, err := tx.ExecContext(ctx, `
 			INSERT INTO chore_routines (
 				created, modified, completed_at, completed_by, 
 				points_awarded, routine_id, chore_id
 			)
 			VALUES (?, ?, ?, ?, ?, ?, ?)
 		`,
-			nowStr,
-			nowStr,
+			now,
+			now,
 			completedAtParam,
 			completedByParam,
 			defaultPoints,
@@ -222,20 +241,17 @@ func UpsertChoreRoutine(db *sql.DB, routineID int64, choreID int64, completed bo
 			choreRoutine.CompletedByID = &userID
 		}
 
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
 		return &choreRoutine, nil
 	} else if err != nil {
 		return nil, err
 	}
 
 	// Record exists, update it
-	choreRoutine.Created, _ = time.Parse(time.RFC3339, createdStr)
 	choreRoutine.Modified = now
 
-	if completedAtStr.Valid {
-		completedAt, _ := time.Parse(time.RFC3339, completedAtStr.String)
-		choreRoutine.CompletedAt = &completedAt
-	}
-
 	if completedBy.Valid {
 		choreRoutine.CompletedByID = &completedBy.Int64
 	}
@@ -245,19 +261,19 @@ func UpsertChoreRoutine(db *sql.DB, routineID int64, choreID int64, completed bo
 	if completed != isCurrentlyCompleted {
 		var completedAtParam, completedByParam interface{}
 		if completed {
-			completedAtParam = nowStr
+			completedAtParam = now
 			completedByParam = userID
 		} else {
 			completedAtParam = nil
 			completedByParam = nil
 		}
 
-		_, err := db.Exec(`
+		_, err := tx.ExecContext(ctx, `
 			UPDATE chore_routines
 			SET modified = ?, completed_at = ?, completed_by = ?
 			WHERE id = ?
 		`,
-			nowStr,
+			now,
 			completedAtParam,
 			completedByParam,
 			choreRoutine.ID,
@@ -276,5 +292,71 @@ func UpsertChoreRoutine(db *sql.DB, routineID int64, choreID int64, completed bo
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return &choreRoutine, nil
 }
+
+// GetChoreRoutines returns the chore routines of a routine with their chores
+func GetChoreRoutines(ctx context.Context, db *sql.DB, routineID int64) ([]models.ChoreRoutine, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT 
+			cr.id, cr.created, cr.modified, cr.completed_at, cr.completed_by, 
+			cr.points_awarded, cr.routine_id, cr.chore_id,
+			c.id, c.name, c.default_points, c.image
+		FROM chore_routines cr
+		JOIN chores c ON cr.chore_id = c.id
+		WHERE cr.routine_id = ?
+		ORDER BY cr.id
+	`, routineID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var choreRoutines []models.ChoreRoutine
+	for rows.Next() {
+		var cr models.ChoreRoutine
+		var completedByID sql.NullInt64
+		var chore models.Chore
+		var image sql.NullString
+
+		if err := rows.Scan(
+			&cr.ID,
+			&cr.Created,
+			&cr.Modified,
+			&cr.CompletedAt,
+			&completedByID,
+			&cr.PointsAwarded,
+			&cr.RoutineID,
+			&cr.ChoreID,
+			&chore.ID,
+			&chore.Name,
+			&chore.DefaultPoints,
+			&image,
+		); err != nil {
+			return nil, err
+		}
+
+		// Handle nullable fields
+		if completedByID.Valid {
+			id := completedByID.Int64
+			cr.CompletedByID = &id
+		}
+
+		if image.Valid {
+			chore.Image = image.String
+		}
+
+		// Set the joined chore
+		cr.Chore = &chore
+
+		choreRoutines = append(choreRoutines, cr)
+	}
+
+	return choreRoutines, rows.Err()
+}
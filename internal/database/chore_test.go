@@ -1,35 +1,25 @@
 package database
 
 import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/bagvendt/chores"
 	"github.com/bagvendt/chores/internal/models"
 )
 
 func TestChoreOperations(t *testing.T) {
-	// Setup test database
-	db, _, cleanup := setupTestDB(t)
-	defer cleanup()
-
-	// Replace the global DB with our test DB
-	originalDB := DB
-	DB = db
-	defer func() { DB = originalDB }()
-
-	// Create the chores table for testing
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS chores (
-			id INTEGER PRIMARY KEY,
-			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			name TEXT NOT NULL,
-			default_points INTEGER NOT NULL CHECK (default_points > 0),
-			image TEXT
-		);
-	`)
-	if err != nil {
-		t.Fatalf("Failed to create chores table: %v", err)
+	repo := openStore(t).Chores
+
+	// The migrations seed some chores
+	existing, err := repo.List(t.Context())
+	if err != nil {
+		t.Fatalf("Failed to get chores: %v", err)
 	}
+	seeded := len(existing)
 
 	// Test CreateChore
 	testChore := &models.Chore{
@@ -38,7 +28,7 @@ func TestChoreOperations(t *testing.T) {
 		Image:         "test.jpg",
 	}
 
-	err = CreateChore(db, testChore)
+	err = repo.Create(t.Context(), testChore)
 	if err != nil {
 		t.Fatalf("Failed to create chore: %v", err)
 	}
@@ -48,7 +38,7 @@ func TestChoreOperations(t *testing.T) {
 	}
 
 	// Test GetChore
-	chore, err := GetChore(db, testChore.ID)
+	chore, err := repo.Get(t.Context(), testChore.ID)
 	if err != nil {
 		t.Fatalf("Failed to get chore: %v", err)
 	}
@@ -70,13 +60,13 @@ func TestChoreOperations(t *testing.T) {
 	testChore.Name = updatedName
 	testChore.DefaultPoints = 15
 
-	err = UpdateChore(db, testChore)
+	err = repo.Update(t.Context(), testChore)
 	if err != nil {
 		t.Fatalf("Failed to update chore: %v", err)
 	}
 
 	// Verify update
-	updatedChore, err := GetChore(db, testChore.ID)
+	updatedChore, err := repo.Get(t.Context(), testChore.ID)
 	if err != nil {
 		t.Fatalf("Failed to get updated chore: %v", err)
 	}
@@ -96,66 +86,46 @@ func TestChoreOperations(t *testing.T) {
 		DefaultPoints: 5,
 	}
 
-	err = CreateChore(db, secondChore)
+	err = repo.Create(t.Context(), secondChore)
 	if err != nil {
 		t.Fatalf("Failed to create second chore: %v", err)
 	}
 
 	// Get all chores
-	chores, err := GetChores(db)
+	chores, err := repo.List(t.Context())
 	if err != nil {
 		t.Fatalf("Failed to get chores: %v", err)
 	}
 
-	if len(chores) != 2 {
-		t.Errorf("Expected 2 chores, got %d", len(chores))
+	if len(chores) != seeded+2 {
+		t.Errorf("Expected %d chores, got %d", seeded+2, len(chores))
 	}
 
 	// Test DeleteChore
-	err = DeleteChore(db, testChore.ID)
+	err = repo.Delete(t.Context(), testChore.ID)
 	if err != nil {
 		t.Fatalf("Failed to delete chore: %v", err)
 	}
 
 	// Verify deletion
-	deletedChores, err := GetChores(db)
+	deletedChores, err := repo.List(t.Context())
 	if err != nil {
 		t.Fatalf("Failed to get chores after deletion: %v", err)
 	}
 
-	if len(deletedChores) != 1 {
-		t.Errorf("Expected 1 chore after deletion, got %d", len(deletedChores))
+	if len(deletedChores) != seeded+1 {
+		t.Errorf("Expected %d chores after deletion, got %d", seeded+1, len(deletedChores))
 	}
 
-	if deletedChores[0].ID != secondChore.ID {
-		t.Errorf("Expected remaining chore to be the second chore, got %d", deletedChores[0].ID)
+	for _, chore := range deletedChores {
+		if chore.ID == testChore.ID {
+			t.Errorf("Expected chore %d to be deleted", testChore.ID)
+		}
 	}
 }
 
 func TestChoreNullableImage(t *testing.T) {
-	// Setup test database
-	db, _, cleanup := setupTestDB(t)
-	defer cleanup()
-
-	// Replace the global DB with our test DB
-	originalDB := DB
-	DB = db
-	defer func() { DB = originalDB }()
-
-	// Create the chores table for testing
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS chores (
-			id INTEGER PRIMARY KEY,
-			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			name TEXT NOT NULL,
-			default_points INTEGER NOT NULL CHECK (default_points > 0),
-			image TEXT
-		);
-	`)
-	if err != nil {
-		t.Fatalf("Failed to create chores table: %v", err)
-	}
+	repo := openStore(t).Chores
 
 	// Test CreateChore with no image
 	testChore := &models.Chore{
@@ -164,13 +134,13 @@ func TestChoreNullableImage(t *testing.T) {
 		// No image
 	}
 
-	err = CreateChore(db, testChore)
+	err := repo.Create(t.Context(), testChore)
 	if err != nil {
 		t.Fatalf("Failed to create chore: %v", err)
 	}
 
 	// Test GetChore
-	chore, err := GetChore(db, testChore.ID)
+	chore, err := repo.Get(t.Context(), testChore.ID)
 	if err != nil {
 		t.Fatalf("Failed to get chore: %v", err)
 	}
@@ -181,13 +151,13 @@ func TestChoreNullableImage(t *testing.T) {
 
 	// Test update from no image to image
 	testChore.Image = "new_image.jpg"
-	err = UpdateChore(db, testChore)
+	err = repo.Update(t.Context(), testChore)
 	if err != nil {
 		t.Fatalf("Failed to update chore with image: %v", err)
 	}
 
 	// Verify update
-	updatedChore, err := GetChore(db, testChore.ID)
+	updatedChore, err := repo.Get(t.Context(), testChore.ID)
 	if err != nil {
 		t.Fatalf("Failed to get updated chore: %v", err)
 	}
@@ -198,13 +168,13 @@ func TestChoreNullableImage(t *testing.T) {
 
 	// Test update from image to no image
 	testChore.Image = ""
-	err = UpdateChore(db, testChore)
+	err = repo.Update(t.Context(), testChore)
 	if err != nil {
 		t.Fatalf("Failed to update chore removing image: %v", err)
 	}
 
 	// Verify update
-	updatedChore, err = GetChore(db, testChore.ID)
+	updatedChore, err = repo.Get(t.Context(), testChore.ID)
 	if err != nil {
 		t.Fatalf("Failed to get updated chore: %v", err)
 	}
@@ -213,3 +183,122 @@ func TestChoreNullableImage(t *testing.T) {
 		t.Errorf("Expected empty image after removal, got %s", updatedChore.Image)
 	}
 }
+
+// openStore opens a store on a migrated database
+func openStore(t *testing.T) *Store {
+	return NewStore(openPools(t))
+}
+
+// openPools opens the configured write and read pools on a migrated database
+func openPools(t *testing.T) (*sql.DB, *sql.DB) {
+	return openPoolsAt(t, filepath.Join(t.TempDir(), "chores.db"))
+}
+
+// openPoolsAt opens the pools on the database file at path and migrates it
+func openPoolsAt(t *testing.T, path string) (*sql.DB, *sql.DB) {
+	writer, reader, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		reader.Close()
+		writer.Close()
+	})
+
+	if err := NewMigrationManager(writer, chores.Migrations()).RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	return writer, reader
+}
+
+func TestOpenFileSettings(t *testing.T) {
+	writer, reader := openPools(t)
+
+	var journalMode string
+	var foreignKeys int
+	if err := writer.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatalf("Failed to read journal mode: %v", err)
+	}
+	if err := writer.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		t.Fatalf("Failed to read foreign keys: %v", err)
+	}
+	if journalMode != "wal" || foreignKeys != 1 {
+		t.Errorf("Expected WAL with foreign keys, got journal mode %q and foreign keys %d", journalMode, foreignKeys)
+	}
+
+	if _, err := writer.Exec("INSERT INTO routines (owner_id) VALUES (999)"); err == nil {
+		t.Errorf("Expected a routine for a missing user to violate the foreign key")
+	}
+	if _, err := reader.Exec("DELETE FROM sessions"); err == nil {
+		t.Errorf("Expected the read pool to refuse writes")
+	}
+}
+
+// TestUpsertChoreRoutineConcurrent is meant to be run with -race
+func TestUpsertChoreRoutineConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chores.db")
+	writer, reader := openPoolsAt(t, path)
+	// A second process on the same file, like the server during a deploy
+	otherWriter, _ := openPoolsAt(t, path)
+
+	// A fresh routine, so every writer races to insert the chore
+	var routineID, choreID, userID int64
+	if err := writer.QueryRow("SELECT id FROM users LIMIT 1").Scan(&userID); err != nil {
+		t.Fatalf("Failed to find a user: %v", err)
+	}
+	if err := writer.QueryRow("SELECT id FROM chores LIMIT 1").Scan(&choreID); err != nil {
+		t.Fatalf("Failed to find a chore: %v", err)
+	}
+	result, err := writer.Exec("INSERT INTO routines (owner_id) VALUES (?)", userID)
+	if err != nil {
+		t.Fatalf("Failed to create routine: %v", err)
+	}
+	if routineID, err = result.LastInsertId(); err != nil {
+		t.Fatalf("Failed to get routine ID: %v", err)
+	}
+
+	const writers = 20
+	const upsertsPerWriter = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*upsertsPerWriter*2)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			db := writer
+			if i%2 == 1 {
+				db = otherWriter
+			}
+			for j := 0; j < upsertsPerWriter; j++ {
+				if _, err := UpsertChoreRoutine(t.Context(), db, routineID, choreID, (i+j)%2 == 0, userID); err != nil {
+					errs <- fmt.Errorf("upsert: %w", err)
+				}
+			}
+		}(i)
+		// Readers keep going while the writers commit
+		go func() {
+			defer wg.Done()
+			for j := 0; j < upsertsPerWriter; j++ {
+				if _, _, err := GetChoreCountsForRoutine(t.Context(), reader, routineID); err != nil {
+					errs <- fmt.Errorf("read: %w", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Failed concurrently: %v", err)
+	}
+
+	var rows int
+	err = writer.QueryRow("SELECT COUNT(*) FROM chore_routines WHERE routine_id = ? AND chore_id = ?", routineID, choreID).Scan(&rows)
+	if err != nil {
+		t.Fatalf("Failed to count chore routines: %v", err)
+	}
+	if rows != 1 {
+		t.Errorf("Expected exactly one chore routine, got %d", rows)
+	}
+}
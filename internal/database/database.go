@@ -1,34 +1,145 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"runtime"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+// ErrInUse is returned when deleting a row other rows still reference
+var ErrInUse = errors.New("still in use")
 
-func Init() error {
-	var err error
+const (
+	// QueryTimeoutEnv names the environment variable that bounds how long a
+	// single database call may run, as a duration like "10s". 0 disables it
+	QueryTimeoutEnv = "DB_QUERY_TIMEOUT"
+	// DefaultQueryTimeout is used when DB_QUERY_TIMEOUT is unset. It leaves
+	// room for the busy timeout on top of the query itself
+	DefaultQueryTimeout = 10 * time.Second
+)
+
+// queryTimeout bounds every database call. Set it with SetQueryTimeout
+var queryTimeout = DefaultQueryTimeout
+
+const (
+	// busyTimeout is how many milliseconds a connection waits for a lock
+	busyTimeout = "5000"
+
+	// Foreign keys and the busy timeout are per connection, WAL is stored in
+	// the file and lets readers carry on while the writer commits
+	writeOptions = "_journal_mode=WAL&_synchronous=NORMAL&_foreign_keys=on&_busy_timeout=" + busyTimeout +
+		// Take the write lock when a transaction begins, so a second writer
+		// waits for the busy timeout instead of failing to upgrade its lock
+		"&_txlock=immediate"
+	readOptions = "_foreign_keys=on&_query_only=true&_busy_timeout=" + busyTimeout
+)
 
-	// Get database URL from environment variable or use default
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		dbURL = "chores.db"
+// Init opens the database and applies any pending migrations from the given file system
+func Init(migrations fs.FS) (*Store, error) {
+	store, err := Open()
+	if err != nil {
+		return nil, err
 	}
+
+	// Run migrations
+	if err := store.RunMigrations(migrations); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// URL returns the database file from DATABASE_URL, or chores.db by default
+func URL() string {
+	if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+		return dbURL
+	}
+	return "chores.db"
+}
+
+// Open opens the database named by DATABASE_URL without touching its schema
+func Open() (*Store, error) {
+	dbURL := URL()
 	log.Printf("Using database URL: %s", dbURL)
 
-	DB, err = sql.Open("sqlite3", dbURL)
+	writer, reader, err := OpenFile(dbURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Run migrations
-	if err := RunMigrations(); err != nil {
-		return err
+	timeout, err := QueryTimeout()
+	if err != nil {
+		writer.Close()
+		reader.Close()
+		return nil, err
 	}
+	SetQueryTimeout(timeout)
+
+	store := NewStore(writer, reader)
+	store.Path = dbURL
+	return store, nil
+}
 
-	return nil
+// QueryTimeout returns the query timeout from DB_QUERY_TIMEOUT
+func QueryTimeout() (time.Duration, error) {
+	value := os.Getenv(QueryTimeoutEnv)
+	if value == "" {
+		return DefaultQueryTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a duration like 10s", QueryTimeoutEnv, value)
+	}
+	return timeout, nil
+}
+
+// SetQueryTimeout changes how long each database call may run. It is meant
+// to be called once at startup, before any queries
+func SetQueryTimeout(timeout time.Duration) {
+	queryTimeout = timeout
+}
+
+// WithQueryTimeout bounds ctx by the query timeout. Callers must call the returned
+// cancel function once the call, including reading its rows, is done
+func WithQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// OpenFile opens the write and read pools for the SQLite file at path
+func OpenFile(path string) (*sql.DB, *sql.DB, error) {
+	writer, err := sql.Open("sqlite3", "file:"+path+"?"+writeOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	writer.SetMaxOpenConns(1)
+
+	reader, err := sql.Open("sqlite3", "file:"+path+"?"+readOptions)
+	if err != nil {
+		writer.Close()
+		return nil, nil, err
+	}
+	reader.SetMaxOpenConns(max(4, runtime.NumCPU()))
+
+	return writer, reader, nil
+}
+
+// inUse turns a foreign key violation into ErrInUse
+func inUse(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+		return ErrInUse
+	}
+	return err
 }
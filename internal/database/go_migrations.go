@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GoMigrationFunc changes the database inside the migration's transaction
+type GoMigrationFunc func(tx *sql.Tx) error
+
+// GoMigration is a migration written in Go, for data changes that are
+// awkward in plain SQL. It is numbered in the same sequence as the SQL files
+type GoMigration struct {
+	ID   int
+	Name string
+	Up   GoMigrationFunc
+	// Down reverts Up, or is nil when the migration can't be rolled back
+	Down GoMigrationFunc
+}
+
+// registeredMigrations holds the Go migrations every new manager starts with
+var registeredMigrations = make(map[int]GoMigration)
+
+// validate rejects a migration that could never be applied
+func (g GoMigration) validate() error {
+	if g.ID <= 0 {
+		return fmt.Errorf("migration %q has ID %d, IDs start at 1", g.Name, g.ID)
+	}
+	if g.Up == nil {
+		return fmt.Errorf("migration %d has no Up function", g.ID)
+	}
+	return nil
+}
+
+// RegisterMigration adds a Go migration for all migration managers created
+// afterwards. It is meant to be called from init and panics on an invalid
+// migration or a duplicate ID
+func RegisterMigration(migration GoMigration) {
+	if err := migration.validate(); err != nil {
+		panic("database: " + err.Error())
+	}
+	if _, exists := registeredMigrations[migration.ID]; exists {
+		panic(fmt.Sprintf("database: migration %d registered twice", migration.ID))
+	}
+	registeredMigrations[migration.ID] = migration
+}
+
+// path is how the migration is named in logs and the status report
+func (g GoMigration) path() string {
+	return fmt.Sprintf("go:%d_%s", g.ID, g.Name)
+}
+
+// file turns the migration into a MigrationFile. Go code can't be hashed,
+// so the checksum only notices when a migration is renamed
+func (g GoMigration) file() MigrationFile {
+	return MigrationFile{
+		ID:       g.ID,
+		Path:     g.path(),
+		Checksum: checksum([]byte(g.path())),
+		Up:       g.Up,
+		Down:     g.Down,
+	}
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bagvendt/chores/internal/models"
+)
+
+// RecordLoginAttempt stores an audit record of a rejected login
+func RecordLoginAttempt(ctx context.Context, db *sql.DB, attempt *models.LoginAttempt) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO login_attempts (created, username, ip, user_agent, reason)
+		VALUES (?, ?, ?, ?, ?)
+	`,
+		now,
+		attempt.Username,
+		attempt.IP,
+		sql.NullString{String: attempt.UserAgent, Valid: attempt.UserAgent != ""},
+		attempt.Reason,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	attempt.ID = id
+	attempt.Created = now
+
+	return nil
+}
+
+// GetLoginAttempts returns the most recent rejected logins, newest first
+func GetLoginAttempts(ctx context.Context, db *sql.DB, limit int) ([]models.LoginAttempt, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, created, username, ip, user_agent, reason
+		FROM login_attempts
+		ORDER BY created DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		var userAgent sql.NullString
+
+		if err := rows.Scan(
+			&attempt.ID,
+			&attempt.Created,
+			&attempt.Username,
+			&attempt.IP,
+			&userAgent,
+			&attempt.Reason,
+		); err != nil {
+			return nil, err
+		}
+
+		attempt.UserAgent = userAgent.String
+
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, nil
+}
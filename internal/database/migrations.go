@@ -1,25 +1,92 @@
 package database
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
-	"path/filepath"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// DriftPolicyEnv names the environment variable that picks the DriftPolicy
+const DriftPolicyEnv = "MIGRATION_DRIFT"
+
+// DriftPolicy decides what RunMigrations does when an applied migration file
+// no longer matches the checksum recorded when it was applied
+type DriftPolicy int
+
+const (
+	// DriftFail refuses to run any migrations
+	DriftFail DriftPolicy = iota
+	// DriftWarn logs the drifted migrations and carries on
+	DriftWarn
+)
+
+// ErrMigrationDrift is returned when applied migration files have been edited
+var ErrMigrationDrift = errors.New("applied migrations have changed")
+
+// ParseDriftPolicy parses "fail" or "warn", with an empty string meaning fail
+func ParseDriftPolicy(s string) (DriftPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "fail":
+		return DriftFail, nil
+	case "warn":
+		return DriftWarn, nil
+	default:
+		return DriftFail, fmt.Errorf("invalid %s %q, expected fail or warn", DriftPolicyEnv, s)
+	}
+}
+
 // MigrationManager handles the application of database migrations
 type MigrationManager struct {
-	db *sql.DB
+	db           *sql.DB
+	migrations   fs.FS
+	goMigrations map[int]GoMigration
+	drift        DriftPolicy
+	backup       func() error
 }
 
-// NewMigrationManager creates a new migration manager
-func NewMigrationManager(db *sql.DB) *MigrationManager {
-	return &MigrationManager{db: db}
+// NewMigrationManager creates a new migration manager that reads SQL
+// migrations from the root of the given file system, along with every
+// registered Go migration
+func NewMigrationManager(db *sql.DB, migrations fs.FS) *MigrationManager {
+	goMigrations := make(map[int]GoMigration, len(registeredMigrations))
+	for id, migration := range registeredMigrations {
+		goMigrations[id] = migration
+	}
+	return &MigrationManager{db: db, migrations: migrations, goMigrations: goMigrations}
+}
+
+// AddGoMigration adds a Go migration to this manager only
+func (m *MigrationManager) AddGoMigration(migration GoMigration) error {
+	if err := migration.validate(); err != nil {
+		return err
+	}
+	if _, exists := m.goMigrations[migration.ID]; exists {
+		return fmt.Errorf("migration %d is already registered", migration.ID)
+	}
+	m.goMigrations[migration.ID] = migration
+	return nil
+}
+
+// SetDriftPolicy changes how RunMigrations reacts to drifted migrations
+func (m *MigrationManager) SetDriftPolicy(policy DriftPolicy) {
+	m.drift = policy
+}
+
+// SetBackup sets a function RunMigrations calls once before it applies the
+// first pending migration to a database that already has migrations applied.
+// If it fails nothing is applied
+func (m *MigrationManager) SetBackup(backup func() error) {
+	m.backup = backup
 }
 
 // EnsureMigrationsTable creates the migrations table if it doesn't exist
@@ -28,21 +95,81 @@ func (m *MigrationManager) EnsureMigrationsTable() error {
 		CREATE TABLE IF NOT EXISTS migrations (
 			id INTEGER PRIMARY KEY,
 			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			migration_id INTEGER UNIQUE NOT NULL
+			migration_id INTEGER UNIQUE NOT NULL,
+			checksum TEXT
 		)
 	`)
+	if err != nil {
+		return err
+	}
+
+	// Databases migrated before checksums were recorded lack the column
+	var hasChecksum bool
+	err = m.db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('migrations') WHERE name = 'checksum'").Scan(&hasChecksum)
+	if err != nil {
+		return err
+	}
+	if !hasChecksum {
+		_, err = m.db.Exec("ALTER TABLE migrations ADD COLUMN checksum TEXT")
+	}
 	return err
 }
 
+// AppliedMigration is a row in the migrations table
+type AppliedMigration struct {
+	ID        int
+	AppliedAt string
+	Checksum  string
+}
+
+// getAppliedRecords returns the migrations table keyed by migration ID
+func (m *MigrationManager) getAppliedRecords() (map[int]AppliedMigration, error) {
+	if err := m.EnsureMigrationsTable(); err != nil {
+		return nil, fmt.Errorf("error creating migrations table: %w", err)
+	}
+
+	rows, err := m.db.Query("SELECT migration_id, applied_at, checksum FROM migrations ORDER BY migration_id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying migrations: %w", err)
+	}
+	defer rows.Close()
+
+	records := make(map[int]AppliedMigration)
+	for rows.Next() {
+		var record AppliedMigration
+		var checksum sql.NullString
+		if err := rows.Scan(&record.ID, &record.AppliedAt, &checksum); err != nil {
+			return nil, fmt.Errorf("error scanning migration row: %w", err)
+		}
+		record.Checksum = checksum.String
+		records[record.ID] = record
+	}
+
+	return records, rows.Err()
+}
+
 // GetAppliedMigrations returns a map of already applied migration IDs
 func (m *MigrationManager) GetAppliedMigrations() (map[int]bool, error) {
-	applied := make(map[int]bool)
-
 	// First ensure the migrations table exists
 	if err := m.EnsureMigrationsTable(); err != nil {
 		return nil, fmt.Errorf("error creating migrations table: %w", err)
 	}
 
+	return m.queryAppliedMigrations()
+}
+
+// hasMigrationsTable reports whether the migrations table exists yet
+func (m *MigrationManager) hasMigrationsTable() (bool, error) {
+	var exists bool
+	err := m.db.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'migrations'").Scan(&exists)
+	return exists, err
+}
+
+// queryAppliedMigrations reads the applied migration IDs from an existing
+// migrations table
+func (m *MigrationManager) queryAppliedMigrations() (map[int]bool, error) {
+	applied := make(map[int]bool)
+
 	rows, err := m.db.Query("SELECT migration_id FROM migrations ORDER BY migration_id")
 	if err != nil {
 		return nil, fmt.Errorf("error querying migrations: %w", err)
@@ -62,22 +189,24 @@ func (m *MigrationManager) GetAppliedMigrations() (map[int]bool, error) {
 
 // RunMigrations applies any pending migrations
 func (m *MigrationManager) RunMigrations() error {
-	// Get applied migrations
-	applied, err := m.GetAppliedMigrations()
+	// Get migration files, sorted by ID, and the applied migrations
+	files, applied, err := m.loadMigrations()
 	if err != nil {
 		return err
 	}
 
-	// Get migration files
-	files, err := m.GetMigrationFiles()
-	if err != nil {
+	// Applied files must not have been edited since
+	if err := m.checkDrift(files); err != nil {
 		return err
 	}
 
-	// Sort numerically by migration ID
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].ID < files[j].ID
-	})
+	// Snapshot existing data before changing its schema. Every applied
+	// migration has a file, so fewer applied than files means some are pending
+	if m.backup != nil && len(applied) > 0 && len(applied) < len(files) {
+		if err := m.backup(); err != nil {
+			return fmt.Errorf("failed to back up before migrating: %w", err)
+		}
+	}
 
 	// Apply each migration in order
 	for _, file := range files {
@@ -100,18 +229,83 @@ func (m *MigrationManager) RunMigrations() error {
 	return nil
 }
 
-// MigrationFile represents a SQL migration file
+// MigrationFile represents a SQL migration file and its optional
+// N_name.down.sql counterpart, or a Go migration when Up is set
 type MigrationFile struct {
-	ID   int
-	Path string
-	SQL  string
+	ID       int
+	Path     string
+	SQL      string
+	Checksum string
+	DownPath string
+	DownSQL  string
+	Up       GoMigrationFunc
+	Down     GoMigrationFunc
+}
+
+// reversible reports whether the migration can be rolled back
+func (f MigrationFile) reversible() bool {
+	return f.DownSQL != "" || f.Down != nil
+}
+
+// checksum returns the hex encoded SHA-256 of a migration's contents
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// downSuffix marks the SQL file that reverts the migration with the same ID
+const downSuffix = ".down.sql"
+
+// Errors describing an invalid set of migrations. Validation wraps each
+// problem in one of these and joins them all into a single error
+var (
+	ErrInvalidMigrationName = errors.New("invalid migration file name")
+	ErrDuplicateMigration   = errors.New("duplicate migration ID")
+	ErrMigrationGap         = errors.New("gap in migration sequence")
+	ErrMissingMigration     = errors.New("applied migration has no file")
+)
+
+// parseMigrationID returns N from a file named N_name.sql or N_name.down.sql
+func parseMigrationID(filename string) (int, bool) {
+	idPart, _, found := strings.Cut(filename, "_")
+	if !found {
+		return 0, false
+	}
+	id, err := strconv.Atoi(idPart)
+	if err != nil || id <= 0 || strconv.Itoa(id) != idPart {
+		return 0, false
+	}
+	return id, true
 }
 
-// GetMigrationFiles finds all SQL migration files
+// GetMigrationFiles finds all SQL migration files, pairs each with its down
+// file and adds the Go migrations, sorted by ID. It fails with every problem
+// found if the set isn't numbered 1, 2, 3 and so on
 func (m *MigrationManager) GetMigrationFiles() ([]MigrationFile, error) {
+	files, problems, err := m.scanMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, invalidMigrations(problems)
+	}
+	return files, nil
+}
+
+// invalidMigrations joins validation problems into one error
+func invalidMigrations(problems []error) error {
+	return fmt.Errorf("invalid migrations:\n%w", errors.Join(problems...))
+}
+
+// scanMigrationFiles reads the migrations and returns them together with
+// any problems in the set. Files with problems are left out where needed
+func (m *MigrationManager) scanMigrationFiles() ([]MigrationFile, []error, error) {
 	var files []MigrationFile
+	var problems []error
+	ups := make(map[int]string)
+	downs := make(map[int]MigrationFile)
 
-	err := filepath.WalkDir("migrations", func(path string, d fs.DirEntry, err error) error {
+	err := fs.WalkDir(m.migrations, ".", func(filePath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -122,39 +316,146 @@ func (m *MigrationManager) GetMigrationFiles() ([]MigrationFile, error) {
 		}
 
 		// Only process SQL files
-		if !strings.HasSuffix(path, ".sql") {
+		if !strings.HasSuffix(filePath, ".sql") {
 			return nil
 		}
 
 		// Extract migration ID from filename
-		filename := filepath.Base(path)
-		idPart := strings.Split(filename, "_")[0]
-		id, err := strconv.Atoi(idPart)
-		if err != nil {
-			log.Printf("Warning: Skipping file with invalid migration ID format: %s", path)
+		filename := path.Base(filePath)
+		id, ok := parseMigrationID(filename)
+		if !ok {
+			problems = append(problems, fmt.Errorf("%w: %s, expected N_name.sql where N is a positive number", ErrInvalidMigrationName, filePath))
 			return nil
 		}
 
 		// Read file content
-		content, err := os.ReadFile(path)
+		content, err := fs.ReadFile(m.migrations, filePath)
 		if err != nil {
-			return fmt.Errorf("error reading migration file %s: %w", path, err)
+			return fmt.Errorf("error reading migration file %s: %w", filePath, err)
 		}
 
-		files = append(files, MigrationFile{
-			ID:   id,
-			Path: path,
-			SQL:  string(content),
-		})
+		file := MigrationFile{
+			ID:       id,
+			Path:     filePath,
+			SQL:      string(content),
+			Checksum: checksum(content),
+		}
+		if strings.HasSuffix(filename, downSuffix) {
+			if other, exists := downs[id]; exists {
+				problems = append(problems, fmt.Errorf("%w: %s and %s both revert migration %d", ErrDuplicateMigration, other.Path, filePath, id))
+				return nil
+			}
+			downs[id] = file
+		} else {
+			if other, exists := ups[id]; exists {
+				problems = append(problems, fmt.Errorf("%w: %s and %s both use %d", ErrDuplicateMigration, other, filePath, id))
+				return nil
+			}
+			ups[id] = filePath
+			files = append(files, file)
+		}
 
 		return nil
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("error walking migrations directory: %w", err)
+		return nil, nil, fmt.Errorf("error walking migrations directory: %w", err)
 	}
 
-	return files, nil
+	// Attach each down file to the migration it reverts
+	for i := range files {
+		if down, ok := downs[files[i].ID]; ok {
+			files[i].DownPath = down.Path
+			files[i].DownSQL = down.SQL
+			delete(downs, files[i].ID)
+		}
+	}
+	for _, down := range downs {
+		log.Printf("Warning: Skipping down file without a matching migration: %s", down.Path)
+	}
+
+	// Go migrations share the numbering with the SQL files
+	for id, migration := range m.goMigrations {
+		if sqlPath, exists := ups[id]; exists {
+			problems = append(problems, fmt.Errorf("%w: %s and %s both use %d", ErrDuplicateMigration, sqlPath, migration.path(), id))
+			continue
+		}
+		files = append(files, migration.file())
+	}
+
+	// Sort numerically by migration ID
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ID < files[j].ID
+	})
+
+	// The sequence starts at 1 and has no holes
+	next := 1
+	for _, file := range files {
+		switch {
+		case file.ID == next+1:
+			problems = append(problems, fmt.Errorf("%w: migration %d is missing before %s", ErrMigrationGap, next, file.Path))
+		case file.ID > next:
+			problems = append(problems, fmt.Errorf("%w: migrations %d to %d are missing before %s", ErrMigrationGap, next, file.ID-1, file.Path))
+		}
+		next = file.ID + 1
+	}
+
+	return files, problems, nil
+}
+
+// loadMigrations validates the migration files against each other and the
+// migrations table, and returns them along with the applied IDs. Nothing is
+// applied or reverted unless the whole set is valid
+func (m *MigrationManager) loadMigrations() ([]MigrationFile, map[int]bool, error) {
+	files, problems, err := m.scanMigrationFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Broken files are reported on their own, before the database is looked at
+	if len(problems) > 0 {
+		return nil, nil, invalidMigrations(problems)
+	}
+
+	// Loading only reads, so a database without the migrations table has
+	// nothing applied rather than getting an empty table
+	applied := make(map[int]bool)
+	exists, err := m.hasMigrationsTable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking for migrations table: %w", err)
+	}
+	if exists {
+		if applied, err = m.queryAppliedMigrations(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Applied migrations can't be checked or reverted without their file
+	known := make(map[int]bool, len(files))
+	for _, file := range files {
+		known[file.ID] = true
+	}
+	var missing []int
+	for id := range applied {
+		if !known[id] {
+			missing = append(missing, id)
+		}
+	}
+	sort.Ints(missing)
+	for _, id := range missing {
+		problems = append(problems, fmt.Errorf("%w: migration %d", ErrMissingMigration, id))
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, invalidMigrations(problems)
+	}
+	return files, applied, nil
+}
+
+// Validate checks the migration set without applying anything
+func (m *MigrationManager) Validate() error {
+	_, _, err := m.loadMigrations()
+	return err
 }
 
 // ApplyMigration applies a single migration within a transaction
@@ -172,15 +473,22 @@ func (m *MigrationManager) ApplyMigration(file MigrationFile) error {
 		}
 	}()
 
-	// Execute migration SQL
-	if _, err = tx.Exec(file.SQL); err != nil {
+	// Execute migration SQL or Go code
+	if file.Up != nil {
+		if err = file.Up(tx); err != nil {
+			return fmt.Errorf("error executing Go migration: %w", err)
+		}
+	} else if _, err = tx.Exec(file.SQL); err != nil {
 		return fmt.Errorf("error executing migration SQL: %w", err)
 	}
 
 	// Record migration as applied
 	// We do this even if the SQL already inserts into migrations 
 	// as a safety measure to ensure it's recorded
-	_, err = tx.Exec("INSERT OR IGNORE INTO migrations (migration_id) VALUES (?)", file.ID)
+	_, err = tx.Exec(`
+		INSERT INTO migrations (migration_id, checksum) VALUES (?, ?)
+		ON CONFLICT (migration_id) DO UPDATE SET checksum = excluded.checksum
+	`, file.ID, file.Checksum)
 	if err != nil {
 		return fmt.Errorf("error recording migration: %w", err)
 	}
@@ -193,8 +501,220 @@ func (m *MigrationManager) ApplyMigration(file MigrationFile) error {
 	return nil
 }
 
-// RunMigrations runs all pending migrations in order
-func RunMigrations() error {
-	manager := NewMigrationManager(DB)
+// Rollback reverts every applied migration with an ID above to, newest first.
+// Each migration is reverted in its own transaction, so when one fails the
+// ones before it stay reverted and the failing one is left applied
+func (m *MigrationManager) Rollback(to int) error {
+	files, applied, err := m.loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[int]MigrationFile, len(files))
+	for _, file := range files {
+		byID[file.ID] = file
+	}
+
+	var ids []int
+	for id := range applied {
+		if id > to {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+
+	// Refuse to start unless every migration can be reverted
+	for _, id := range ids {
+		if !byID[id].reversible() {
+			return fmt.Errorf("migration %d has no down migration, cannot roll back to %d", id, to)
+		}
+	}
+
+	for _, id := range ids {
+		file := byID[id]
+		log.Printf("Rolling back migration %d: %s", file.ID, file.Path)
+
+		if err := m.RevertMigration(file); err != nil {
+			return fmt.Errorf("failed to roll back migration %d: %w", file.ID, err)
+		}
+
+		log.Printf("Migration %d rolled back successfully", file.ID)
+	}
+
+	return nil
+}
+
+// RevertMigration applies a single down migration within a transaction
+func (m *MigrationManager) RevertMigration(file MigrationFile) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Ensure we either commit or rollback
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if file.Down != nil {
+		if err = file.Down(tx); err != nil {
+			return fmt.Errorf("error executing Go down migration: %w", err)
+		}
+	} else if _, err = tx.Exec(file.DownSQL); err != nil {
+		return fmt.Errorf("error executing down migration SQL: %w", err)
+	}
+
+	if _, err = tx.Exec("DELETE FROM migrations WHERE migration_id = ?", file.ID); err != nil {
+		return fmt.Errorf("error removing migration record: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// MigrationState describes how a migration file relates to the database
+type MigrationState string
+
+const (
+	MigrationApplied MigrationState = "applied"
+	MigrationPending MigrationState = "pending"
+	MigrationDrifted MigrationState = "drifted"
+	// MigrationMissing is an applied migration whose file is gone
+	MigrationMissing MigrationState = "missing"
+)
+
+// MigrationStatus is one line of the migration status report
+type MigrationStatus struct {
+	ID        int
+	Path      string
+	State     MigrationState
+	AppliedAt string
+}
+
+// Status lists every known migration, from files and the migrations table, by ID
+func (m *MigrationManager) Status() ([]MigrationStatus, error) {
+	records, err := m.getAppliedRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	// Report what can be read even when the set is invalid, Validate says why
+	files, _, err := m.scanMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, file := range files {
+		status := MigrationStatus{ID: file.ID, Path: file.Path, State: MigrationPending}
+		if record, ok := records[file.ID]; ok {
+			status.AppliedAt = record.AppliedAt
+			status.State = MigrationApplied
+			// Rows from before checksums were recorded can't be compared
+			if record.Checksum != "" && record.Checksum != file.Checksum {
+				status.State = MigrationDrifted
+			}
+			delete(records, file.ID)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range records {
+		statuses = append(statuses, MigrationStatus{ID: record.ID, State: MigrationMissing, AppliedAt: record.AppliedAt})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+
+	return statuses, nil
+}
+
+// checkDrift compares applied files against their recorded checksums and
+// applies the drift policy. Rows without a checksum get the current one
+func (m *MigrationManager) checkDrift(files []MigrationFile) error {
+	records, err := m.getAppliedRecords()
+	if err != nil {
+		return err
+	}
+
+	var drifted []int
+	for _, file := range files {
+		record, ok := records[file.ID]
+		if !ok {
+			continue
+		}
+
+		if record.Checksum == "" {
+			if _, err := m.db.Exec("UPDATE migrations SET checksum = ? WHERE migration_id = ?", file.Checksum, file.ID); err != nil {
+				return fmt.Errorf("error recording checksum for migration %d: %w", file.ID, err)
+			}
+			continue
+		}
+
+		if record.Checksum != file.Checksum {
+			drifted = append(drifted, file.ID)
+		}
+	}
+
+	if len(drifted) == 0 {
+		return nil
+	}
+	if m.drift == DriftWarn {
+		log.Printf("Warning: %v: %v", ErrMigrationDrift, drifted)
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrMigrationDrift, drifted)
+}
+
+// RunMigrations runs all pending migrations from the given file system in
+// order, using the drift policy from MIGRATION_DRIFT
+func (s *Store) RunMigrations(migrations fs.FS) error {
+	policy, err := ParseDriftPolicy(os.Getenv(DriftPolicyEnv))
+	if err != nil {
+		return err
+	}
+
+	manager := NewMigrationManager(s.DB, migrations)
+	manager.SetDriftPolicy(policy)
+
+	// In-memory and test databases opened without Open have nothing to back up
+	if s.Path != "" && s.Path != ":memory:" {
+		keep, err := BackupKeep()
+		if err != nil {
+			return err
+		}
+		manager.SetBackup(func() error {
+			backupPath, err := Backup(s.DB, s.Path, time.Now())
+			if err != nil {
+				return err
+			}
+			log.Printf("Backed up database to %s", backupPath)
+			return PruneBackups(s.Path, keep)
+		})
+	}
+
 	return manager.RunMigrations()
+}
+
+// ValidateMigrations checks the migrations in the given file system against the database
+func (s *Store) ValidateMigrations(migrations fs.FS) error {
+	manager := NewMigrationManager(s.DB, migrations)
+	return manager.Validate()
+}
+
+// MigrationStatus reports the state of every migration in the given file system
+func (s *Store) MigrationStatus(migrations fs.FS) ([]MigrationStatus, error) {
+	manager := NewMigrationManager(s.DB, migrations)
+	return manager.Status()
+}
+
+// Rollback reverts applied migrations from the given file system down to the given ID
+func (s *Store) Rollback(migrations fs.FS, to int) error {
+	manager := NewMigrationManager(s.DB, migrations)
+	return manager.Rollback(to)
 } 
\ No newline at end of file
@@ -2,10 +2,14 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 
+	"github.com/bagvendt/chores"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -34,20 +38,20 @@ func setupTestDB(t *testing.T) (*sql.DB, string, func()) {
 	return db, tempDir, cleanup
 }
 
-// createTestMigration creates a migration file for testing
-func createTestMigration(t *testing.T, dir, name, content string) {
-	path := filepath.Join(dir, name)
-	err := os.WriteFile(path, []byte(content), 0644)
-	if err != nil {
-		t.Fatalf("Failed to write migration file: %v", err)
+// testMigrations builds an in-memory migrations directory from file names and SQL
+func testMigrations(files map[string]string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
 	}
+	return fsys
 }
 
 func TestEnsureMigrationsTable(t *testing.T) {
 	db, _, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	manager := NewMigrationManager(db)
+	manager := NewMigrationManager(db, fstest.MapFS{})
 	err := manager.EnsureMigrationsTable()
 	if err != nil {
 		t.Fatalf("Failed to ensure migrations table: %v", err)
@@ -69,7 +73,7 @@ func TestGetAppliedMigrations(t *testing.T) {
 	db, _, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	manager := NewMigrationManager(db)
+	manager := NewMigrationManager(db, fstest.MapFS{})
 	err := manager.EnsureMigrationsTable()
 	if err != nil {
 		t.Fatalf("Failed to ensure migrations table: %v", err)
@@ -104,7 +108,7 @@ func TestApplyMigration(t *testing.T) {
 	db, _, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	manager := NewMigrationManager(db)
+	manager := NewMigrationManager(db, fstest.MapFS{})
 	err := manager.EnsureMigrationsTable()
 	if err != nil {
 		t.Fatalf("Failed to ensure migrations table: %v", err)
@@ -147,42 +151,27 @@ func TestApplyMigration(t *testing.T) {
 }
 
 func TestRunMigrationsInOrder(t *testing.T) {
-	db, tempDir, cleanup := setupTestDB(t)
+	db, _, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	// Create migrations directory within tempDir
-	migrationsDir := filepath.Join(tempDir, "migrations")
-	if err := os.Mkdir(migrationsDir, 0755); err != nil {
-		t.Fatalf("Failed to create migrations dir: %v", err)
-	}
-
 	// Create test migrations
-	createTestMigration(t, migrationsDir, "1_first.sql", `
+	migrations := testMigrations(map[string]string{
+		"1_first.sql": `
 		CREATE TABLE first_table (id INTEGER PRIMARY KEY);
 		INSERT INTO first_table (id) VALUES (1);
-	`)
-	createTestMigration(t, migrationsDir, "2_second.sql", `
+	`,
+		"2_second.sql": `
 		CREATE TABLE second_table (id INTEGER PRIMARY KEY, first_id INTEGER,
 		FOREIGN KEY (first_id) REFERENCES first_table(id));
 		INSERT INTO second_table (id, first_id) VALUES (1, 1);
-	`)
-	createTestMigration(t, migrationsDir, "3_third.sql", `
+	`,
+		"3_third.sql": `
 		CREATE TABLE third_table (id INTEGER PRIMARY KEY);
-	`)
+	`,
+	})
 
 	// Create migration manager
-	manager := NewMigrationManager(db)
-
-	// Override the migrations directory for testing
-	origDir, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("Failed to get current directory: %v", err)
-	}
-	defer os.Chdir(origDir)
-
-	if err := os.Chdir(tempDir); err != nil {
-		t.Fatalf("Failed to change directory: %v", err)
-	}
+	manager := NewMigrationManager(db, migrations)
 
 	// Run migrations
 	if err := manager.RunMigrations(); err != nil {
@@ -193,7 +182,7 @@ func TestRunMigrationsInOrder(t *testing.T) {
 	tables := []string{"first_table", "second_table", "third_table"}
 	for _, table := range tables {
 		var count int
-		err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&count)
+		err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&count)
 		if err != nil {
 			t.Fatalf("Failed to query for table %s: %v", table, err)
 		}
@@ -205,7 +194,7 @@ func TestRunMigrationsInOrder(t *testing.T) {
 
 	// Verify data was inserted correctly by the first two migrations
 	var firstCount, secondCount int
-	err = db.QueryRow("SELECT COUNT(*) FROM first_table").Scan(&firstCount)
+	err := db.QueryRow("SELECT COUNT(*) FROM first_table").Scan(&firstCount)
 	if err != nil {
 		t.Fatalf("Failed to query first_table: %v", err)
 	}
@@ -233,49 +222,32 @@ func TestRunMigrationsInOrder(t *testing.T) {
 }
 
 func TestMigrationFailureTransactional(t *testing.T) {
-	db, tempDir, cleanup := setupTestDB(t)
+	db, _, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	// Create migrations directory within tempDir
-	migrationsDir := filepath.Join(tempDir, "migrations")
-	if err := os.Mkdir(migrationsDir, 0755); err != nil {
-		t.Fatalf("Failed to create migrations dir: %v", err)
-	}
-
-	// Create a successful first migration
-	createTestMigration(t, migrationsDir, "1_good.sql", `
+	migrations := testMigrations(map[string]string{
+		// Create a successful first migration
+		"1_good.sql": `
 		CREATE TABLE good_table (id INTEGER PRIMARY KEY);
 		INSERT INTO good_table (id) VALUES (1);
-	`)
-
-	// Create a failing second migration (invalid SQL)
-	createTestMigration(t, migrationsDir, "2_bad.sql", `
+	`,
+		// Create a failing second migration (invalid SQL)
+		"2_bad.sql": `
 		CREATE TABLE bad_table (id INTEGER PRIMARY KEY);
 		-- This next statement will fail because the column name is missing
 		INSERT INTO bad_table () VALUES (1);
-	`)
-
-	// Create a third migration that should never be applied
-	createTestMigration(t, migrationsDir, "3_never.sql", `
+	`,
+		// Create a third migration that should never be applied
+		"3_never.sql": `
 		CREATE TABLE never_table (id INTEGER PRIMARY KEY);
-	`)
+	`,
+	})
 
 	// Create migration manager
-	manager := NewMigrationManager(db)
-
-	// Override the migrations directory for testing
-	origDir, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("Failed to get current directory: %v", err)
-	}
-	defer os.Chdir(origDir)
-
-	if err := os.Chdir(tempDir); err != nil {
-		t.Fatalf("Failed to change directory: %v", err)
-	}
+	manager := NewMigrationManager(db, migrations)
 
 	// Run migrations - should fail on the second one
-	err = manager.RunMigrations()
+	err := manager.RunMigrations()
 	if err == nil {
 		t.Fatalf("Expected RunMigrations to fail, but it succeeded")
 	}
@@ -320,3 +292,632 @@ func TestMigrationFailureTransactional(t *testing.T) {
 		t.Errorf("Expected 1 recorded migration, got %d", appliedCount)
 	}
 }
+
+func TestRollback(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	migrations := testMigrations(map[string]string{
+		"1_first.sql":       `CREATE TABLE first_table (id INTEGER PRIMARY KEY);`,
+		"1_first.down.sql":  `DROP TABLE first_table;`,
+		"2_second.sql":      `CREATE TABLE second_table (id INTEGER PRIMARY KEY);`,
+		"2_second.down.sql": `DROP TABLE second_table;`,
+		"3_third.sql":       `CREATE TABLE third_table (id INTEGER PRIMARY KEY);`,
+		"3_third.down.sql":  `DROP TABLE third_table;`,
+	})
+
+	manager := NewMigrationManager(db, migrations)
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// Down files must not be applied as migrations of their own
+	assertApplied(t, db, 1, 2, 3)
+	assertTables(t, db, map[string]bool{"first_table": true, "second_table": true, "third_table": true})
+
+	if err := manager.Rollback(1); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	assertApplied(t, db, 1)
+	assertTables(t, db, map[string]bool{"first_table": true, "second_table": false, "third_table": false})
+
+	// Rolled back migrations are pending again
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to re-run migrations: %v", err)
+	}
+	assertApplied(t, db, 1, 2, 3)
+}
+
+func TestRollbackPartialFailure(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	migrations := testMigrations(map[string]string{
+		"1_first.sql":      `CREATE TABLE first_table (id INTEGER PRIMARY KEY);`,
+		"1_first.down.sql": `DROP TABLE first_table;`,
+		"2_second.sql":     `CREATE TABLE second_table (id INTEGER PRIMARY KEY);`,
+		// The second down migration fails halfway through
+		"2_second.down.sql": `
+		DROP TABLE second_table;
+		DROP TABLE missing_table;
+	`,
+		"3_third.sql":      `CREATE TABLE third_table (id INTEGER PRIMARY KEY);`,
+		"3_third.down.sql": `DROP TABLE third_table;`,
+	})
+
+	manager := NewMigrationManager(db, migrations)
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	if err := manager.Rollback(0); err == nil {
+		t.Fatalf("Expected Rollback to fail, but it succeeded")
+	}
+
+	// The third migration was reverted before the failure, the second was
+	// rolled back as a whole and the first was never reached
+	assertApplied(t, db, 1, 2)
+	assertTables(t, db, map[string]bool{"first_table": true, "second_table": true, "third_table": false})
+}
+
+func TestRollbackMissingDownFile(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	migrations := testMigrations(map[string]string{
+		"1_first.sql":      `CREATE TABLE first_table (id INTEGER PRIMARY KEY);`,
+		"1_first.down.sql": `DROP TABLE first_table;`,
+		"2_second.sql":     `CREATE TABLE second_table (id INTEGER PRIMARY KEY);`,
+		"3_third.sql":      `CREATE TABLE third_table (id INTEGER PRIMARY KEY);`,
+		"3_third.down.sql": `DROP TABLE third_table;`,
+	})
+
+	manager := NewMigrationManager(db, migrations)
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	if err := manager.Rollback(0); err == nil {
+		t.Fatalf("Expected Rollback to fail without a down file, but it succeeded")
+	}
+
+	// Nothing is touched when a migration in range can't be reverted
+	assertApplied(t, db, 1, 2, 3)
+	assertTables(t, db, map[string]bool{"first_table": true, "second_table": true, "third_table": true})
+}
+
+func TestRollbackRealMigrations(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, chores.Migrations())
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// Every shipped migration has to be reversible
+	if err := manager.Rollback(0); err != nil {
+		t.Fatalf("Failed to roll back all migrations: %v", err)
+	}
+	assertApplied(t, db)
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name != 'migrations' AND name NOT LIKE 'sqlite_%'").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count tables: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected only the migrations table to remain, found %d other tables", count)
+	}
+
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to re-run migrations after rollback: %v", err)
+	}
+}
+
+func TestMigrationChecksumRecorded(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	content := `CREATE TABLE first_table (id INTEGER PRIMARY KEY);`
+	manager := NewMigrationManager(db, testMigrations(map[string]string{"1_first.sql": content}))
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	var recorded string
+	if err := db.QueryRow("SELECT checksum FROM migrations WHERE migration_id = 1").Scan(&recorded); err != nil {
+		t.Fatalf("Failed to query checksum: %v", err)
+	}
+	if want := checksum([]byte(content)); recorded != want {
+		t.Errorf("Expected checksum %s, got %s", want, recorded)
+	}
+}
+
+func TestMigrationDrift(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	migrations := testMigrations(map[string]string{
+		"1_first.sql": `CREATE TABLE first_table (id INTEGER PRIMARY KEY);`,
+	})
+	if err := NewMigrationManager(db, migrations).RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// Edit the applied migration and add a pending one
+	migrations["1_first.sql"].Data = []byte(`CREATE TABLE first_table (id INTEGER PRIMARY KEY, name TEXT);`)
+	migrations["2_second.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE second_table (id INTEGER PRIMARY KEY);`)}
+
+	manager := NewMigrationManager(db, migrations)
+	err := manager.RunMigrations()
+	if !errors.Is(err, ErrMigrationDrift) {
+		t.Fatalf("Expected ErrMigrationDrift, got %v", err)
+	}
+	assertApplied(t, db, 1)
+
+	statuses, err := manager.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].State != MigrationDrifted || statuses[1].State != MigrationPending {
+		t.Errorf("Expected drifted and pending migrations, got %+v", statuses)
+	}
+
+	// Warning only lets the pending migration through
+	manager.SetDriftPolicy(DriftWarn)
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Expected drift to only warn, got %v", err)
+	}
+	assertApplied(t, db, 1, 2)
+}
+
+func TestMigrationChecksumBackfill(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// A migrations table from before checksums were recorded
+	_, err := db.Exec(`
+		CREATE TABLE migrations (
+			id INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			migration_id INTEGER UNIQUE NOT NULL
+		);
+		CREATE TABLE first_table (id INTEGER PRIMARY KEY);
+		INSERT INTO migrations (migration_id) VALUES (1);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create legacy migrations table: %v", err)
+	}
+
+	migrations := testMigrations(map[string]string{
+		"1_first.sql": `CREATE TABLE first_table (id INTEGER PRIMARY KEY);`,
+	})
+	manager := NewMigrationManager(db, migrations)
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// The current file is trusted and later edits are caught
+	migrations["1_first.sql"].Data = []byte(`CREATE TABLE first_table (id INTEGER);`)
+	if err := manager.RunMigrations(); !errors.Is(err, ErrMigrationDrift) {
+		t.Errorf("Expected ErrMigrationDrift after backfill, got %v", err)
+	}
+}
+
+func TestParseDriftPolicy(t *testing.T) {
+	tests := map[string]DriftPolicy{"": DriftFail, "fail": DriftFail, "WARN": DriftWarn}
+	for input, want := range tests {
+		got, err := ParseDriftPolicy(input)
+		if err != nil || got != want {
+			t.Errorf("ParseDriftPolicy(%q) = %v, %v, expected %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseDriftPolicy("ignore"); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
+
+func TestGoMigrationsInterleaved(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	migrations := testMigrations(map[string]string{
+		"1_first.sql":      `CREATE TABLE names (name TEXT NOT NULL);`,
+		"1_first.down.sql": `DROP TABLE names;`,
+		// Only works if the Go migration ran in between
+		"3_third.sql": `UPDATE names SET name = upper(name);`,
+	})
+
+	manager := NewMigrationManager(db, migrations)
+	err := manager.AddGoMigration(GoMigration{
+		ID:   2,
+		Name: "seed_names",
+		Up: func(tx *sql.Tx) error {
+			for _, name := range []string{"poul", "ulla"} {
+				if _, err := tx.Exec("INSERT INTO names (name) VALUES (?)", name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM names")
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add Go migration: %v", err)
+	}
+
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	assertApplied(t, db, 1, 2, 3)
+
+	var upper int
+	if err := db.QueryRow("SELECT COUNT(*) FROM names WHERE name IN ('POUL', 'ULLA')").Scan(&upper); err != nil {
+		t.Fatalf("Failed to query names: %v", err)
+	}
+	if upper != 2 {
+		t.Errorf("Expected 2 upper cased names, got %d", upper)
+	}
+
+	// 3 has no down file, so nothing can be rolled back past it
+	if err := manager.Rollback(1); err == nil {
+		t.Errorf("Expected Rollback over a migration without a down to fail")
+	}
+	noop := func(*sql.Tx) error { return nil }
+	if err := manager.AddGoMigration(GoMigration{ID: 2, Name: "again", Up: noop}); err == nil {
+		t.Errorf("Expected a duplicate Go migration to be rejected")
+	}
+}
+
+func TestGoMigrationValidation(t *testing.T) {
+	noop := func(*sql.Tx) error { return nil }
+	invalid := []GoMigration{
+		{ID: 0, Name: "zero", Up: noop},
+		{ID: -1, Name: "negative", Up: noop},
+		{ID: 1, Name: "no_up", Down: noop},
+	}
+
+	manager := NewMigrationManager(nil, fstest.MapFS{})
+	for _, migration := range invalid {
+		if err := manager.AddGoMigration(migration); err == nil {
+			t.Errorf("Expected AddGoMigration to reject %q", migration.Name)
+		}
+
+		// Invalid migrations panic before they reach the registry
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected RegisterMigration to panic on %q", migration.Name)
+				}
+			}()
+			RegisterMigration(migration)
+		}()
+	}
+	if len(registeredMigrations) != 0 {
+		t.Errorf("Expected no migrations to be registered, got %d", len(registeredMigrations))
+	}
+}
+
+func TestGoMigrationFailureTransactional(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	migrations := testMigrations(map[string]string{
+		"1_good.sql":  `CREATE TABLE good_table (id INTEGER PRIMARY KEY);`,
+		"3_never.sql": `CREATE TABLE never_table (id INTEGER PRIMARY KEY);`,
+	})
+
+	manager := NewMigrationManager(db, migrations)
+	err := manager.AddGoMigration(GoMigration{
+		ID:   2,
+		Name: "bad",
+		Up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("INSERT INTO good_table (id) VALUES (1)"); err != nil {
+				return err
+			}
+			return errors.New("backfill failed")
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add Go migration: %v", err)
+	}
+
+	if err := manager.RunMigrations(); err == nil {
+		t.Fatalf("Expected RunMigrations to fail, but it succeeded")
+	}
+
+	// The Go migration's writes are rolled back and 3 is never applied
+	assertApplied(t, db, 1)
+	assertTables(t, db, map[string]bool{"good_table": true, "never_table": false})
+
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM good_table").Scan(&rows); err != nil {
+		t.Fatalf("Failed to query good_table: %v", err)
+	}
+	if rows != 0 {
+		t.Errorf("Expected the failed Go migration's insert to be rolled back, found %d rows", rows)
+	}
+}
+
+func TestGoMigrationRollback(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, fstest.MapFS{})
+	err := manager.AddGoMigration(GoMigration{
+		ID:   1,
+		Name: "create",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE go_table (id INTEGER PRIMARY KEY)")
+			return err
+		},
+		Down: func(tx *sql.Tx) error {
+			_, err := tx.Exec("DROP TABLE go_table")
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add Go migration: %v", err)
+	}
+
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := manager.Rollback(0); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	assertApplied(t, db)
+	assertTables(t, db, map[string]bool{"go_table": false})
+}
+
+func TestGoMigrationConflictsWithFile(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, testMigrations(map[string]string{
+		"1_first.sql": `CREATE TABLE first_table (id INTEGER PRIMARY KEY);`,
+	}))
+	if err := manager.AddGoMigration(GoMigration{ID: 1, Name: "first", Up: func(*sql.Tx) error { return nil }}); err != nil {
+		t.Fatalf("Failed to add Go migration: %v", err)
+	}
+
+	if err := manager.RunMigrations(); err == nil {
+		t.Fatalf("Expected a Go migration sharing an ID with a file to fail")
+	}
+	assertTables(t, db, map[string]bool{"first_table": false})
+}
+
+func TestValidateMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		applied []int
+		errs    []error
+		hidden  []error
+	}{
+		{
+			name:  "valid",
+			files: map[string]string{"1_first.sql": "", "2_second.sql": "", "2_second.down.sql": ""},
+		},
+		{
+			name:  "duplicate ID",
+			files: map[string]string{"1_first.sql": "", "2_second.sql": "", "2_other.sql": ""},
+			errs:  []error{ErrDuplicateMigration},
+		},
+		{
+			name:  "duplicate down file",
+			files: map[string]string{"1_first.sql": "", "1_first.down.sql": "", "1_other.down.sql": ""},
+			errs:  []error{ErrDuplicateMigration},
+		},
+		{
+			name:  "gap",
+			files: map[string]string{"1_first.sql": "", "2_second.sql": "", "4_fourth.sql": ""},
+			errs:  []error{ErrMigrationGap},
+		},
+		{
+			name:  "not starting at 1",
+			files: map[string]string{"2_second.sql": ""},
+			errs:  []error{ErrMigrationGap},
+		},
+		{
+			name:  "non-numeric prefix",
+			files: map[string]string{"1_first.sql": "", "two_second.sql": ""},
+			errs:  []error{ErrInvalidMigrationName},
+		},
+		{
+			name:  "no name",
+			files: map[string]string{"1_first.sql": "", "2.sql": ""},
+			errs:  []error{ErrInvalidMigrationName},
+		},
+		{
+			name:  "zero padded prefix",
+			files: map[string]string{"1_first.sql": "", "02_second.sql": ""},
+			errs:  []error{ErrInvalidMigrationName},
+		},
+		{
+			name:    "applied without file",
+			files:   map[string]string{"1_first.sql": ""},
+			applied: []int{1, 2},
+			errs:    []error{ErrMissingMigration},
+		},
+		{
+			name:  "every file problem at once",
+			files: map[string]string{"1_first.sql": "", "1_again.sql": "", "3_third.sql": "", "x_bad.sql": ""},
+			errs:  []error{ErrDuplicateMigration, ErrMigrationGap, ErrInvalidMigrationName},
+		},
+		{
+			// Missing files are only looked for once the files themselves are fine
+			name:    "file problems before missing files",
+			files:   map[string]string{"1_first.sql": "", "3_third.sql": ""},
+			applied: []int{7},
+			errs:    []error{ErrMigrationGap},
+			hidden:  []error{ErrMissingMigration},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _, cleanup := setupTestDB(t)
+			defer cleanup()
+
+			// Every migration creates a table so anything applied shows up
+			files := make(map[string]string, len(tt.files))
+			for name := range tt.files {
+				table := "t_" + strings.NewReplacer(".", "_").Replace(name)
+				files[name] = "CREATE TABLE " + table + " (id INTEGER PRIMARY KEY);"
+			}
+
+			manager := NewMigrationManager(db, testMigrations(files))
+			if err := manager.EnsureMigrationsTable(); err != nil {
+				t.Fatalf("Failed to ensure migrations table: %v", err)
+			}
+			for _, id := range tt.applied {
+				if _, err := db.Exec("INSERT INTO migrations (migration_id) VALUES (?)", id); err != nil {
+					t.Fatalf("Failed to record migration %d: %v", id, err)
+				}
+			}
+
+			err := manager.RunMigrations()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatalf("Expected valid migrations, got %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Expected RunMigrations to fail, but it succeeded")
+			}
+			for _, want := range tt.errs {
+				if !errors.Is(err, want) {
+					t.Errorf("Expected error to include %q, got %v", want, err)
+				}
+			}
+			for _, unwanted := range tt.hidden {
+				if errors.Is(err, unwanted) {
+					t.Errorf("Expected error not to include %q yet, got %v", unwanted, err)
+				}
+			}
+			if rollbackErr := manager.Rollback(0); rollbackErr == nil {
+				t.Errorf("Expected Rollback to fail on the same migrations")
+			}
+
+			// Nothing is applied when the set is invalid
+			var tables int
+			err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name LIKE 't\\_%' ESCAPE '\\'").Scan(&tables)
+			if err != nil {
+				t.Fatalf("Failed to count tables: %v", err)
+			}
+			if tables != 0 {
+				t.Errorf("Expected no migrations to be applied, found %d tables", tables)
+			}
+		})
+	}
+}
+
+func TestValidateDoesNotCreateMigrationsTable(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, testMigrations(map[string]string{"1_first.sql": ""}))
+	if err := manager.Validate(); err != nil {
+		t.Fatalf("Expected valid migrations, got %v", err)
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'migrations'").Scan(&tables); err != nil {
+		t.Fatalf("Failed to look for the migrations table: %v", err)
+	}
+	if tables != 0 {
+		t.Errorf("Expected Validate to leave the database untouched")
+	}
+}
+
+func TestRunMigrationsBacksUp(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	migrations := testMigrations(map[string]string{
+		"1_first.sql": `CREATE TABLE first_table (id INTEGER PRIMARY KEY);`,
+	})
+	manager := NewMigrationManager(db, migrations)
+
+	backups := 0
+	manager.SetBackup(func() error {
+		backups++
+		return nil
+	})
+
+	// A fresh database has nothing worth saving
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if backups != 0 {
+		t.Errorf("Expected no backup of a fresh database, got %d", backups)
+	}
+
+	// Nor does an up to date one need saving
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if backups != 0 {
+		t.Errorf("Expected no backup without pending migrations, got %d", backups)
+	}
+
+	migrations["2_second.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE second_table (id INTEGER PRIMARY KEY);`)}
+	migrations["3_third.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE third_table (id INTEGER PRIMARY KEY);`)}
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if backups != 1 {
+		t.Errorf("Expected one backup before the pending migrations, got %d", backups)
+	}
+
+	// A failed backup stops the migrations
+	migrations["4_fourth.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE fourth_table (id INTEGER PRIMARY KEY);`)}
+	manager.SetBackup(func() error { return errors.New("disk full") })
+	if err := manager.RunMigrations(); err == nil {
+		t.Fatalf("Expected RunMigrations to fail when the backup fails")
+	}
+	assertApplied(t, db, 1, 2, 3)
+}
+
+// assertApplied checks that exactly the given migration IDs are recorded
+func assertApplied(t *testing.T, db *sql.DB, ids ...int) {
+	t.Helper()
+
+	manager := NewMigrationManager(db, fstest.MapFS{})
+	applied, err := manager.GetAppliedMigrations()
+	if err != nil {
+		t.Fatalf("Failed to get applied migrations: %v", err)
+	}
+
+	if len(applied) != len(ids) {
+		t.Errorf("Expected %d applied migrations, got %d", len(ids), len(applied))
+	}
+	for _, id := range ids {
+		if !applied[id] {
+			t.Errorf("Expected migration %d to be applied", id)
+		}
+	}
+}
+
+// assertTables checks whether each named table exists
+func assertTables(t *testing.T, db *sql.DB, tables map[string]bool) {
+	t.Helper()
+
+	for table, want := range tables {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&count)
+		if err != nil {
+			t.Fatalf("Failed to query for table %s: %v", table, err)
+		}
+		if exists := count == 1; exists != want {
+			t.Errorf("Expected table %s to exist: %v, got %v", table, want, exists)
+		}
+	}
+}
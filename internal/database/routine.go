@@ -1,16 +1,21 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
 	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
 )
 
 // GetRoutines retrieves all routines from the database for a specific user
-func GetRoutines(db *sql.DB, userID int64) ([]models.Routine, error) {
-	rows, err := db.Query(`
-		SELECT r.id, r.created, r.modified, r.owner_id, r.routine_blueprint_id, -- Added r.routine_blueprint_id
+func GetRoutines(ctx context.Context, db *sql.DB, userID int64) ([]models.Routine, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.id, r.created, r.modified, r.owner_id, r.routine_blueprint_id, r.day, r.missed, -- Added r.routine_blueprint_id
 		       u.name as owner_name, 
 		       rb.image as image_url
 		FROM routines r
@@ -28,22 +33,21 @@ func GetRoutines(db *sql.DB, userID int64) ([]models.Routine, error) {
 	for rows.Next() {
 		var r models.Routine
 		var owner models.User
-		var created, modified string
 		var imageUrl sql.NullString
 		err := rows.Scan(
 			&r.ID,
-			&created,
-			&modified,
+			&r.Created,
+			&r.Modified,
 			&r.OwnerID,
 			&r.RoutineBlueprintID, // Scan the new field
+			&r.Day,
+			&r.Missed,
 			&owner.Name,
 			&imageUrl,
 		)
 		if err != nil {
 			return nil, err
 		}
-		r.Created, _ = time.Parse(time.RFC3339, created)
-		r.Modified, _ = time.Parse(time.RFC3339, modified)
 		r.Owner = &owner
 		if imageUrl.Valid {
 			r.ImageUrl = imageUrl.String
@@ -56,13 +60,15 @@ func GetRoutines(db *sql.DB, userID int64) ([]models.Routine, error) {
 }
 
 // GetRoutine retrieves a single routine by ID
-func GetRoutine(db *sql.DB, id int64) (*models.Routine, error) {
+func GetRoutine(ctx context.Context, db *sql.DB, id int64) (*models.Routine, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	var r models.Routine
 	var owner models.User
-	var created, modified string
 	var imageUrl sql.NullString
-	err := db.QueryRow(`
-		SELECT r.id, r.created, r.modified, r.owner_id, r.routine_blueprint_id, -- Added r.routine_blueprint_id
+	err := db.QueryRowContext(ctx, `
+		SELECT r.id, r.created, r.modified, r.owner_id, r.routine_blueprint_id, r.day, r.missed, -- Added r.routine_blueprint_id
 		       u.name as owner_name, 
 		       rb.image as image_url
 		FROM routines r
@@ -71,10 +77,12 @@ func GetRoutine(db *sql.DB, id int64) (*models.Routine, error) {
 		WHERE r.id = ?
 	`, id).Scan(
 		&r.ID,
-		&created,
-		&modified,
+		&r.Created,
+		&r.Modified,
 		&r.OwnerID,
 		&r.RoutineBlueprintID, // Scan the new field
+		&r.Day,
+		&r.Missed,
 		&owner.Name,
 		&imageUrl,
 	)
@@ -84,8 +92,6 @@ func GetRoutine(db *sql.DB, id int64) (*models.Routine, error) {
 	if err != nil {
 		return nil, err
 	}
-	r.Created, _ = time.Parse(time.RFC3339, created)
-	r.Modified, _ = time.Parse(time.RFC3339, modified)
 	r.Owner = &owner
 	if imageUrl.Valid {
 		r.ImageUrl = imageUrl.String
@@ -96,8 +102,11 @@ func GetRoutine(db *sql.DB, id int64) (*models.Routine, error) {
 }
 
 // CreateRoutine creates a new routine
-func CreateRoutine(db *sql.DB, routine *models.Routine) error {
-	now := time.Now().UTC().Format(time.RFC3339)
+func CreateRoutine(ctx context.Context, db *sql.DB, routine *models.Routine) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
 
 	// Prepare the routine_blueprint_id for the query
 	var blueprintID interface{}
@@ -107,14 +116,15 @@ func CreateRoutine(db *sql.DB, routine *models.Routine) error {
 		blueprintID = nil
 	}
 
-	result, err := db.Exec(`
-		INSERT INTO routines (created, modified, owner_id, routine_blueprint_id)
-		VALUES (?, ?, ?, ?)
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO routines (created, modified, owner_id, routine_blueprint_id, day)
+		VALUES (?, ?, ?, ?, ?)
 	`,
 		now,
 		now,
 		routine.OwnerID,
 		blueprintID,
+		routine.Day,
 	)
 	if err != nil {
 		return err
@@ -126,16 +136,132 @@ func CreateRoutine(db *sql.DB, routine *models.Routine) error {
 	}
 
 	routine.ID = id
-	routine.Created, _ = time.Parse(time.RFC3339, now)
+	routine.Created = now
 	routine.Modified = routine.Created
 
 	return nil
 }
 
+// StartRoutine starts the blueprint's routine for the owner on day, with an
+// unfinished chore routine for each of the blueprint's chores. Unless the
+// blueprint allows several routines a day, the routine already started that
+// day is returned instead, with started false. It returns nil if there is no
+// such blueprint
+func StartRoutine(ctx context.Context, db *sql.DB, ownerID, blueprintID int64, day schedule.Date) (routine *models.Routine, started bool, err error) {
+	return startRoutine(ctx, db, ownerID, blueprintID, day, false)
+}
+
+// EnsureRoutine is like StartRoutine, but returns the first routine started
+// that day even when the blueprint allows several, so running it again never
+// starts another
+func EnsureRoutine(ctx context.Context, db *sql.DB, ownerID, blueprintID int64, day schedule.Date) (routine *models.Routine, started bool, err error) {
+	return startRoutine(ctx, db, ownerID, blueprintID, day, true)
+}
+
+// startRoutine starts a routine as StartRoutine does. With reuse, a routine
+// already started that day is returned for any blueprint
+func startRoutine(ctx context.Context, db *sql.DB, ownerID, blueprintID int64, day schedule.Date, reuse bool) (*models.Routine, bool, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	// Transactions take the write lock up front, so two taps on the same card
+	// can't both miss the other's routine
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var allowMultiple bool
+	var image string
+	err = tx.QueryRowContext(ctx, "SELECT allow_multiple_instances_per_day, image FROM routine_blueprints WHERE id = ?", blueprintID).Scan(&allowMultiple, &image)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	r := models.Routine{
+		OwnerID:            ownerID,
+		RoutineBlueprintID: sql.NullInt64{Int64: blueprintID, Valid: true},
+		ImageUrl:           image,
+		Day:                day,
+	}
+
+	if reuse || !allowMultiple {
+		err = tx.QueryRowContext(ctx, `
+			SELECT id, created, modified FROM routines
+			WHERE owner_id = ? AND routine_blueprint_id = ? AND day = ?
+			ORDER BY id
+			LIMIT 1
+		`, ownerID, blueprintID, day).Scan(&r.ID, &r.Created, &r.Modified)
+		if err == nil {
+			return &r, false, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, false, err
+		}
+	}
+
+	now := models.NewTimestamp(time.Now())
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO routines (created, modified, owner_id, routine_blueprint_id, day, once_per_day)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, now, now, ownerID, blueprintID, day, !allowMultiple)
+	if err != nil {
+		return nil, false, err
+	}
+	if r.ID, err = result.LastInsertId(); err != nil {
+		return nil, false, err
+	}
+	r.Created = now
+	r.Modified = now
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chore_routines (created, modified, points_awarded, routine_id, chore_id)
+		SELECT ?, ?, c.default_points, ?, c.id
+		FROM routine_blueprint_chores rbc
+		JOIN chores c ON rbc.chore_id = c.id
+		WHERE rbc.routine_blueprint_id = ?
+	`, now, now, r.ID, blueprintID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return &r, true, nil
+}
+
+// MarkMissedRoutines marks routines from days before day that still have
+// unfinished chores as missed, and returns how many it marked. Routines
+// already marked are left alone, so it can run any number of times
+func MarkMissedRoutines(ctx context.Context, db *sql.DB, before schedule.Date) (int64, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, `
+		UPDATE routines SET missed = 1, modified = ?
+		WHERE day < ? AND NOT missed AND EXISTS (
+			SELECT 1 FROM chore_routines cr
+			WHERE cr.routine_id = routines.id AND cr.completed_at IS NULL
+		)
+	`, models.NewTimestamp(time.Now()), before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // GetChoreCountsForRoutine counts the total number of chores and completed chores for a routine
-func GetChoreCountsForRoutine(db *sql.DB, routineID int64) (total int, completed int, err error) {
+func GetChoreCountsForRoutine(ctx context.Context, db *sql.DB, routineID int64) (total int, completed int, err error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	// Query to count total chores and completed chores for the routine
-	row := db.QueryRow(`
+	row := db.QueryRowContext(ctx, `
 		SELECT 
 			COUNT(*), 
 			COUNT(CASE WHEN completed_at IS NOT NULL THEN 1 END)
@@ -148,20 +274,21 @@ func GetChoreCountsForRoutine(db *sql.DB, routineID int64) (total int, completed
 	return total, completed, err
 }
 
-// GetRelevantRoutines retrieves routines created before today for a specific user
-func GetRelevantRoutines(db *sql.DB, userID int64, today time.Time) ([]models.Routine, error) {
-	// Convert today to UTC and strip time part to get start of day
-	startOfDay := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+// GetRelevantRoutines retrieves a user's routines for the given household day.
+// The caller picks the day, as it depends on the household's time zone
+func GetRelevantRoutines(ctx context.Context, db *sql.DB, userID int64, day schedule.Date) ([]models.Routine, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
 
-	rows, err := db.Query(`
-		SELECT r.id, r.created, r.modified, r.owner_id, r.routine_blueprint_id,
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.id, r.created, r.modified, r.owner_id, r.routine_blueprint_id, r.day, r.missed,
 		       NULL as owner_name, 
 		       rb.image as image_url
 		FROM routines r
 		LEFT JOIN routine_blueprints rb ON r.routine_blueprint_id = rb.id
-		WHERE r.owner_id = ? AND r.created > ?
+		WHERE r.owner_id = ? AND r.day = ?
 		ORDER BY r.created DESC
-	`, userID, startOfDay)
+	`, userID, day)
 	if err != nil {
 		return nil, err
 	}
@@ -171,23 +298,22 @@ func GetRelevantRoutines(db *sql.DB, userID int64, today time.Time) ([]models.Ro
 	for rows.Next() {
 		var r models.Routine
 		var owner models.User
-		var created, modified string
 		var imageUrl sql.NullString
 		var ownerName sql.NullString
 		err := rows.Scan(
 			&r.ID,
-			&created,
-			&modified,
+			&r.Created,
+			&r.Modified,
 			&r.OwnerID,
 			&r.RoutineBlueprintID,
+			&r.Day,
+			&r.Missed,
 			&ownerName,
 			&imageUrl,
 		)
 		if err != nil {
 			return nil, err
 		}
-		r.Created, _ = time.Parse(time.RFC3339, created)
-		r.Modified, _ = time.Parse(time.RFC3339, modified)
 
 		// Create a basic owner with just the ID
 		owner.ID = r.OwnerID
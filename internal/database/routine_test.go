@@ -0,0 +1,320 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/bagvendt/chores"
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
+)
+
+func TestGetRelevantRoutinesCancelled(t *testing.T) {
+	db, _ := openPools(t)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	// A client that has gone away must not leave the query running
+	if _, err := GetRelevantRoutines(ctx, db, 1, schedule.DateOf(time.Now())); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, err := GetRelevantRoutines(t.Context(), db, 1, schedule.DateOf(time.Now())); err != nil {
+		t.Errorf("Expected a live context to work, got %v", err)
+	}
+}
+
+func TestGetRelevantRoutinesDay(t *testing.T) {
+	writer, reader := openPools(t)
+
+	var userID int64
+	if err := writer.QueryRow("SELECT id FROM users LIMIT 1").Scan(&userID); err != nil {
+		t.Fatalf("Failed to find a user: %v", err)
+	}
+
+	// The household day decides, not when the row was created. Just after
+	// midnight in Copenhagen it is still the day before in UTC
+	monday := schedule.Date{Year: 2024, Month: time.April, Day: 1}
+	routines := []struct {
+		name    string
+		created time.Time
+		day     schedule.Date
+	}{
+		{"started just after midnight", time.Date(2024, 3, 31, 22, 10, 0, 0, time.UTC), monday},
+		{"started later on Monday", time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC), monday},
+		{"left over from Sunday", time.Date(2024, 3, 31, 21, 50, 0, 0, time.UTC), monday.AddDays(-1)},
+		{"for Tuesday", time.Date(2024, 4, 1, 22, 5, 0, 0, time.UTC), monday.AddDays(1)},
+	}
+	ids := make(map[int64]string)
+	for _, routine := range routines {
+		result, err := writer.Exec("INSERT INTO routines (created, modified, owner_id, day) VALUES (?, ?, ?, ?)",
+			models.NewTimestamp(routine.created), models.NewTimestamp(routine.created), userID, routine.day)
+		if err != nil {
+			t.Fatalf("Failed to create routine: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		ids[id] = routine.name
+	}
+
+	relevant, err := GetRelevantRoutines(t.Context(), reader, userID, monday)
+	if err != nil {
+		t.Fatalf("Failed to get relevant routines: %v", err)
+	}
+	found := make(map[string]bool)
+	for _, routine := range relevant {
+		if name, ok := ids[routine.ID]; ok {
+			found[name] = true
+		}
+	}
+	for _, routine := range routines {
+		if want := routine.day == monday; found[routine.name] != want {
+			t.Errorf("Expected the routine %s to be relevant: %v, got %v", routine.name, want, found[routine.name])
+		}
+	}
+}
+
+func TestStartRoutine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chores.db")
+	writer, _ := openPoolsAt(t, path)
+	// A second process on the same file, like the server during a deploy
+	otherWriter, _ := openPoolsAt(t, path)
+
+	var userID int64
+	if err := writer.QueryRow("SELECT id FROM users LIMIT 1").Scan(&userID); err != nil {
+		t.Fatalf("Failed to find a user: %v", err)
+	}
+	var blueprintChores int
+	if err := writer.QueryRow("SELECT COUNT(*) FROM routine_blueprint_chores WHERE routine_blueprint_id = 1").Scan(&blueprintChores); err != nil {
+		t.Fatalf("Failed to count blueprint chores: %v", err)
+	}
+	monday := schedule.Date{Year: 2024, Month: time.April, Day: 1}
+
+	// Blueprint 1 allows one routine a day, so every tap gets the same one
+	const taps = 10
+	routines := make(chan *models.Routine, taps)
+	var startedCount int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < taps; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db := writer
+			if i%2 == 1 {
+				db = otherWriter
+			}
+			routine, started, err := StartRoutine(t.Context(), db, userID, 1, monday)
+			if err != nil {
+				t.Errorf("Failed to start routine: %v", err)
+				return
+			}
+			if started {
+				mu.Lock()
+				startedCount++
+				mu.Unlock()
+			}
+			routines <- routine
+		}(i)
+	}
+	wg.Wait()
+	close(routines)
+
+	var first *models.Routine
+	for routine := range routines {
+		if first == nil {
+			first = routine
+		}
+		if routine.ID != first.ID || routine.Day != monday {
+			t.Errorf("Expected every tap to give routine %d on %s, got %d on %s", first.ID, monday, routine.ID, routine.Day)
+		}
+	}
+	if startedCount != 1 {
+		t.Errorf("Expected the routine to be started once, got %d", startedCount)
+	}
+
+	var choreRoutines int
+	if err := writer.QueryRow("SELECT COUNT(*) FROM chore_routines WHERE routine_id = ?", first.ID).Scan(&choreRoutines); err != nil {
+		t.Fatalf("Failed to count chore routines: %v", err)
+	}
+	if choreRoutines != blueprintChores {
+		t.Errorf("Expected %d chore routines, got %d", blueprintChores, choreRoutines)
+	}
+
+	// The index holds even for writes that skip StartRoutine
+	_, err := writer.Exec("INSERT INTO routines (owner_id, routine_blueprint_id, day, once_per_day) VALUES (?, 1, ?, 1)", userID, monday)
+	if err == nil {
+		t.Errorf("Expected a second routine on the same day to be rejected")
+	}
+
+	// The next day gets its own routine
+	tuesday, started, err := StartRoutine(t.Context(), writer, userID, 1, monday.AddDays(1))
+	if err != nil || !started || tuesday.ID == first.ID {
+		t.Errorf("Expected a new routine on Tuesday, got %+v, %v (%v)", tuesday, started, err)
+	}
+
+	// Blueprints that allow several a day start a new one every time
+	if _, err := writer.Exec("UPDATE routine_blueprints SET allow_multiple_instances_per_day = 1 WHERE id = 2"); err != nil {
+		t.Fatalf("Failed to update blueprint: %v", err)
+	}
+	one, _, err := StartRoutine(t.Context(), writer, userID, 2, monday)
+	if err != nil {
+		t.Fatalf("Failed to start routine: %v", err)
+	}
+	two, started, err := StartRoutine(t.Context(), writer, userID, 2, monday)
+	if err != nil || !started || two.ID == one.ID {
+		t.Errorf("Expected a second routine, got %+v, %v (%v)", two, started, err)
+	}
+
+	if routine, _, err := StartRoutine(t.Context(), writer, userID, 999, monday); routine != nil || err != nil {
+		t.Errorf("Expected nil for a missing blueprint, got %+v (%v)", routine, err)
+	}
+}
+
+func TestMarkMissedRoutines(t *testing.T) {
+	store := openStore(t)
+
+	var userID int64
+	if err := store.DB.QueryRow("SELECT id FROM users LIMIT 1").Scan(&userID); err != nil {
+		t.Fatalf("Failed to find a user: %v", err)
+	}
+	monday := schedule.Date{Year: 2024, Month: time.April, Day: 1}
+	tuesday := monday.AddDays(1)
+
+	unfinished, _, err := StartRoutine(t.Context(), store.DB, userID, 1, monday)
+	if err != nil {
+		t.Fatalf("Failed to start routine: %v", err)
+	}
+	finished, _, err := StartRoutine(t.Context(), store.DB, userID, 2, monday)
+	if err != nil {
+		t.Fatalf("Failed to start routine: %v", err)
+	}
+	if _, err := store.DB.Exec("UPDATE chore_routines SET completed_at = '2024-04-01T06:00:00Z' WHERE routine_id = ?", finished.ID); err != nil {
+		t.Fatalf("Failed to finish routine: %v", err)
+	}
+	today, _, err := StartRoutine(t.Context(), store.DB, userID, 1, tuesday)
+	if err != nil {
+		t.Fatalf("Failed to start routine: %v", err)
+	}
+
+	// Only Monday's unfinished routine is missed, and only once
+	for _, want := range []int64{1, 0} {
+		marked, err := MarkMissedRoutines(t.Context(), store.DB, tuesday)
+		if err != nil || marked != want {
+			t.Errorf("Expected %d routines marked, got %d (%v)", want, marked, err)
+		}
+	}
+	for _, routine := range []*models.Routine{unfinished, finished, today} {
+		saved, err := GetRoutine(t.Context(), store.DB, routine.ID)
+		if err != nil {
+			t.Fatalf("Failed to get routine: %v", err)
+		}
+		if want := routine == unfinished; saved.Missed != want {
+			t.Errorf("Expected routine %d on %s to be missed: %v, got %v", routine.ID, routine.Day, want, saved.Missed)
+		}
+	}
+}
+
+func TestRoutineDaysMigration(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, chores.Migrations())
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := manager.Rollback(12); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+
+	// Duplicates from before the rule must not stop the migration
+	_, err := db.Exec(`
+		INSERT INTO routines (id, created, modified, owner_id, routine_blueprint_id) VALUES
+			(100, '2024-04-01T06:00:00Z', '2024-04-01T06:00:00Z', 1, 1),
+			(101, '2024-04-01T06:05:00Z', '2024-04-01T06:05:00Z', 1, 1);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to insert old routines: %v", err)
+	}
+
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to re-run migrations: %v", err)
+	}
+
+	for _, id := range []int64{100, 101} {
+		routine, err := GetRoutine(t.Context(), db, id)
+		if err != nil {
+			t.Fatalf("Failed to get routine %d: %v", id, err)
+		}
+		if want := (schedule.Date{Year: 2024, Month: time.April, Day: 1}); routine.Day != want {
+			t.Errorf("Expected routine %d to be on %s, got %s", id, want, routine.Day)
+		}
+	}
+}
+
+func TestQueryTimeoutAbortsSlowQuery(t *testing.T) {
+	_, reader := openPools(t)
+
+	previous := queryTimeout
+	SetQueryTimeout(50 * time.Millisecond)
+	t.Cleanup(func() { SetQueryTimeout(previous) })
+
+	ctx, cancel := WithQueryTimeout(t.Context())
+	defer cancel()
+
+	// Counting to a billion takes far longer than the timeout
+	start := time.Now()
+	var n int64
+	err := reader.QueryRowContext(ctx, `
+		WITH RECURSIVE count(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM count WHERE n < 1000000000)
+		SELECT max(n) FROM count
+	`).Scan(&n)
+	if err == nil {
+		t.Fatalf("Expected the query to be aborted, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the query to stop soon after the timeout, took %v", elapsed)
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to have passed, got %v", ctx.Err())
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultQueryTimeout, false},
+		{"250ms", 250 * time.Millisecond, false},
+		{"0", 0, false},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(QueryTimeoutEnv, tt.value)
+
+			got, err := QueryTimeout()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
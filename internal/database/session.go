@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bagvendt/chores/internal/models"
+)
+
+// CreateSession stores a new session
+func CreateSession(ctx context.Context, db *sql.DB, session *models.Session) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
+
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO sessions (token_hash, user_id, created, last_seen, expires, user_agent, csrf_token)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`,
+		session.TokenHash,
+		session.UserID,
+		now,
+		now,
+		session.Expires,
+		sql.NullString{String: session.UserAgent, Valid: session.UserAgent != ""},
+		session.CSRFToken,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	session.ID = id
+	session.Created = now
+	session.LastSeen = now
+
+	return nil
+}
+
+// GetSessionByTokenHash returns the unexpired session with the given token hash
+// along with its user, or nil if there is no such session
+func GetSessionByTokenHash(ctx context.Context, db *sql.DB, tokenHash string, now time.Time) (*models.Session, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var session models.Session
+	var user models.User
+	var userAgent sql.NullString
+
+	err := db.QueryRowContext(ctx, `
+		SELECT s.id, s.token_hash, s.user_id, s.created, s.last_seen, s.expires, s.user_agent, s.csrf_token,
+		       u.id, u.created, u.modified, u.name, u.is_admin, u.role, u.active
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.token_hash = ? AND s.expires > ? AND u.active = 1
+	`, tokenHash, models.NewTimestamp(now)).Scan(
+		&session.ID,
+		&session.TokenHash,
+		&session.UserID,
+		&session.Created,
+		&session.LastSeen,
+		&session.Expires,
+		&userAgent,
+		&session.CSRFToken,
+		&user.ID,
+		&user.Created,
+		&user.Modified,
+		&user.Name,
+		&user.IsAdmin,
+		&user.Role,
+		&user.Active,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if userAgent.Valid {
+		session.UserAgent = userAgent.String
+	}
+
+	session.User = &user
+
+	return &session, nil
+}
+
+// TouchSession records activity on a session and pushes its expiry forward
+func TouchSession(ctx context.Context, db *sql.DB, id int64, lastSeen time.Time, expires time.Time) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		UPDATE sessions
+		SET last_seen = ?, expires = ?
+		WHERE id = ?
+	`,
+		models.NewTimestamp(lastSeen),
+		models.NewTimestamp(expires),
+		id,
+	)
+	return err
+}
+
+// DeleteSession deletes the session with the given token hash
+func DeleteSession(ctx context.Context, db *sql.DB, tokenHash string) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, "DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// DeleteSessionsForUser deletes every session belonging to the given user
+func DeleteSessionsForUser(ctx context.Context, db *sql.DB, userID int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteExpiredSessions deletes all sessions that expired before now and
+// returns how many were removed
+func DeleteExpiredSessions(ctx context.Context, db *sql.DB, now time.Time) (int64, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "DELETE FROM sessions WHERE expires <= ?", models.NewTimestamp(now))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
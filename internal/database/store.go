@@ -0,0 +1,380 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
+)
+
+// ChoreRepository stores chores
+type ChoreRepository interface {
+	List(ctx context.Context) ([]models.Chore, error)
+	// Get returns nil if there is no such chore
+	Get(ctx context.Context, id int64) (*models.Chore, error)
+	Create(ctx context.Context, chore *models.Chore) error
+	Update(ctx context.Context, chore *models.Chore) error
+	// Delete returns ErrInUse while a blueprint or routine uses the chore
+	Delete(ctx context.Context, id int64) error
+}
+
+// BlueprintRepository stores routine blueprints and the chores they're made of
+type BlueprintRepository interface {
+	List(ctx context.Context) ([]models.RoutineBlueprint, error)
+	// Get returns nil if there is no such blueprint
+	Get(ctx context.Context, id int64) (*models.RoutineBlueprint, []models.RoutineBlueprintChore, error)
+	Chores(ctx context.Context, blueprintID int64) ([]models.RoutineBlueprintChore, error)
+	Create(ctx context.Context, blueprint *models.RoutineBlueprint, choreIDs []int64) error
+	Update(ctx context.Context, blueprint *models.RoutineBlueprint, choreIDs []int64) error
+	// Delete returns ErrInUse while a routine was made from the blueprint
+	Delete(ctx context.Context, id int64) error
+}
+
+// RoutineRepository stores routines
+type RoutineRepository interface {
+	List(ctx context.Context, userID int64) ([]models.Routine, error)
+	// Get returns nil if there is no such routine
+	Get(ctx context.Context, id int64) (*models.Routine, error)
+	Create(ctx context.Context, routine *models.Routine) error
+	// Start starts the blueprint's routine for the owner on day, or returns
+	// the one already started if the blueprint allows only one a day
+	Start(ctx context.Context, ownerID, blueprintID int64, day schedule.Date) (routine *models.Routine, started bool, err error)
+	// Ensure starts the blueprint's routine for the owner on day unless one
+	// was already started that day, even if the blueprint allows several
+	Ensure(ctx context.Context, ownerID, blueprintID int64, day schedule.Date) (routine *models.Routine, started bool, err error)
+	// MarkMissed marks unfinished routines from before day as missed
+	MarkMissed(ctx context.Context, before schedule.Date) (int64, error)
+	// Relevant returns the user's routines for the given household day
+	Relevant(ctx context.Context, userID int64, day schedule.Date) ([]models.Routine, error)
+}
+
+// ChoreRoutineRepository stores the chores of routines and whether they're done
+type ChoreRoutineRepository interface {
+	ForRoutine(ctx context.Context, routineID int64) ([]models.ChoreRoutine, error)
+	Counts(ctx context.Context, routineID int64) (total int, completed int, err error)
+	Upsert(ctx context.Context, routineID int64, choreID int64, completed bool, userID int64) (*models.ChoreRoutine, error)
+}
+
+// UserRepository stores users and who is whose parent
+type UserRepository interface {
+	List(ctx context.Context) ([]models.User, error)
+	// Get returns nil if there is no such user
+	Get(ctx context.Context, id int64) (*models.User, error)
+	// Create and Update save the user and the children they can act on
+	// behalf of together
+	Create(ctx context.Context, user *models.User, childIDs []int64) error
+	Update(ctx context.Context, user *models.User, childIDs []int64) error
+	// SetPassword returns ErrUserNotFound if there is no such user
+	SetPassword(ctx context.Context, id int64, hashedPassword string) error
+	SetPIN(ctx context.Context, id int64, hashedPIN string) error
+	SetActive(ctx context.Context, id int64, active bool) error
+	PINLoginUsers(ctx context.Context) ([]models.User, error)
+	Children(ctx context.Context, parentID int64) ([]models.User, error)
+	IsParentOf(ctx context.Context, parentID int64, childID int64) (bool, error)
+	// Credentials returns the active user with the given name and their
+	// password hash, or nil if there is no such user
+	Credentials(ctx context.Context, name string) (*models.User, error)
+	// PIN returns the active user with the given ID, their PIN hash and how
+	// long PIN login is locked, or a nil user if there is no such user
+	PIN(ctx context.Context, id int64) (user *models.User, hashedPIN string, lockedUntil *models.Timestamp, err error)
+	// RecordPINFailure counts a wrong PIN and locks PIN login until
+	// lockedUntil once maxAttempts is reached
+	RecordPINFailure(ctx context.Context, id int64, maxAttempts int, lockedUntil time.Time) (locked bool, err error)
+	ResetPINFailures(ctx context.Context, id int64) error
+}
+
+// SessionRepository stores sessions keyed by the hash of their token
+type SessionRepository interface {
+	// Create stores a new session and assigns its ID
+	Create(ctx context.Context, session *models.Session) error
+	// GetByTokenHash returns the session with the given token hash if it hasn't
+	// expired at now, or nil if there is no such session
+	GetByTokenHash(ctx context.Context, tokenHash string, now time.Time) (*models.Session, error)
+	// Touch records activity on a session and moves its expiry
+	Touch(ctx context.Context, id int64, lastSeen time.Time, expires time.Time) error
+	// Delete removes the session with the given token hash
+	Delete(ctx context.Context, tokenHash string) error
+	// DeleteForUser removes every session belonging to the given user
+	DeleteForUser(ctx context.Context, userID int64) error
+	// DeleteExpired removes all sessions expired at now and returns how many were removed
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// APITokenRepository stores API tokens keyed by the hash of their token
+type APITokenRepository interface {
+	List(ctx context.Context) ([]models.APIToken, error)
+	Create(ctx context.Context, token *models.APIToken) error
+	// GetByHash returns the unrevoked token with the given hash along with its
+	// active user, or nil if there is no such token
+	GetByHash(ctx context.Context, tokenHash string) (*models.APIToken, error)
+	// Touch records when a token was last used
+	Touch(ctx context.Context, id int64, lastUsed time.Time) error
+	Revoke(ctx context.Context, id int64) error
+}
+
+// LoginAttemptRepository stores the audit log of rejected logins
+type LoginAttemptRepository interface {
+	Record(ctx context.Context, attempt *models.LoginAttempt) error
+	// Recent returns up to limit attempts, newest first
+	Recent(ctx context.Context, limit int) ([]models.LoginAttempt, error)
+}
+
+// Store holds the database pools and the repositories built on them. It is
+// opened once in main and handed to whatever needs the database. Tests can
+// fill in the repositories with fakes and leave the pools nil
+type Store struct {
+	// DB is the write pool. It has a single connection, since SQLite only
+	// allows one writer at a time anyway
+	DB *sql.DB
+	// ReadDB is the read pool. Its connections refuse to write
+	ReadDB *sql.DB
+	// Path is the file the pools were opened from, if any
+	Path string
+
+	Chores        ChoreRepository
+	Blueprints    BlueprintRepository
+	Routines      RoutineRepository
+	ChoreRoutines ChoreRoutineRepository
+	Users         UserRepository
+	Sessions      SessionRepository
+	APITokens     APITokenRepository
+	LoginAttempts LoginAttemptRepository
+}
+
+// NewStore builds a store whose repositories write through writer and read
+// through reader. Both may be the same pool
+func NewStore(writer, reader *sql.DB) *Store {
+	return &Store{
+		DB:            writer,
+		ReadDB:        reader,
+		Chores:        sqliteChores{writer, reader},
+		Blueprints:    sqliteBlueprints{writer, reader},
+		Routines:      sqliteRoutines{writer, reader},
+		ChoreRoutines: sqliteChoreRoutines{writer, reader},
+		Users:         sqliteUsers{writer, reader},
+		Sessions:      sqliteSessions{writer, reader},
+		APITokens:     sqliteAPITokens{writer, reader},
+		LoginAttempts: sqliteLoginAttempts{writer, reader},
+	}
+}
+
+// Close closes both pools
+func (s *Store) Close() error {
+	if s.ReadDB == s.DB {
+		return s.DB.Close()
+	}
+	return errors.Join(s.ReadDB.Close(), s.DB.Close())
+}
+
+// The SQLite repositories write through db and read through read
+
+type sqliteChores struct{ db, read *sql.DB }
+
+func (r sqliteChores) List(ctx context.Context) ([]models.Chore, error) {
+	return GetChores(ctx, r.read)
+}
+
+func (r sqliteChores) Get(ctx context.Context, id int64) (*models.Chore, error) {
+	return GetChore(ctx, r.read, id)
+}
+
+func (r sqliteChores) Create(ctx context.Context, chore *models.Chore) error {
+	return CreateChore(ctx, r.db, chore)
+}
+
+func (r sqliteChores) Update(ctx context.Context, chore *models.Chore) error {
+	return UpdateChore(ctx, r.db, chore)
+}
+
+func (r sqliteChores) Delete(ctx context.Context, id int64) error {
+	return DeleteChore(ctx, r.db, id)
+}
+
+type sqliteBlueprints struct{ db, read *sql.DB }
+
+func (r sqliteBlueprints) List(ctx context.Context) ([]models.RoutineBlueprint, error) {
+	return GetBlueprints(ctx, r.read)
+}
+
+func (r sqliteBlueprints) Get(ctx context.Context, id int64) (*models.RoutineBlueprint, []models.RoutineBlueprintChore, error) {
+	return GetBlueprint(ctx, r.read, id)
+}
+
+func (r sqliteBlueprints) Chores(ctx context.Context, blueprintID int64) ([]models.RoutineBlueprintChore, error) {
+	return GetBlueprintChores(ctx, r.read, blueprintID)
+}
+
+func (r sqliteBlueprints) Create(ctx context.Context, blueprint *models.RoutineBlueprint, choreIDs []int64) error {
+	return CreateBlueprint(ctx, r.db, blueprint, choreIDs)
+}
+
+func (r sqliteBlueprints) Update(ctx context.Context, blueprint *models.RoutineBlueprint, choreIDs []int64) error {
+	return UpdateBlueprint(ctx, r.db, blueprint, choreIDs)
+}
+
+func (r sqliteBlueprints) Delete(ctx context.Context, id int64) error {
+	return DeleteBlueprint(ctx, r.db, id)
+}
+
+type sqliteRoutines struct{ db, read *sql.DB }
+
+func (r sqliteRoutines) List(ctx context.Context, userID int64) ([]models.Routine, error) {
+	return GetRoutines(ctx, r.read, userID)
+}
+
+func (r sqliteRoutines) Get(ctx context.Context, id int64) (*models.Routine, error) {
+	return GetRoutine(ctx, r.read, id)
+}
+
+func (r sqliteRoutines) Create(ctx context.Context, routine *models.Routine) error {
+	return CreateRoutine(ctx, r.db, routine)
+}
+
+func (r sqliteRoutines) Start(ctx context.Context, ownerID, blueprintID int64, day schedule.Date) (*models.Routine, bool, error) {
+	return StartRoutine(ctx, r.db, ownerID, blueprintID, day)
+}
+
+func (r sqliteRoutines) Ensure(ctx context.Context, ownerID, blueprintID int64, day schedule.Date) (*models.Routine, bool, error) {
+	return EnsureRoutine(ctx, r.db, ownerID, blueprintID, day)
+}
+
+func (r sqliteRoutines) MarkMissed(ctx context.Context, before schedule.Date) (int64, error) {
+	return MarkMissedRoutines(ctx, r.db, before)
+}
+
+func (r sqliteRoutines) Relevant(ctx context.Context, userID int64, day schedule.Date) ([]models.Routine, error) {
+	return GetRelevantRoutines(ctx, r.read, userID, day)
+}
+
+type sqliteChoreRoutines struct{ db, read *sql.DB }
+
+func (r sqliteChoreRoutines) ForRoutine(ctx context.Context, routineID int64) ([]models.ChoreRoutine, error) {
+	return GetChoreRoutines(ctx, r.read, routineID)
+}
+
+func (r sqliteChoreRoutines) Counts(ctx context.Context, routineID int64) (int, int, error) {
+	return GetChoreCountsForRoutine(ctx, r.read, routineID)
+}
+
+func (r sqliteChoreRoutines) Upsert(ctx context.Context, routineID int64, choreID int64, completed bool, userID int64) (*models.ChoreRoutine, error) {
+	return UpsertChoreRoutine(ctx, r.db, routineID, choreID, completed, userID)
+}
+
+type sqliteUsers struct{ db, read *sql.DB }
+
+func (r sqliteUsers) List(ctx context.Context) ([]models.User, error) {
+	return GetUsers(ctx, r.read)
+}
+
+func (r sqliteUsers) Get(ctx context.Context, id int64) (*models.User, error) {
+	return GetUser(ctx, r.read, id)
+}
+
+func (r sqliteUsers) Create(ctx context.Context, user *models.User, childIDs []int64) error {
+	return CreateUser(ctx, r.db, user, childIDs)
+}
+
+func (r sqliteUsers) Update(ctx context.Context, user *models.User, childIDs []int64) error {
+	return UpdateUser(ctx, r.db, user, childIDs)
+}
+
+func (r sqliteUsers) SetPassword(ctx context.Context, id int64, hashedPassword string) error {
+	return SetUserPassword(ctx, r.db, id, hashedPassword)
+}
+
+func (r sqliteUsers) SetPIN(ctx context.Context, id int64, hashedPIN string) error {
+	return SetUserPIN(ctx, r.db, id, hashedPIN)
+}
+
+func (r sqliteUsers) SetActive(ctx context.Context, id int64, active bool) error {
+	return SetUserActive(ctx, r.db, id, active)
+}
+
+func (r sqliteUsers) PINLoginUsers(ctx context.Context) ([]models.User, error) {
+	return GetPINLoginUsers(ctx, r.read)
+}
+
+func (r sqliteUsers) Children(ctx context.Context, parentID int64) ([]models.User, error) {
+	return GetChildren(ctx, r.read, parentID)
+}
+
+func (r sqliteUsers) IsParentOf(ctx context.Context, parentID int64, childID int64) (bool, error) {
+	return IsParentOf(ctx, r.read, parentID, childID)
+}
+
+func (r sqliteUsers) Credentials(ctx context.Context, name string) (*models.User, error) {
+	return GetUserCredentials(ctx, r.read, name)
+}
+
+func (r sqliteUsers) PIN(ctx context.Context, id int64) (*models.User, string, *models.Timestamp, error) {
+	return GetUserPIN(ctx, r.read, id)
+}
+
+func (r sqliteUsers) RecordPINFailure(ctx context.Context, id int64, maxAttempts int, lockedUntil time.Time) (bool, error) {
+	return RecordPINFailure(ctx, r.db, id, maxAttempts, lockedUntil)
+}
+
+func (r sqliteUsers) ResetPINFailures(ctx context.Context, id int64) error {
+	return ResetPINFailures(ctx, r.db, id)
+}
+
+type sqliteSessions struct{ db, read *sql.DB }
+
+func (r sqliteSessions) Create(ctx context.Context, session *models.Session) error {
+	return CreateSession(ctx, r.db, session)
+}
+
+func (r sqliteSessions) GetByTokenHash(ctx context.Context, tokenHash string, now time.Time) (*models.Session, error) {
+	return GetSessionByTokenHash(ctx, r.read, tokenHash, now)
+}
+
+func (r sqliteSessions) Touch(ctx context.Context, id int64, lastSeen time.Time, expires time.Time) error {
+	return TouchSession(ctx, r.db, id, lastSeen, expires)
+}
+
+func (r sqliteSessions) Delete(ctx context.Context, tokenHash string) error {
+	return DeleteSession(ctx, r.db, tokenHash)
+}
+
+func (r sqliteSessions) DeleteForUser(ctx context.Context, userID int64) error {
+	return DeleteSessionsForUser(ctx, r.db, userID)
+}
+
+func (r sqliteSessions) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return DeleteExpiredSessions(ctx, r.db, now)
+}
+
+type sqliteAPITokens struct{ db, read *sql.DB }
+
+func (r sqliteAPITokens) List(ctx context.Context) ([]models.APIToken, error) {
+	return GetAPITokens(ctx, r.read)
+}
+
+func (r sqliteAPITokens) Create(ctx context.Context, token *models.APIToken) error {
+	return CreateAPIToken(ctx, r.db, token)
+}
+
+func (r sqliteAPITokens) GetByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	return GetAPITokenByHash(ctx, r.read, tokenHash)
+}
+
+func (r sqliteAPITokens) Touch(ctx context.Context, id int64, lastUsed time.Time) error {
+	return TouchAPIToken(ctx, r.db, id, lastUsed)
+}
+
+func (r sqliteAPITokens) Revoke(ctx context.Context, id int64) error {
+	return RevokeAPIToken(ctx, r.db, id)
+}
+
+type sqliteLoginAttempts struct{ db, read *sql.DB }
+
+func (r sqliteLoginAttempts) Record(ctx context.Context, attempt *models.LoginAttempt) error {
+	return RecordLoginAttempt(ctx, r.db, attempt)
+}
+
+func (r sqliteLoginAttempts) Recent(ctx context.Context, limit int) ([]models.LoginAttempt, error) {
+	return GetLoginAttempts(ctx, r.read, limit)
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bagvendt/chores"
+	"github.com/bagvendt/chores/internal/models"
+)
+
+func TestTimestampScan(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, err := db.Exec(`
+		CREATE TABLE stamps (
+			id INTEGER PRIMARY KEY,
+			declared TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			untyped TEXT
+		);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create stamps table: %v", err)
+	}
+
+	want := time.Date(2024, 3, 9, 17, 30, 5, 0, time.UTC)
+	cases := []struct {
+		name  string
+		value any
+	}{
+		{"sql default", "2024-03-09 17:30:05"},
+		{"rfc3339", "2024-03-09T17:30:05Z"},
+		{"rfc3339 offset", "2024-03-09T19:30:05+02:00"},
+		{"driver time", time.Date(2024, 3, 9, 18, 30, 5, 0, time.FixedZone("CET", 3600))},
+		{"timestamp", models.NewTimestamp(want)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := db.Exec("DELETE FROM stamps"); err != nil {
+				t.Fatalf("Failed to clear stamps: %v", err)
+			}
+			if _, err := db.Exec("INSERT INTO stamps (id, declared, untyped) VALUES (1, ?, ?)", tc.value, tc.value); err != nil {
+				t.Fatalf("Failed to insert stamp: %v", err)
+			}
+
+			// The driver parses declared TIMESTAMP columns itself and hands
+			// over untyped columns as text, so both paths are covered
+			var declared, untyped models.Timestamp
+			if err := db.QueryRow("SELECT declared, untyped FROM stamps").Scan(&declared, &untyped); err != nil {
+				t.Fatalf("Failed to scan stamp: %v", err)
+			}
+			if !declared.Equal(want) {
+				t.Errorf("Expected declared column %v, got %v", want, declared.Time)
+			}
+			if !untyped.Equal(want) {
+				t.Errorf("Expected untyped column %v, got %v", want, untyped.Time)
+			}
+		})
+	}
+
+	// Rows inserted by SQL defaults must not come back as the zero time
+	if _, err := db.Exec("INSERT INTO stamps (id) VALUES (2)"); err != nil {
+		t.Fatalf("Failed to insert default stamp: %v", err)
+	}
+	var created models.Timestamp
+	if err := db.QueryRow("SELECT declared FROM stamps WHERE id = 2").Scan(&created); err != nil {
+		t.Fatalf("Failed to scan default stamp: %v", err)
+	}
+	if created.IsZero() || time.Since(created.Time) > time.Minute {
+		t.Errorf("Expected CURRENT_TIMESTAMP to scan as roughly now, got %v", created.Time)
+	}
+
+	// NULL only fits a *Timestamp
+	var missing *models.Timestamp
+	if err := db.QueryRow("SELECT untyped FROM stamps WHERE id = 2").Scan(&missing); err != nil {
+		t.Fatalf("Failed to scan NULL into *Timestamp: %v", err)
+	}
+	if missing != nil {
+		t.Errorf("Expected nil for NULL, got %v", missing)
+	}
+	if err := db.QueryRow("SELECT untyped FROM stamps WHERE id = 2").Scan(&created); err == nil {
+		t.Errorf("Expected an error scanning NULL into Timestamp")
+	}
+
+	if _, err := db.Exec("UPDATE stamps SET untyped = 'yesterday' WHERE id = 2"); err != nil {
+		t.Fatalf("Failed to store garbage: %v", err)
+	}
+	if err := db.QueryRow("SELECT untyped FROM stamps WHERE id = 2").Scan(&created); err == nil {
+		t.Errorf("Expected an error scanning an unparseable timestamp")
+	}
+
+	// The driver turns garbage in a declared column into the zero time
+	if _, err := db.Exec("UPDATE stamps SET declared = 'yesterday' WHERE id = 2"); err != nil {
+		t.Fatalf("Failed to store garbage: %v", err)
+	}
+	if err := db.QueryRow("SELECT declared FROM stamps WHERE id = 2").Scan(&created); err == nil {
+		t.Errorf("Expected an error scanning an unparseable declared timestamp, got %v", created.Time)
+	}
+}
+
+func TestNormalizeTimestampsMigration(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, chores.Migrations())
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	// Step back to before the normalisation so it runs over old data
+	if err := manager.Rollback(9); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO chores (id, created, modified, name, default_points)
+		VALUES (100, '2024-03-09 17:30:05', '2024-03-09 19:30:05.123456789+02:00', 'Old chore', 1);
+		INSERT INTO chores (id, name, default_points) VALUES (101, 'Default chore', 1);
+		INSERT INTO users (id, name, password, pin_locked_until) VALUES (100, 'old', 'x', '2024-03-09 17:30:05');
+		INSERT INTO users (id, name, password) VALUES (101, 'unlocked', 'x');
+	`)
+	if err != nil {
+		t.Fatalf("Failed to insert old rows: %v", err)
+	}
+
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to re-run migrations: %v", err)
+	}
+
+	// Concatenating reads the stored text rather than the driver's parse of it
+	stored := func(query string) string {
+		t.Helper()
+		var s string
+		if err := db.QueryRow(query).Scan(&s); err != nil {
+			t.Fatalf("Failed to read %q: %v", query, err)
+		}
+		return s
+	}
+
+	if got := stored("SELECT created || '' FROM chores WHERE id = 100"); got != "2024-03-09T17:30:05Z" {
+		t.Errorf("Expected created normalised to 2024-03-09T17:30:05Z, got %q", got)
+	}
+	if got := stored("SELECT modified || '' FROM chores WHERE id = 100"); got != "2024-03-09T17:30:05Z" {
+		t.Errorf("Expected modified normalised to UTC, got %q", got)
+	}
+	if got := stored("SELECT pin_locked_until || '' FROM users WHERE id = 100"); got != "2024-03-09T17:30:05Z" {
+		t.Errorf("Expected pin_locked_until normalised, got %q", got)
+	}
+
+	var locked *models.Timestamp
+	if err := db.QueryRow("SELECT pin_locked_until FROM users WHERE id = 101").Scan(&locked); err != nil {
+		t.Fatalf("Failed to read pin_locked_until: %v", err)
+	}
+	if locked != nil {
+		t.Errorf("Expected NULL pin_locked_until to stay NULL, got %v", locked)
+	}
+
+	chore, err := GetChore(t.Context(), db, 101)
+	if err != nil {
+		t.Fatalf("Failed to get chore: %v", err)
+	}
+	if chore.Created.IsZero() || chore.Modified.IsZero() {
+		t.Errorf("Expected defaulted timestamps to be set, got created %v and modified %v", chore.Created.Time, chore.Modified.Time)
+	}
+}
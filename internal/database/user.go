@@ -0,0 +1,497 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrUserNameTaken is returned when another user already has the requested name
+	ErrUserNameTaken = errors.New("user name already taken")
+	// ErrUserNotFound is returned when changing a user that doesn't exist
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// GetUsers returns all users, including deactivated ones
+func GetUsers(ctx context.Context, db *sql.DB) ([]models.User, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, created, modified, name, is_admin, role, active, avatar, pin IS NOT NULL
+		FROM users
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var avatar sql.NullString
+
+		if err := rows.Scan(
+			&user.ID,
+			&user.Created,
+			&user.Modified,
+			&user.Name,
+			&user.IsAdmin,
+			&user.Role,
+			&user.Active,
+			&avatar,
+			&user.HasPIN,
+		); err != nil {
+			return nil, err
+		}
+
+		user.Avatar = avatar.String
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// GetUser returns a user by ID. The password hash is not loaded.
+func GetUser(ctx context.Context, db *sql.DB, id int64) (*models.User, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var user models.User
+	var avatar sql.NullString
+
+	err := db.QueryRowContext(ctx, `
+		SELECT id, created, modified, name, is_admin, role, active, avatar, pin IS NOT NULL
+		FROM users
+		WHERE id = ?
+	`, id).Scan(
+		&user.ID,
+		&user.Created,
+		&user.Modified,
+		&user.Name,
+		&user.IsAdmin,
+		&user.Role,
+		&user.Active,
+		&avatar,
+		&user.HasPIN,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.Avatar = avatar.String
+
+	return &user, nil
+}
+
+// nameTaken turns a unique constraint violation on the user's name into
+// ErrUserNameTaken
+func nameTaken(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrUserNameTaken
+	}
+	return err
+}
+
+// CreateUser creates a new active user along with the children they can act
+// on behalf of, in one transaction. user.Password must already be hashed.
+func CreateUser(ctx context.Context, db *sql.DB, user *models.User, childIDs []int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := models.NewTimestamp(time.Now())
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO users (created, modified, name, password, is_admin, role, active, avatar)
+		VALUES (?, ?, ?, ?, ?, ?, 1, ?)
+	`,
+		now,
+		now,
+		user.Name,
+		user.Password,
+		user.IsAdmin,
+		string(user.Role),
+		sql.NullString{String: user.Avatar, Valid: user.Avatar != ""},
+	)
+	if err != nil {
+		return nameTaken(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if err := replaceChildren(ctx, tx, id, user.Role, childIDs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	user.ID = id
+	user.Active = true
+	user.Created = now
+	user.Modified = user.Created
+
+	return nil
+}
+
+// UpdateUser updates a user's name, admin flag, role and avatar and replaces
+// their children, in one transaction. The password and PIN are left alone.
+func UpdateUser(ctx context.Context, db *sql.DB, user *models.User, childIDs []int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := models.NewTimestamp(time.Now())
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET modified = ?, name = ?, is_admin = ?, role = ?, avatar = ?
+		WHERE id = ?
+	`,
+		now,
+		user.Name,
+		user.IsAdmin,
+		string(user.Role),
+		sql.NullString{String: user.Avatar, Valid: user.Avatar != ""},
+		user.ID,
+	)
+	if err != nil {
+		return nameTaken(err)
+	}
+
+	if err := replaceChildren(ctx, tx, user.ID, user.Role, childIDs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	user.Modified = now
+	return nil
+}
+
+// SetUserPassword replaces a user's password hash. It returns
+// ErrUserNotFound if there is no such user
+func SetUserPassword(ctx context.Context, db *sql.DB, id int64, hashedPassword string) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
+	result, err := db.ExecContext(ctx, `
+		UPDATE users
+		SET modified = ?, password = ?
+		WHERE id = ?
+	`, now, hashedPassword, id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserPIN replaces a user's PIN hash and clears any PIN lockout. An empty
+// hash disables PIN login for the user.
+func SetUserPIN(ctx context.Context, db *sql.DB, id int64, hashedPIN string) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	now := models.NewTimestamp(time.Now())
+	_, err := db.ExecContext(ctx, `
+		UPDATE users
+		SET modified = ?, pin = ?, pin_failed_attempts = 0, pin_locked_until = NULL
+		WHERE id = ?
+	`, now, sql.NullString{String: hashedPIN, Valid: hashedPIN != ""}, id)
+	return err
+}
+
+// GetPINLoginUsers returns the active users who can log in with a PIN.
+// Admins always have to use their password, so they are never included.
+func GetPINLoginUsers(ctx context.Context, db *sql.DB) ([]models.User, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name, role, avatar
+		FROM users
+		WHERE active = 1 AND is_admin = 0 AND pin IS NOT NULL
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var avatar sql.NullString
+
+		if err := rows.Scan(&user.ID, &user.Name, &user.Role, &avatar); err != nil {
+			return nil, err
+		}
+
+		user.Active = true
+		user.HasPIN = true
+		user.Avatar = avatar.String
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// SetUserActive activates or deactivates a user. Deactivating a user also
+// ends all of their sessions.
+func SetUserActive(ctx context.Context, db *sql.DB, id int64, active bool) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := models.NewTimestamp(time.Now())
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET modified = ?, active = ?
+		WHERE id = ?
+	`, now, active, id)
+	if err != nil {
+		return err
+	}
+
+	if !active {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// replaceChildren replaces the children a parent can act on behalf of. Only
+// parents have children, so for anyone else they are all removed
+func replaceChildren(ctx context.Context, tx *sql.Tx, parentID int64, role models.Role, childIDs []int64) error {
+	if role != models.RoleParent {
+		childIDs = nil
+	}
+
+	// Remove existing children
+	_, err := tx.ExecContext(ctx, "DELETE FROM user_children WHERE parent_id = ?", parentID)
+	if err != nil {
+		return err
+	}
+
+	// Add new children
+	for _, childID := range childIDs {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO user_children (parent_id, child_id)
+			VALUES (?, ?)
+		`, parentID, childID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetChildren returns the children a parent can act on behalf of
+func GetChildren(ctx context.Context, db *sql.DB, parentID int64) ([]models.User, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.id, u.created, u.modified, u.name, u.is_admin, u.role, u.active, u.avatar, u.pin IS NOT NULL
+		FROM user_children uc
+		JOIN users u ON uc.child_id = u.id
+		WHERE uc.parent_id = ? AND u.active = 1
+		ORDER BY u.name
+	`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var children []models.User
+	for rows.Next() {
+		var child models.User
+		var avatar sql.NullString
+
+		if err := rows.Scan(
+			&child.ID,
+			&child.Created,
+			&child.Modified,
+			&child.Name,
+			&child.IsAdmin,
+			&child.Role,
+			&child.Active,
+			&avatar,
+			&child.HasPIN,
+		); err != nil {
+			return nil, err
+		}
+
+		child.Avatar = avatar.String
+
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+// IsParentOf reports whether parentID is an active parent registered as a
+// parent of childID
+func IsParentOf(ctx context.Context, db *sql.DB, parentID int64, childID int64) (bool, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM user_children uc
+		JOIN users p ON uc.parent_id = p.id
+		WHERE uc.parent_id = ? AND uc.child_id = ? AND p.role = 'parent' AND p.active = 1
+	`, parentID, childID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetUserCredentials returns the active user with the given name along with
+// their password hash, or nil if there is no such user
+func GetUserCredentials(ctx context.Context, db *sql.DB, name string) (*models.User, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var user models.User
+
+	err := db.QueryRowContext(ctx, `
+		SELECT id, created, modified, name, password, is_admin, role, active
+		FROM users
+		WHERE name = ? AND active = 1
+	`, name).Scan(&user.ID, &user.Created, &user.Modified, &user.Name, &user.Password, &user.IsAdmin, &user.Role, &user.Active)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUserPIN returns the active user with the given ID along with their PIN
+// hash and how long PIN login is locked, or nil if there is no such user. The
+// hash is empty when the user has no PIN.
+func GetUserPIN(ctx context.Context, db *sql.DB, id int64) (*models.User, string, *models.Timestamp, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var user models.User
+	var hashedPIN sql.NullString
+	var lockedUntil *models.Timestamp
+
+	err := db.QueryRowContext(ctx, `
+		SELECT id, created, modified, name, is_admin, role, active, pin, pin_locked_until
+		FROM users
+		WHERE id = ? AND active = 1
+	`, id).Scan(&user.ID, &user.Created, &user.Modified, &user.Name, &user.IsAdmin, &user.Role, &user.Active, &hashedPIN, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, "", nil, nil
+	}
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	user.HasPIN = hashedPIN.Valid
+
+	return &user, hashedPIN.String, lockedUntil, nil
+}
+
+// RecordPINFailure counts a wrong PIN. Once the user has reached maxAttempts
+// PIN login is locked until lockedUntil and the counter starts over. It
+// reports whether PIN login was locked.
+func RecordPINFailure(ctx context.Context, db *sql.DB, id int64, maxAttempts int, lockedUntil time.Time) (bool, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var attempts int
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users
+		SET pin_failed_attempts = pin_failed_attempts + 1
+		WHERE id = ?
+		RETURNING pin_failed_attempts
+	`, id).Scan(&attempts)
+	if err != nil {
+		return false, err
+	}
+
+	locked := attempts >= maxAttempts
+	if locked {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users
+			SET pin_failed_attempts = 0, pin_locked_until = ?
+			WHERE id = ?
+		`, models.NewTimestamp(lockedUntil), id)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return locked, tx.Commit()
+}
+
+// ResetPINFailures forgets a user's wrong PINs and lifts any PIN lockout
+func ResetPINFailures(ctx context.Context, db *sql.DB, id int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		UPDATE users
+		SET pin_failed_attempts = 0, pin_locked_until = NULL
+		WHERE id = ?
+	`, id)
+	return err
+}
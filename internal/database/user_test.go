@@ -0,0 +1,274 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/bagvendt/chores"
+	"github.com/bagvendt/chores/internal/models"
+)
+
+func TestUserOperations(t *testing.T) {
+	// Setup test database
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Create the tables users depend on for testing
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			name TEXT NOT NULL UNIQUE,
+			password TEXT NOT NULL,
+			is_admin BOOLEAN NOT NULL DEFAULT 0,
+			role TEXT NOT NULL DEFAULT 'child',
+			active BOOLEAN NOT NULL DEFAULT 1,
+			avatar TEXT,
+			pin TEXT,
+			pin_failed_attempts INTEGER NOT NULL DEFAULT 0,
+			pin_locked_until TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS user_children (
+			id INTEGER PRIMARY KEY,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			parent_id INTEGER NOT NULL,
+			child_id INTEGER NOT NULL,
+			UNIQUE (parent_id, child_id)
+		);
+		CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY,
+			token_hash TEXT UNIQUE NOT NULL,
+			user_id INTEGER NOT NULL,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_seen TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires TIMESTAMP NOT NULL,
+			user_agent TEXT
+		);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	// Test CreateUser
+	parent := &models.User{Name: "mor", Password: "hash", Role: models.RoleParent, IsAdmin: true}
+	if err := CreateUser(t.Context(), db, parent, nil); err != nil {
+		t.Fatalf("Failed to create parent: %v", err)
+	}
+	child := &models.User{Name: "poul", Password: "hash", Role: models.RoleChild}
+	if err := CreateUser(t.Context(), db, child, nil); err != nil {
+		t.Fatalf("Failed to create child: %v", err)
+	}
+	if !child.Active || child.ID <= 0 {
+		t.Errorf("Expected new user to be active with an ID, got %+v", child)
+	}
+
+	// Names must be unique
+	if err := CreateUser(t.Context(), db, &models.User{Name: "poul", Password: "hash", Role: models.RoleChild}, nil); err != ErrUserNameTaken {
+		t.Errorf("Expected ErrUserNameTaken, got %v", err)
+	}
+
+	// Test UpdateUser
+	child.Name = "Poul"
+	if err := UpdateUser(t.Context(), db, child, nil); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	updated, err := GetUser(t.Context(), db, child.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if updated.Name != "Poul" || updated.Role != models.RoleChild {
+		t.Errorf("Expected updated child, got %+v", updated)
+	}
+
+	// Renaming onto another user's name is rejected
+	child.Name = "mor"
+	if err := UpdateUser(t.Context(), db, child, nil); err != ErrUserNameTaken {
+		t.Errorf("Expected ErrUserNameTaken, got %v", err)
+	}
+
+	// Test SetUserPassword
+	if err := SetUserPassword(t.Context(), db, child.ID, "new-hash"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	var password string
+	if err := db.QueryRow("SELECT password FROM users WHERE id = ?", child.ID).Scan(&password); err != nil {
+		t.Fatalf("Failed to read password: %v", err)
+	}
+	if password != "new-hash" {
+		t.Errorf("Expected password to be replaced, got %s", password)
+	}
+	if err := SetUserPassword(t.Context(), db, 999, "new-hash"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound for an unknown user, got %v", err)
+	}
+
+	// Test DeleteSessionsForUser leaves other users signed in
+	_, err = db.Exec(`
+		INSERT INTO sessions (token_hash, user_id, expires) VALUES
+			('child-1', ?, '2999-01-01T00:00:00Z'),
+			('child-2', ?, '2999-01-01T00:00:00Z'),
+			('parent', ?, '2999-01-01T00:00:00Z')
+	`, child.ID, child.ID, parent.ID)
+	if err != nil {
+		t.Fatalf("Failed to insert sessions: %v", err)
+	}
+	if err := DeleteSessionsForUser(t.Context(), db, child.ID); err != nil {
+		t.Fatalf("Failed to delete sessions: %v", err)
+	}
+	var remaining []string
+	rows, err := db.Query("SELECT token_hash FROM sessions")
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	for rows.Next() {
+		var tokenHash string
+		if err := rows.Scan(&tokenHash); err != nil {
+			t.Fatalf("Failed to scan session: %v", err)
+		}
+		remaining = append(remaining, tokenHash)
+	}
+	rows.Close()
+	if len(remaining) != 1 || remaining[0] != "parent" {
+		t.Errorf("Expected only the parent's session to remain, got %v", remaining)
+	}
+
+	// Test SetUserPIN and GetPINLoginUsers, admins are never offered PIN login
+	if err := SetUserPIN(t.Context(), db, child.ID, "pin-hash"); err != nil {
+		t.Fatalf("Failed to set PIN: %v", err)
+	}
+	if err := SetUserPIN(t.Context(), db, parent.ID, "pin-hash"); err != nil {
+		t.Fatalf("Failed to set PIN: %v", err)
+	}
+	pinUsers, err := GetPINLoginUsers(t.Context(), db)
+	if err != nil {
+		t.Fatalf("Failed to get PIN users: %v", err)
+	}
+	if len(pinUsers) != 1 || pinUsers[0].ID != child.ID {
+		t.Errorf("Expected only child %d to have PIN login, got %+v", child.ID, pinUsers)
+	}
+	if err := SetUserPIN(t.Context(), db, child.ID, ""); err != nil {
+		t.Fatalf("Failed to clear PIN: %v", err)
+	}
+	if updated, _ := GetUser(t.Context(), db, child.ID); updated == nil || updated.HasPIN {
+		t.Errorf("Expected PIN to be cleared, got %+v", updated)
+	}
+
+	// Test UpdateUser with children and GetChildren
+	if err := UpdateUser(t.Context(), db, parent, []int64{child.ID}); err != nil {
+		t.Fatalf("Failed to set children: %v", err)
+	}
+	children, err := GetChildren(t.Context(), db, parent.ID)
+	if err != nil {
+		t.Fatalf("Failed to get children: %v", err)
+	}
+	if len(children) != 1 || children[0].ID != child.ID {
+		t.Errorf("Expected child %d, got %+v", child.ID, children)
+	}
+	isParent, err := IsParentOf(t.Context(), db, parent.ID, child.ID)
+	if err != nil || !isParent {
+		t.Errorf("Expected %d to be parent of %d (err %v)", parent.ID, child.ID, err)
+	}
+
+	// A user whose children can't be saved isn't created either
+	far := &models.User{Name: "far", Password: "hash", Role: models.RoleParent}
+	if err := CreateUser(t.Context(), db, far, []int64{child.ID, child.ID}); err == nil {
+		t.Fatalf("Expected linking the same child twice to fail")
+	}
+	var fars int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE name = 'far'").Scan(&fars); err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if fars != 0 {
+		t.Errorf("Expected the user to be rolled back with their children")
+	}
+
+	// Test deactivation ends sessions and hides the child
+	if _, err := db.Exec("INSERT INTO sessions (token_hash, user_id, expires) VALUES ('h', ?, '2999-01-01T00:00:00Z')", child.ID); err != nil {
+		t.Fatalf("Failed to insert session: %v", err)
+	}
+	if err := SetUserActive(t.Context(), db, child.ID, false); err != nil {
+		t.Fatalf("Failed to deactivate user: %v", err)
+	}
+	var sessions int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ?", child.ID).Scan(&sessions); err != nil {
+		t.Fatalf("Failed to count sessions: %v", err)
+	}
+	if sessions != 0 {
+		t.Errorf("Expected sessions to be removed on deactivation, got %d", sessions)
+	}
+	children, err = GetChildren(t.Context(), db, parent.ID)
+	if err != nil {
+		t.Fatalf("Failed to get children: %v", err)
+	}
+	if len(children) != 0 {
+		t.Errorf("Expected deactivated child to be hidden, got %d children", len(children))
+	}
+
+	// Test GetUsers still lists deactivated users
+	users, err := GetUsers(t.Context(), db)
+	if err != nil {
+		t.Fatalf("Failed to get users: %v", err)
+	}
+	if len(users) != 2 {
+		t.Errorf("Expected 2 users, got %d", len(users))
+	}
+
+	// Only active parents act for their children
+	if err := SetUserActive(t.Context(), db, parent.ID, false); err != nil {
+		t.Fatalf("Failed to deactivate parent: %v", err)
+	}
+	if isParent, err := IsParentOf(t.Context(), db, parent.ID, child.ID); err != nil || isParent {
+		t.Errorf("Expected a deactivated parent not to count as parent (err %v)", err)
+	}
+	if err := SetUserActive(t.Context(), db, parent.ID, true); err != nil {
+		t.Fatalf("Failed to reactivate parent: %v", err)
+	}
+
+	// A parent who becomes a child loses their children
+	parent.Role = models.RoleChild
+	if err := UpdateUser(t.Context(), db, parent, []int64{child.ID}); err != nil {
+		t.Fatalf("Failed to update parent: %v", err)
+	}
+	var links int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_children WHERE parent_id = ?", parent.ID).Scan(&links); err != nil {
+		t.Fatalf("Failed to count children: %v", err)
+	}
+	if links != 0 {
+		t.Errorf("Expected the children to be unlinked, got %d", links)
+	}
+}
+
+func TestUniqueUserNamesMigration(t *testing.T) {
+	db, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager := NewMigrationManager(db, chores.Migrations())
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := manager.Rollback(14); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+
+	// Duplicates from before the index must not stop the migration
+	if _, err := db.Exec("INSERT INTO users (id, name, password) VALUES (10, 'poul', 'hash')"); err != nil {
+		t.Fatalf("Failed to insert duplicate user: %v", err)
+	}
+	if err := manager.RunMigrations(); err != nil {
+		t.Fatalf("Failed to re-run migrations: %v", err)
+	}
+
+	for id, want := range map[int64]string{1: "poul", 10: "poul (10)"} {
+		user, err := GetUser(t.Context(), db, id)
+		if err != nil {
+			t.Fatalf("Failed to get user %d: %v", id, err)
+		}
+		if user.Name != want {
+			t.Errorf("Expected user %d to be named %q, got %q", id, want, user.Name)
+		}
+	}
+
+	// The index catches a duplicate even without a check beforehand
+	if _, err := db.Exec("INSERT INTO users (name, password) VALUES ('ulla', 'hash')"); nameTaken(err) != ErrUserNameTaken {
+		t.Errorf("Expected the index to reject a duplicate name, got %v", err)
+	}
+}
@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/bagvendt/chores/internal/contextkeys"
-	"github.com/bagvendt/chores/internal/database"
 	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/services"
 )
 
 // ChoreCompletionRequest is the request body for updating chore completion status
@@ -24,20 +27,20 @@ type ChoreCompletionResponse struct {
 }
 
 // APIHandler handles API requests
-func APIHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) APIHandler(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api")
 
 	// Route to appropriate handler based on path
 	switch {
 	case strings.HasPrefix(path, "/routine/"):
-		handleRoutineAPI(w, r, strings.TrimPrefix(path, "/routine/"))
+		s.handleRoutineAPI(w, r, strings.TrimPrefix(path, "/routine/"))
 	default:
 		http.Error(w, "API endpoint not found", http.StatusNotFound)
 	}
 }
 
 // handleRoutineAPI handles routine-related API requests
-func handleRoutineAPI(w http.ResponseWriter, r *http.Request, path string) {
+func (s *Server) handleRoutineAPI(w http.ResponseWriter, r *http.Request, path string) {
 	// Extract routineID from path
 	parts := strings.Split(path, "/")
 	if len(parts) < 1 {
@@ -55,14 +58,14 @@ func handleRoutineAPI(w http.ResponseWriter, r *http.Request, path string) {
 	restPath := strings.Join(parts[1:], "/")
 	switch {
 	case strings.HasPrefix(restPath, "chore/"):
-		handleRoutineChoreAPI(w, r, routineID, strings.TrimPrefix(restPath, "chore/"))
+		s.handleRoutineChoreAPI(w, r, routineID, strings.TrimPrefix(restPath, "chore/"))
 	default:
 		http.Error(w, "API endpoint not found", http.StatusNotFound)
 	}
 }
 
 // handleRoutineChoreAPI handles chore-related API requests for a specific routine
-func handleRoutineChoreAPI(w http.ResponseWriter, r *http.Request, routineID int64, path string) {
+func (s *Server) handleRoutineChoreAPI(w http.ResponseWriter, r *http.Request, routineID int64, path string) {
 	// Extract choreID from path
 	parts := strings.Split(path, "/")
 	if len(parts) < 1 {
@@ -92,6 +95,49 @@ func handleRoutineChoreAPI(w http.ResponseWriter, r *http.Request, routineID int
 		return
 	}
 
+	// Make sure the user may change this routine
+	_, err = s.auth.AuthorizeRoutine(r.Context(), user, routineID)
+	switch {
+	case errors.Is(err, services.ErrRoutineNotFound):
+		sendJSONResponse(w, http.StatusNotFound, ChoreCompletionResponse{
+			Success: false,
+			Error:   "Routine not found",
+		})
+		return
+	case errors.Is(err, services.ErrForbidden):
+		sendJSONResponse(w, http.StatusForbidden, ChoreCompletionResponse{
+			Success: false,
+			Error:   "Not allowed to change this routine",
+		})
+		return
+	case err != nil:
+		log.Printf("Failed to authorize routine (ID: %d): %v", routineID, err)
+		sendJSONResponse(w, http.StatusInternalServerError, ChoreCompletionResponse{
+			Success: false,
+			Error:   "Failed to load routine",
+		})
+		return
+	}
+
+	// Only the routine's own chores can be ticked off, or any chore's points
+	// could be collected
+	choreRoutines, err := s.chores.GetChoresForRoutine(r.Context(), routineID)
+	if err != nil {
+		log.Printf("Failed to load chores for routine (ID: %d): %v", routineID, err)
+		sendJSONResponse(w, http.StatusInternalServerError, ChoreCompletionResponse{
+			Success: false,
+			Error:   "Failed to load routine",
+		})
+		return
+	}
+	if !slices.ContainsFunc(choreRoutines, func(cr models.ChoreRoutine) bool { return cr.ChoreID == choreID }) {
+		sendJSONResponse(w, http.StatusNotFound, ChoreCompletionResponse{
+			Success: false,
+			Error:   "Chore not found in this routine",
+		})
+		return
+	}
+
 	// Parse request body
 	var req ChoreCompletionRequest
 	decoder := json.NewDecoder(r.Body)
@@ -104,7 +150,7 @@ func handleRoutineChoreAPI(w http.ResponseWriter, r *http.Request, routineID int
 	}
 
 	// Update chore completion status
-	choreRoutine, err := database.UpsertChoreRoutine(database.DB, routineID, choreID, req.Completed, user.ID)
+	choreRoutine, err := s.store.ChoreRoutines.Upsert(r.Context(), routineID, choreID, req.Completed, user.ID)
 	if err != nil {
 		sendJSONResponse(w, http.StatusInternalServerError, ChoreCompletionResponse{
 			Success: false,
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bagvendt/chores/internal/services"
+	"github.com/bagvendt/chores/internal/templates"
+)
+
+// APITokensHandler lets admins mint and revoke API tokens
+func (s *Server) APITokensHandler(w http.ResponseWriter, r *http.Request) {
+	// Strip prefix to get the path
+	path := strings.TrimPrefix(r.URL.Path, "/tokens")
+
+	switch {
+	case path == "" || path == "/":
+		if r.Method == http.MethodPost {
+			s.createAPIToken(w, r)
+		} else {
+			s.renderAPITokens(w, r, "", "")
+		}
+	case strings.HasSuffix(path, "/revoke"):
+		s.revokeAPIToken(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/revoke"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// renderAPITokens shows all tokens. newToken is the plain token just minted,
+// which can only be shown this once.
+func (s *Server) renderAPITokens(w http.ResponseWriter, r *http.Request, newToken string, errorMessage string) {
+	tokens, err := s.store.APITokens.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to load API tokens: %v", err)
+		http.Error(w, "Failed to load API tokens", http.StatusInternalServerError)
+		return
+	}
+
+	users, err := s.store.Users.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to load users: %v", err)
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+
+	content := templates.APITokens(tokens, users, newToken, errorMessage)
+	if r.Header.Get("HX-Request") == "true" {
+		content.Render(r.Context(), w)
+	} else {
+		templates.AdminBase(content).Render(r.Context(), w)
+	}
+}
+
+func (s *Server) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+	if err != nil {
+		s.renderAPITokens(w, r, "", "Pick a user for the token")
+		return
+	}
+	user, err := s.store.Users.Get(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to load user (ID: %d): %v", userID, err)
+		http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || !user.Active {
+		s.renderAPITokens(w, r, "", "Pick an active user for the token")
+		return
+	}
+
+	_, token, err := s.authentication.CreateAPIToken(r.Context(), userID, r.FormValue("name"))
+	if errors.Is(err, services.ErrAPITokenNameRequired) {
+		s.renderAPITokens(w, r, "", "Give the token a name so you know where it is used")
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating API token: %v", err)
+		http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderAPITokens(w, r, token, "")
+}
+
+func (s *Server) revokeAPIToken(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.APITokens.Revoke(r.Context(), id); err != nil {
+		log.Printf("Error revoking API token (ID: %d): %v", id, err)
+		http.Error(w, "Failed to revoke API token", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", "/admin/tokens")
+	} else {
+		http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
+	}
+}
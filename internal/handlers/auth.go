@@ -1,34 +1,49 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/bagvendt/chores/internal/database"
+	"github.com/a-h/templ"
+	"github.com/bagvendt/chores/internal/models"
 	"github.com/bagvendt/chores/internal/services"
 	"github.com/bagvendt/chores/internal/templates"
 )
 
 // LoginHandler handles the login page
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		// Handle login form submission
 		username := r.FormValue("username")
 		password := r.FormValue("password")
+		ip := s.clientIP(r)
 
-		_, sessionToken, err := services.AuthenticateUser(database.DB, username, password)
+		if wait, ok := s.limiter.Allow(ip, username); !ok {
+			s.auditLogin(r, username, ip, models.LoginRateLimited)
+			renderLockedOut(w, r, wait, templates.LoginPage("", wait, s.loginTiles(r.Context())))
+			return
+		}
+
+		_, sessionToken, err := s.authentication.AuthenticateUser(r.Context(), username, password, r.UserAgent())
 		if err != nil {
 			// Authentication failed, show error
-			templates.LoginPage("Invalid username or password").Render(r.Context(), w)
+			s.auditLogin(r, username, ip, models.LoginInvalidCredentials)
+			if wait := s.limiter.RecordFailure(ip, username); wait > 0 {
+				renderLockedOut(w, r, wait, templates.LoginPage("", wait, s.loginTiles(r.Context())))
+				return
+			}
+			templates.LoginPage("Invalid username or password", 0, s.loginTiles(r.Context())).Render(r.Context(), w)
 			return
 		}
+		s.limiter.RecordSuccess(username)
 
-		// Set the session cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_token",
-			Value:    sessionToken,
-			Path:     "/",
-			HttpOnly: true,
-		})
+		SetSessionCookie(w, r, sessionToken)
 
 		// Redirect to home page after successful login
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -36,16 +51,146 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Show the login form for GET requests
-	templates.LoginPage("").Render(r.Context(), w)
+	templates.LoginPage("", 0, s.loginTiles(r.Context())).Render(r.Context(), w)
+}
+
+// loginTiles returns everyone who gets an avatar tile on the login page
+func (s *Server) loginTiles(ctx context.Context) []models.User {
+	pinUsers, err := s.store.Users.PINLoginUsers(ctx)
+	if err != nil {
+		// The password form still works without the tiles
+		log.Printf("Failed to load PIN login users: %v", err)
+	}
+	return pinUsers
+}
+
+// renderLockedOut responds with 429 Too Many Requests and the given page
+func renderLockedOut(w http.ResponseWriter, r *http.Request, wait time.Duration, page templ.Component) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)))
+	w.WriteHeader(http.StatusTooManyRequests)
+	page.Render(r.Context(), w)
+}
+
+// auditLogin records a rejected login in the login_attempts table
+func (s *Server) auditLogin(r *http.Request, username, ip, reason string) {
+	attempt := &models.LoginAttempt{
+		Username:  username,
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Reason:    reason,
+	}
+	if err := s.store.LoginAttempts.Record(r.Context(), attempt); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+}
+
+// clientIP returns the address of the client. Behind the Fly proxy the
+// connection comes from the proxy, so the Fly-Client-IP header is used instead.
+// Anywhere else clients could send the header themselves to dodge the rate
+// limiter, so it is ignored.
+func (s *Server) clientIP(r *http.Request) string {
+	if ip := r.Header.Get("Fly-Client-IP"); s.behindFly && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// PINLoginHandler handles the PIN keypad at /login/pin/{id}
+func (s *Server) PINLoginHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/login/pin/"), 10, 64)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := s.store.Users.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to load user (ID: %d): %v", id, err)
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	// Only users offered a tile on the login page get a keypad
+	if user == nil || !user.Active || user.IsAdmin || !user.HasPIN {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		templates.PINLoginPage(*user, "", 0).Render(r.Context(), w)
+		return
+	}
+
+	ip := s.clientIP(r)
+	if wait, ok := s.limiter.AllowPIN(user.Name); !ok {
+		s.auditLogin(r, user.Name, ip, models.LoginRateLimited)
+		renderLockedOut(w, r, wait, templates.PINLoginPage(*user, "", wait))
+		return
+	}
+
+	_, sessionToken, err := s.authentication.AuthenticateUserPIN(r.Context(), id, r.FormValue("pin"), r.UserAgent())
+	if err != nil {
+		s.renderPINError(w, r, user, ip, err)
+		return
+	}
+	s.limiter.RecordSuccess(user.Name)
+
+	SetSessionCookie(w, r, sessionToken)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// renderPINError shows the keypad again with a message explaining what went wrong
+func (s *Server) renderPINError(w http.ResponseWriter, r *http.Request, user *models.User, ip string, err error) {
+	var message string
+	switch {
+	case errors.Is(err, services.ErrInvalidPIN):
+		s.auditLogin(r, user.Name, ip, models.LoginInvalidPIN)
+		if wait := s.limiter.RecordPINFailure(user.Name); wait > 0 {
+			renderLockedOut(w, r, wait, templates.PINLoginPage(*user, "", wait))
+			return
+		}
+		message = "Wrong PIN, try again"
+	case errors.Is(err, services.ErrPINLocked):
+		message = "Too many wrong PINs. Ask a grown-up or try again later."
+	default:
+		log.Printf("Error during PIN login (ID: %d): %v", user.ID, err)
+		message = "Something went wrong, try again"
+	}
+
+	templates.PINLoginPage(*user, message, 0).Render(r.Context(), w)
+}
+
+// SetSessionCookie stores the session token in the browser until the session
+// would expire. It is sent again whenever the session is extended, so the
+// cookie slides along with the session
+func SetSessionCookie(w http.ResponseWriter, r *http.Request, sessionToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    sessionToken,
+		Path:     "/",
+		MaxAge:   int(services.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isHTTPS reports whether the browser reached us over HTTPS. On Fly TLS ends
+// at the proxy, which sets X-Forwarded-Proto.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
 }
 
 // LogoutHandler handles user logout
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the session token from the cookie
 	cookie, err := r.Cookie("session_token")
 	if err == nil {
-		// Clear the session from memory
-		services.ClearSession(cookie.Value)
+		// Remove the session from the session store
+		s.authentication.ClearSession(r.Context(), cookie.Value)
 	}
 
 	// Clear the cookie
@@ -55,6 +200,8 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		Path:     "/",
 		MaxAge:   -1,
 		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
 	})
 
 	// Redirect to login page
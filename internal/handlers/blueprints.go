@@ -1,18 +1,22 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bagvendt/chores/internal/database"
 	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
 	"github.com/bagvendt/chores/internal/templates"
 	"github.com/bagvendt/chores/internal/utils"
 )
 
-func BlueprintsHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) BlueprintsHandler(w http.ResponseWriter, r *http.Request) {
 	// Strip prefix to get the path
 	path := strings.TrimPrefix(r.URL.Path, "/blueprints")
 
@@ -21,26 +25,26 @@ func BlueprintsHandler(w http.ResponseWriter, r *http.Request) {
 	case path == "" || path == "/":
 		// Handle list and create
 		if r.Method == http.MethodPost {
-			createBlueprint(w, r)
+			s.createBlueprint(w, r)
 		} else {
-			listBlueprints(w, r)
+			s.listBlueprints(w, r)
 		}
 	case strings.HasPrefix(path, "/new"):
-		newBlueprint(w, r)
+		s.newBlueprint(w, r)
 	default:
 		// Handle detail/edit/delete routes
 		idStr := strings.TrimPrefix(path, "/")
 		if strings.HasSuffix(idStr, "/edit") {
 			idStr = strings.TrimSuffix(idStr, "/edit")
-			editBlueprint(w, r, idStr)
+			s.editBlueprint(w, r, idStr)
 		} else {
-			blueprintDetail(w, r, idStr)
+			s.blueprintDetail(w, r, idStr)
 		}
 	}
 }
 
-func listBlueprints(w http.ResponseWriter, r *http.Request) {
-	blueprints, err := database.GetBlueprints(database.DB)
+func (s *Server) listBlueprints(w http.ResponseWriter, r *http.Request) {
+	blueprints, err := s.store.Blueprints.List(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to load blueprints", http.StatusInternalServerError)
 		return
@@ -54,27 +58,27 @@ func listBlueprints(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func blueprintDetail(w http.ResponseWriter, r *http.Request, idStr string) {
+func (s *Server) blueprintDetail(w http.ResponseWriter, r *http.Request, idStr string) {
 	switch r.Method {
 	case http.MethodGet:
-		getBlueprintDetail(w, r, idStr)
+		s.getBlueprintDetail(w, r, idStr)
 	case http.MethodPost:
-		updateBlueprint(w, r, idStr)
+		s.updateBlueprint(w, r, idStr)
 	case http.MethodDelete:
-		deleteBlueprint(w, r, idStr)
+		s.deleteBlueprint(w, r, idStr)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func getBlueprintDetail(w http.ResponseWriter, r *http.Request, idStr string) {
+func (s *Server) getBlueprintDetail(w http.ResponseWriter, r *http.Request, idStr string) {
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid blueprint ID", http.StatusBadRequest)
 		return
 	}
 
-	blueprint, chores, err := database.GetBlueprint(database.DB, id)
+	blueprint, chores, err := s.store.Blueprints.Get(r.Context(), id)
 	if err != nil {
 		log.Printf("Failed to load blueprint (ID: %d): %v", id, err)
 		http.Error(w, "Failed to load blueprint", http.StatusInternalServerError)
@@ -92,39 +96,18 @@ func getBlueprintDetail(w http.ResponseWriter, r *http.Request, idStr string) {
 	}
 }
 
-func newBlueprint(w http.ResponseWriter, r *http.Request) {
-	chores, err := database.GetChores(database.DB)
-	if err != nil {
-		http.Error(w, "Failed to load chores", http.StatusInternalServerError)
-		return
-	}
-
-	imageFiles, err := utils.GetImageFiles()
-	if err != nil {
-		// Log the error but continue, maybe show a message in the form?
-		log.Printf("Warning: Failed to load image files: %v", err)
-		// Or return an error response:
-		// http.Error(w, "Failed to load image files", http.StatusInternalServerError)
-		// return
-	}
-
-	blueprint := &models.RoutineBlueprint{}
-	content := templates.BlueprintForm(blueprint, chores, imageFiles)
-	if r.Header.Get("HX-Request") == "true" {
-		content.Render(r.Context(), w)
-	} else {
-		templates.AdminBase(content).Render(r.Context(), w)
-	}
+func (s *Server) newBlueprint(w http.ResponseWriter, r *http.Request) {
+	s.renderBlueprintForm(w, r, &models.RoutineBlueprint{}, "")
 }
 
-func editBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
+func (s *Server) editBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid blueprint ID", http.StatusBadRequest)
 		return
 	}
 
-	blueprint, _, err := database.GetBlueprint(database.DB, id)
+	blueprint, _, err := s.store.Blueprints.Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Failed to load blueprint", http.StatusInternalServerError)
 		return
@@ -134,7 +117,12 @@ func editBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 		return
 	}
 
-	chores, err := database.GetChores(database.DB)
+	s.renderBlueprintForm(w, r, blueprint, "")
+}
+
+// renderBlueprintForm shows the form for blueprint along with an error message, if any
+func (s *Server) renderBlueprintForm(w http.ResponseWriter, r *http.Request, blueprint *models.RoutineBlueprint, errorMessage string) {
+	chores, err := s.store.Chores.List(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to load chores", http.StatusInternalServerError)
 		return
@@ -146,7 +134,7 @@ func editBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 		// Continue without images rather than failing completely
 	}
 
-	content := templates.BlueprintForm(blueprint, chores, imageFiles)
+	content := templates.BlueprintForm(blueprint, chores, imageFiles, errorMessage)
 	if r.Header.Get("HX-Request") == "true" {
 		content.Render(r.Context(), w)
 	} else {
@@ -154,7 +142,74 @@ func editBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 	}
 }
 
-func updateBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
+// timesFromForm reads and validates when on a due day the blueprint's routines
+// can be started and must be done by. Fields are set as far as they parse
+func timesFromForm(r *http.Request, blueprint *models.RoutineBlueprint) error {
+	deadline, err := schedule.ParseTimeOfDay(r.FormValue("to_be_completed_by"))
+	if err != nil {
+		return errors.New("to be completed by must be a time like 08:00")
+	}
+	blueprint.ToBeCompletedBy = deadline
+
+	value := r.FormValue("available_from")
+	if value == "" {
+		return nil
+	}
+	from, err := schedule.ParseTimeOfDay(value)
+	if err != nil {
+		return errors.New("available from must be a time like 07:00")
+	}
+	blueprint.AvailableFrom = &from
+	if from >= deadline {
+		return errors.New("available from must be before the deadline")
+	}
+	return nil
+}
+
+// scheduleFromForm reads and validates the schedule fields of the blueprint form.
+// A schedule without a start date starts today, the household's day
+func scheduleFromForm(r *http.Request, today schedule.Date) (schedule.Schedule, error) {
+	sched := schedule.Schedule{
+		Frequency: schedule.Frequency(r.FormValue("recurrence")),
+	}
+
+	var err error
+	if sched.Interval, err = formInt(r, "recurrence_interval"); err != nil {
+		return sched, errors.New("every must be a whole number")
+	}
+	if sched.MonthDay, err = formInt(r, "month_day"); err != nil {
+		return sched, errors.New("day of the month must be a whole number")
+	}
+	for _, value := range r.Form["weekdays"] {
+		day, err := strconv.Atoi(value)
+		if err != nil || day < int(time.Sunday) || day > int(time.Saturday) {
+			return sched, fmt.Errorf("invalid weekday %q", value)
+		}
+		sched.Weekdays |= schedule.WeekdaysOf(time.Weekday(day))
+	}
+	if sched.Start, err = schedule.ParseDate(r.FormValue("starts_on")); err != nil {
+		return sched, err
+	}
+	if sched.End, err = schedule.ParseDate(r.FormValue("ends_on")); err != nil {
+		return sched, err
+	}
+
+	if sched.Frequency != "" && sched.Start.IsZero() {
+		sched.Start = today
+	}
+	return sched, sched.Validate()
+}
+
+// formInt parses an optional whole number form field, which is 0 when empty
+func formInt(r *http.Request, name string) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func (s *Server) updateBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
@@ -169,12 +224,24 @@ func updateBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 	blueprint := &models.RoutineBlueprint{
 		ID:                           id,
 		Name:                         r.FormValue("name"),
-		ToBeCompletedBy:              r.FormValue("to_be_completed_by"),
+		HideWhenOverdue:              r.FormValue("hide_when_overdue") == "on",
 		AllowMultipleInstancesPerDay: r.FormValue("allow_multiple_instances_per_day") == "on",
-		Recurrence:                   models.RecurrenceType(r.FormValue("recurrence")),
 		Image:                        r.FormValue("image"),
 	}
 
+	// Both are read before either error is shown, so the form keeps everything entered
+	timesErr := timesFromForm(r, blueprint)
+	sched, err := scheduleFromForm(r, s.today())
+	blueprint.Schedule = sched
+	if timesErr != nil {
+		s.renderBlueprintForm(w, r, blueprint, "Invalid times: "+timesErr.Error())
+		return
+	}
+	if err != nil {
+		s.renderBlueprintForm(w, r, blueprint, "Invalid schedule: "+err.Error())
+		return
+	}
+
 	// Get selected chores
 	choreIDs := []int64{}
 	for _, idStr := range r.Form["chores"] {
@@ -185,9 +252,9 @@ func updateBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 
 	var saveErr error
 	if id == 0 {
-		saveErr = database.CreateBlueprint(database.DB, blueprint, choreIDs)
+		saveErr = s.store.Blueprints.Create(r.Context(), blueprint, choreIDs)
 	} else {
-		saveErr = database.UpdateBlueprint(database.DB, blueprint, choreIDs)
+		saveErr = s.store.Blueprints.Update(r.Context(), blueprint, choreIDs)
 	}
 
 	if saveErr != nil {
@@ -204,14 +271,18 @@ func updateBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 	}
 }
 
-func deleteBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
+func (s *Server) deleteBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid blueprint ID", http.StatusBadRequest)
 		return
 	}
 
-	if err := database.DeleteBlueprint(database.DB, id); err != nil {
+	if err := s.store.Blueprints.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, database.ErrInUse) {
+			http.Error(w, "Blueprint has routines and can't be deleted", http.StatusConflict)
+			return
+		}
 		log.Printf("Error deleting blueprint (ID: %d): %v", id, err)
 		http.Error(w, "Failed to delete blueprint", http.StatusInternalServerError)
 		return
@@ -226,18 +297,30 @@ func deleteBlueprint(w http.ResponseWriter, r *http.Request, idStr string) {
 }
 
 // createBlueprint handles creation of new RoutineBlueprint
-func createBlueprint(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createBlueprint(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
 	blueprint := &models.RoutineBlueprint{
 		Name:                         r.FormValue("name"),
-		ToBeCompletedBy:              r.FormValue("to_be_completed_by"),
+		HideWhenOverdue:              r.FormValue("hide_when_overdue") == "on",
 		AllowMultipleInstancesPerDay: r.FormValue("allow_multiple_instances_per_day") == "on",
-		Recurrence:                   models.RecurrenceType(r.FormValue("recurrence")),
 		Image:                        r.FormValue("image"),
 	}
+
+	// Both are read before either error is shown, so the form keeps everything entered
+	timesErr := timesFromForm(r, blueprint)
+	sched, err := scheduleFromForm(r, s.today())
+	blueprint.Schedule = sched
+	if timesErr != nil {
+		s.renderBlueprintForm(w, r, blueprint, "Invalid times: "+timesErr.Error())
+		return
+	}
+	if err != nil {
+		s.renderBlueprintForm(w, r, blueprint, "Invalid schedule: "+err.Error())
+		return
+	}
 	// Get selected chores
 	choreIDs := []int64{}
 	for _, idStr := range r.Form["chores"] {
@@ -246,7 +329,7 @@ func createBlueprint(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	// Save new blueprint
-	if err := database.CreateBlueprint(database.DB, blueprint, choreIDs); err != nil {
+	if err := s.store.Blueprints.Create(r.Context(), blueprint, choreIDs); err != nil {
 		log.Printf("Error creating blueprint: %v", err)
 		http.Error(w, "Failed to create blueprint", http.StatusInternalServerError)
 		return
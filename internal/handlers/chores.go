@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,33 +14,33 @@ import (
 	"github.com/bagvendt/chores/internal/utils"
 )
 
-func ChoresHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ChoresHandler(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/chores")
 
 	switch {
 	case path == "" || path == "/":
 		if r.Method == http.MethodPost {
-			createChore(w, r)
+			s.createChore(w, r)
 		} else {
-			listChores(w, r)
+			s.listChores(w, r)
 		}
 	case strings.HasPrefix(path, "/new"):
-		newChore(w, r)
+		s.newChore(w, r)
 	default:
 		idStr := strings.TrimPrefix(path, "/")
 		if strings.HasSuffix(idStr, "/edit") {
 			idStr = strings.TrimSuffix(idStr, "/edit")
-			editChore(w, r, idStr)
+			s.editChore(w, r, idStr)
 		} else if r.Method == http.MethodDelete {
-			deleteChore(w, r, idStr)
+			s.deleteChore(w, r, idStr)
 		} else {
-			choreDetail(w, r, idStr)
+			s.choreDetail(w, r, idStr)
 		}
 	}
 }
 
-func listChores(w http.ResponseWriter, r *http.Request) {
-	chores, err := database.GetChores(database.DB)
+func (s *Server) listChores(w http.ResponseWriter, r *http.Request) {
+	chores, err := s.store.Chores.List(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to load chores", http.StatusInternalServerError)
 		return
@@ -52,13 +53,13 @@ func listChores(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func choreDetail(w http.ResponseWriter, r *http.Request, idStr string) {
+func (s *Server) choreDetail(w http.ResponseWriter, r *http.Request, idStr string) {
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid chore ID", http.StatusBadRequest)
 		return
 	}
-	chore, err := database.GetChore(database.DB, id)
+	chore, err := s.store.Chores.Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Failed to load chore", http.StatusInternalServerError)
 		return
@@ -75,9 +76,9 @@ func choreDetail(w http.ResponseWriter, r *http.Request, idStr string) {
 	}
 }
 
-func newChore(w http.ResponseWriter, r *http.Request) {
+func (s *Server) newChore(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		createChore(w, r)
+		s.createChore(w, r)
 		return
 	}
 	chore := &models.Chore{}
@@ -97,7 +98,7 @@ func newChore(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func createChore(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createChore(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
@@ -107,7 +108,7 @@ func createChore(w http.ResponseWriter, r *http.Request) {
 		DefaultPoints: atoiOrZero(r.FormValue("default_points")),
 		Image:         r.FormValue("image"),
 	}
-	if err := database.CreateChore(database.DB, chore); err != nil {
+	if err := s.store.Chores.Create(r.Context(), chore); err != nil {
 		http.Error(w, "Failed to create chore", http.StatusInternalServerError)
 		return
 	}
@@ -118,14 +119,14 @@ func createChore(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func editChore(w http.ResponseWriter, r *http.Request, idStr string) {
+func (s *Server) editChore(w http.ResponseWriter, r *http.Request, idStr string) {
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid chore ID", http.StatusBadRequest)
 		return
 	}
 
-	chore, err := database.GetChore(database.DB, id)
+	chore, err := s.store.Chores.Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Failed to load chore", http.StatusInternalServerError)
 		return
@@ -164,7 +165,7 @@ func editChore(w http.ResponseWriter, r *http.Request, idStr string) {
 			chore.DefaultPoints,
 			chore.Image)
 
-		if err := database.UpdateChore(database.DB, chore); err != nil {
+		if err := s.store.Chores.Update(r.Context(), chore); err != nil {
 			log.Printf("Error updating chore: %v", err)
 			http.Error(w, "Failed to update chore", http.StatusInternalServerError)
 			return
@@ -193,13 +194,17 @@ func editChore(w http.ResponseWriter, r *http.Request, idStr string) {
 	}
 }
 
-func deleteChore(w http.ResponseWriter, r *http.Request, idStr string) {
+func (s *Server) deleteChore(w http.ResponseWriter, r *http.Request, idStr string) {
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid chore ID", http.StatusBadRequest)
 		return
 	}
-	if err := database.DeleteChore(database.DB, id); err != nil {
+	if err := s.store.Chores.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, database.ErrInUse) {
+			http.Error(w, "Chore is used by a blueprint or routine", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to delete chore", http.StatusInternalServerError)
 		return
 	}
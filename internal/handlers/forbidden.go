@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/bagvendt/chores/internal/templates"
+)
+
+// ForbiddenHandler renders the 403 page for users who lack permission
+func ForbiddenHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	templates.Base(templates.Forbidden()).Render(r.Context(), w)
+}
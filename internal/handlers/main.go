@@ -1,12 +1,12 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/bagvendt/chores/internal/contextkeys"
-	"github.com/bagvendt/chores/internal/database" // Add database import
 	"github.com/bagvendt/chores/internal/models"
-	"github.com/bagvendt/chores/internal/services"
 	"github.com/bagvendt/chores/internal/templates"
 )
 
@@ -15,17 +15,37 @@ func MainHandler(w http.ResponseWriter, r *http.Request) {
 	templates.AdminBase(content).Render(r.Context(), w)
 }
 
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	// Fetch routines for a specific user (e.g., user ID 1 for now)
-	// TODO: Replace '1' with actual user ID from session/context
-	routineService := services.NewRoutineService(database.DB)
-
+func (s *Server) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	user, ok := r.Context().Value(contextkeys.UserContextKey).(*models.User)
 	if !ok || user == nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	routines, err := routineService.GetRelevantRoutines(user.ID)
+
+	// Parents pick which child they are running routines for
+	actingFor := user
+	var children []models.User
+	if user.IsParent() {
+		var err error
+		children, err = s.store.Users.Children(r.Context(), user.ID)
+		if err != nil {
+			log.Printf("Failed to load children for user %d: %v", user.ID, err)
+			http.Error(w, "Failed to load children", http.StatusInternalServerError)
+			return
+		}
+		if len(children) > 0 {
+			actingFor = &children[0]
+			if childID, err := strconv.ParseInt(r.URL.Query().Get("child"), 10, 64); err == nil {
+				for i := range children {
+					if children[i].ID == childID {
+						actingFor = &children[i]
+					}
+				}
+			}
+		}
+	}
+
+	routines, err := s.routines.GetRelevantRoutines(r.Context(), actingFor.ID)
 	if err != nil {
 		// Handle error appropriately, maybe show an error page or log
 		http.Error(w, "Failed to load routines", http.StatusInternalServerError)
@@ -33,6 +53,6 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Pass routines to the template
-	content := templates.Home(routines)
+	content := templates.Home(routines, children, actingFor)
 	templates.Base(content).Render(r.Context(), w)
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,7 +9,6 @@ import (
 	"strings"
 
 	"github.com/bagvendt/chores/internal/contextkeys"
-	"github.com/bagvendt/chores/internal/database"
 	"github.com/bagvendt/chores/internal/models"
 	"github.com/bagvendt/chores/internal/services"
 	"github.com/bagvendt/chores/internal/templates"
@@ -23,15 +23,16 @@ type ChoreWithStatus struct {
 }
 
 // RoutineDetailHandler specifically handles the new routine detail view that displays chore cards
-func RoutineDetailHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) RoutineDetailHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the routine ID from the URL path
 	path := strings.TrimPrefix(r.URL.Path, "/routine/")
 
 	// Handle the case where we're creating a routine from a blueprint
 	if strings.HasPrefix(path, "create-from-blueprint/") {
 		blueprintIDStr := strings.TrimPrefix(path, "create-from-blueprint/")
-		if r.Method == http.MethodGet || r.Method == http.MethodPost {
-			createRoutineFromBlueprint(w, r, blueprintIDStr)
+		// Only POST, so the CSRF check guards it and a link can't start routines
+		if r.Method == http.MethodPost {
+			s.createRoutineFromBlueprint(w, r, blueprintIDStr)
 			return
 		}
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -44,20 +45,25 @@ func RoutineDetailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch the routine from the database
-	routine, err := database.GetRoutine(database.DB, id)
-	if err != nil {
-		http.Error(w, "Failed to load routine", http.StatusInternalServerError)
-		return
-	}
-	if routine == nil {
+	user, _ := r.Context().Value(contextkeys.UserContextKey).(*models.User)
+
+	// Fetch the routine, making sure the user may see it
+	routine, err := s.auth.AuthorizeRoutine(r.Context(), user, id)
+	switch {
+	case errors.Is(err, services.ErrRoutineNotFound):
 		http.Error(w, "Routine not found", http.StatusNotFound)
 		return
+	case errors.Is(err, services.ErrForbidden):
+		ForbiddenHandler(w, r)
+		return
+	case err != nil:
+		log.Printf("Failed to load routine (ID: %d): %v", id, err)
+		http.Error(w, "Failed to load routine", http.StatusInternalServerError)
+		return
 	}
 
-	// Use the new ChoreService to fetch chores for this routine
-	choreService := services.NewChoreService(database.DB)
-	choreRoutines, err := choreService.GetChoresForRoutine(id)
+	// Use the ChoreService to fetch chores for this routine
+	choreRoutines, err := s.chores.GetChoresForRoutine(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Failed to load chores for routine", http.StatusInternalServerError)
 		return
@@ -80,7 +86,7 @@ func RoutineDetailHandler(w http.ResponseWriter, r *http.Request) {
 	templates.Base(content).Render(r.Context(), w)
 }
 
-func createRoutineFromBlueprint(w http.ResponseWriter, r *http.Request, blueprintIDStr string) {
+func (s *Server) createRoutineFromBlueprint(w http.ResponseWriter, r *http.Request, blueprintIDStr string) {
 	// Parse the blueprint ID
 	blueprintID, err := strconv.ParseInt(blueprintIDStr, 10, 64)
 	if err != nil {
@@ -88,18 +94,6 @@ func createRoutineFromBlueprint(w http.ResponseWriter, r *http.Request, blueprin
 		return
 	}
 
-	// Get the blueprint from the database
-	blueprint, blueprintChores, err := database.GetBlueprint(database.DB, blueprintID)
-	if err != nil {
-		log.Printf("Failed to get blueprint: %v", err)
-		http.Error(w, "Failed to load blueprint", http.StatusInternalServerError)
-		return
-	}
-	if blueprint == nil {
-		http.Error(w, "Blueprint not found", http.StatusNotFound)
-		return
-	}
-
 	// Make sure the user is authenticated
 	user, ok := r.Context().Value(contextkeys.UserContextKey).(*models.User)
 	if !ok || user == nil {
@@ -107,34 +101,39 @@ func createRoutineFromBlueprint(w http.ResponseWriter, r *http.Request, blueprin
 		return
 	}
 
-	// Create a new routine from the blueprint
-	routine := &models.Routine{
-		OwnerID: user.ID,
+	// The routine belongs to the logged in user, unless a parent is acting for one of their children
+	ownerID := user.ID
+	if ownerStr := r.URL.Query().Get("owner"); ownerStr != "" {
+		ownerID, err = strconv.ParseInt(ownerStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid owner ID", http.StatusBadRequest)
+			return
+		}
 	}
-
-	// Setup RoutineBlueprintID
-	routine.RoutineBlueprintID.Int64 = blueprint.ID
-	routine.RoutineBlueprintID.Valid = true
-
-	// Use the image from the blueprint if available
-	routine.ImageUrl = blueprint.Image
-
-	// Save the new routine to the database
-	if err := database.CreateRoutine(database.DB, routine); err != nil {
-		log.Printf("Failed to create routine: %v", err)
+	allowed, err := s.auth.CanActFor(r.Context(), user, ownerID)
+	if err != nil {
+		log.Printf("Failed to check access to user %d: %v", ownerID, err)
 		http.Error(w, "Failed to create routine", http.StatusInternalServerError)
 		return
 	}
+	if !allowed {
+		ForbiddenHandler(w, r)
+		return
+	}
 
-	// Create chore_routines for each blueprint chore
-	for _, bc := range blueprintChores {
-		_, err := database.UpsertChoreRoutine(database.DB, routine.ID, bc.ChoreID, false, user.ID)
-		if err != nil {
-			log.Printf("Warning: Failed to create chore_routine: %v", err)
-			// Continue with the rest of the chores
-		}
+	// Start today's routine with its chores. Tapping the card again opens the
+	// same routine, unless the blueprint may be done several times a day
+	routine, err := s.routines.StartRoutine(r.Context(), ownerID, blueprintID)
+	switch {
+	case errors.Is(err, services.ErrBlueprintNotFound):
+		http.Error(w, "Blueprint not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Failed to create routine: %v", err)
+		http.Error(w, "Failed to create routine", http.StatusInternalServerError)
+		return
 	}
 
-	// Redirect to the new routine detail page
+	// Redirect to the routine detail page
 	http.Redirect(w, r, fmt.Sprintf("/routine/%d", routine.ID), http.StatusSeeOther)
 }
@@ -7,34 +7,33 @@ import (
 	"strings"
 
 	"github.com/bagvendt/chores/internal/contextkeys"
-	"github.com/bagvendt/chores/internal/database"
 	"github.com/bagvendt/chores/internal/models"
 	"github.com/bagvendt/chores/internal/templates"
 )
 
-func RoutinesHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) RoutinesHandler(w http.ResponseWriter, r *http.Request) {
 	// Strip prefix to get the path
 	path := strings.TrimPrefix(r.URL.Path, "/routines")
 
 	// Handle different routes
 	switch {
 	case path == "" || path == "/":
-		listRoutines(w, r)
+		s.listRoutines(w, r)
 	case strings.HasPrefix(path, "/new"):
 		newRoutine(w, r)
 	default:
 		// Assume it's a routine detail page
-		routineDetail(w, r, strings.TrimPrefix(path, "/"))
+		s.routineDetail(w, r, strings.TrimPrefix(path, "/"))
 	}
 }
 
-func listRoutines(w http.ResponseWriter, r *http.Request) {
+func (s *Server) listRoutines(w http.ResponseWriter, r *http.Request) {
 	user, ok := r.Context().Value(contextkeys.UserContextKey).(*models.User)
 	if !ok || user == nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	routines, err := database.GetRoutines(database.DB, user.ID)
+	routines, err := s.store.Routines.List(r.Context(), user.ID)
 	if err != nil {
 		log.Printf("Failed to load routines: %v", err)
 		http.Error(w, "Failed to load routines", http.StatusInternalServerError)
@@ -49,14 +48,14 @@ func listRoutines(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func routineDetail(w http.ResponseWriter, r *http.Request, idStr string) {
+func (s *Server) routineDetail(w http.ResponseWriter, r *http.Request, idStr string) {
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid routine ID", http.StatusBadRequest)
 		return
 	}
 
-	routine, err := database.GetRoutine(database.DB, id)
+	routine, err := s.store.Routines.Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Failed to load routine", http.StatusInternalServerError)
 		return
@@ -75,7 +74,7 @@ func routineDetail(w http.ResponseWriter, r *http.Request, idStr string) {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		routines, err := database.GetRoutines(database.DB, user.ID)
+		routines, err := s.store.Routines.List(r.Context(), user.ID)
 		if err != nil {
 			http.Error(w, "Failed to load routines", http.StatusInternalServerError)
 			return
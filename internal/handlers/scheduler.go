@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/bagvendt/chores/internal/templates"
+)
+
+// SchedulerHandler shows the scheduler's last run and lets admins run it now
+func (s *Server) SchedulerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/scheduler" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Redirect after running, so refreshing the page doesn't run it again
+	if r.Method == http.MethodPost {
+		url := "/admin/scheduler"
+		if run, err := s.scheduler.Run(r.Context()); err != nil {
+			log.Printf("Scheduler run for %s failed: %v", run.Day, err)
+			url += "?failed=1"
+		}
+		if r.Header.Get("HX-Request") == "true" {
+			w.Header().Set("HX-Redirect", url)
+		} else {
+			http.Redirect(w, r, url, http.StatusSeeOther)
+		}
+		return
+	}
+
+	errorMessage := ""
+	if r.URL.Query().Get("failed") == "1" {
+		errorMessage = "The scheduler failed, see the log. Routines it did start are kept."
+	}
+
+	content := templates.Scheduler(s.scheduler.LastRun(), errorMessage)
+	if r.Header.Get("HX-Request") == "true" {
+		content.Render(r.Context(), w)
+	} else {
+		templates.AdminBase(content).Render(r.Context(), w)
+	}
+}
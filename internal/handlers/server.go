@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bagvendt/chores/internal/database"
+	"github.com/bagvendt/chores/internal/schedule"
+	"github.com/bagvendt/chores/internal/services"
+)
+
+// Server holds the store and services the handlers work with
+type Server struct {
+	store          *database.Store
+	routines       *services.RoutineService
+	chores         *services.ChoreService
+	auth           *services.AuthorizationService
+	authentication *services.AuthenticationService
+	limiter        *services.LoginRateLimiter
+	scheduler      *services.Scheduler
+	household      *time.Location
+	behindFly      bool
+}
+
+// BehindFlyEnv names the environment variable that says the server runs
+// behind the Fly proxy, so the client's address is taken from the
+// Fly-Client-IP header the proxy sets
+const BehindFlyEnv = "BEHIND_FLY"
+
+// BehindFly reports whether BEHIND_FLY is set to a true value
+func BehindFly() (bool, error) {
+	value := os.Getenv(BehindFlyEnv)
+	if value == "" {
+		return false, nil
+	}
+	behindFly, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, expected true or false", BehindFlyEnv, value)
+	}
+	return behindFly, nil
+}
+
+// NewServer creates a Server on top of the given store. Days start at
+// midnight in household. The authentication service, login rate limiter and
+// scheduler are shared with the middleware and background loops that use them.
+// Only when behindFly is set are clients identified by the Fly-Client-IP header
+func NewServer(store *database.Store, authentication *services.AuthenticationService, limiter *services.LoginRateLimiter, scheduler *services.Scheduler, household *time.Location, behindFly bool) *Server {
+	return &Server{
+		store:          store,
+		routines:       services.NewRoutineService(store.Routines, store.Blueprints, store.ChoreRoutines, services.SystemClock{}, household),
+		chores:         services.NewChoreService(store.Routines, store.Blueprints, store.ChoreRoutines),
+		auth:           services.NewAuthorizationService(store.Routines, store.Users),
+		authentication: authentication,
+		limiter:        limiter,
+		scheduler:      scheduler,
+		household:      household,
+		behindFly:      behindFly,
+	}
+}
+
+// today returns the household's current date
+func (s *Server) today() schedule.Date {
+	return schedule.DateOf(time.Now().In(s.household))
+}
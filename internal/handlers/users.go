@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bagvendt/chores/internal/contextkeys"
+	"github.com/bagvendt/chores/internal/database"
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/templates"
+	"github.com/bagvendt/chores/internal/utils"
+	"github.com/bagvendt/chores/internal/utils/auth"
+)
+
+func (s *Server) UsersHandler(w http.ResponseWriter, r *http.Request) {
+	// Strip prefix to get the path
+	path := strings.TrimPrefix(r.URL.Path, "/users")
+
+	// Handle different routes
+	switch {
+	case path == "" || path == "/":
+		// Handle list and create
+		if r.Method == http.MethodPost {
+			s.createUser(w, r)
+		} else {
+			s.listUsers(w, r)
+		}
+	case strings.HasPrefix(path, "/new"):
+		s.newUser(w, r)
+	default:
+		// Handle detail/edit/password/PIN/activation routes
+		idStr := strings.TrimPrefix(path, "/")
+		switch {
+		case strings.HasSuffix(idStr, "/edit"):
+			s.editUser(w, r, strings.TrimSuffix(idStr, "/edit"))
+		case strings.HasSuffix(idStr, "/pin/clear"):
+			s.clearUserPIN(w, r, strings.TrimSuffix(idStr, "/pin/clear"))
+		case strings.HasSuffix(idStr, "/pin"):
+			s.setUserPIN(w, r, strings.TrimSuffix(idStr, "/pin"))
+		case strings.HasSuffix(idStr, "/password"):
+			s.resetUserPassword(w, r, strings.TrimSuffix(idStr, "/password"))
+		case strings.HasSuffix(idStr, "/deactivate"):
+			s.setUserActive(w, r, strings.TrimSuffix(idStr, "/deactivate"), false)
+		case strings.HasSuffix(idStr, "/activate"):
+			s.setUserActive(w, r, strings.TrimSuffix(idStr, "/activate"), true)
+		default:
+			s.userDetail(w, r, idStr)
+		}
+	}
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.store.Users.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to load users: %v", err)
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+
+	content := templates.Users(users)
+	if r.Header.Get("HX-Request") == "true" {
+		content.Render(r.Context(), w)
+	} else {
+		templates.AdminBase(content).Render(r.Context(), w)
+	}
+}
+
+func (s *Server) userDetail(w http.ResponseWriter, r *http.Request, idStr string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getUserDetail(w, r, idStr)
+	case http.MethodPost:
+		s.updateUser(w, r, idStr)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) getUserDetail(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.Users.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to load user (ID: %d): %v", id, err)
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	children, err := s.store.Users.Children(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to load children (ID: %d): %v", id, err)
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	content := templates.UserDetail(user, children)
+	if r.Header.Get("HX-Request") == "true" {
+		content.Render(r.Context(), w)
+	} else {
+		templates.AdminBase(content).Render(r.Context(), w)
+	}
+}
+
+func (s *Server) newUser(w http.ResponseWriter, r *http.Request) {
+	s.renderUserForm(w, r, &models.User{Role: models.RoleChild}, nil, "")
+}
+
+func (s *Server) editUser(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.Users.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	children, err := s.store.Users.Children(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	childIDs := make([]int64, 0, len(children))
+	for _, child := range children {
+		childIDs = append(childIDs, child.ID)
+	}
+
+	s.renderUserForm(w, r, user, childIDs, "")
+}
+
+// renderUserForm shows the create/edit form, optionally with a validation error
+func (s *Server) renderUserForm(w http.ResponseWriter, r *http.Request, user *models.User, childIDs []int64, errorMessage string) {
+	users, err := s.store.Users.List(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+
+	var candidates []models.User
+	for _, u := range users {
+		if canBeChildOf(u, user.ID) {
+			candidates = append(candidates, u)
+		}
+	}
+
+	selected := make(map[int64]bool)
+	for _, id := range childIDs {
+		selected[id] = true
+	}
+
+	imageFiles, err := utils.GetImageFiles()
+	if err != nil {
+		log.Printf("Warning: Failed to load image files: %v", err)
+	}
+
+	content := templates.UserForm(user, candidates, selected, imageFiles, errorMessage)
+	if r.Header.Get("HX-Request") == "true" {
+		content.Render(r.Context(), w)
+	} else {
+		templates.AdminBase(content).Render(r.Context(), w)
+	}
+}
+
+// canBeChildOf reports whether u can be picked as a child of the user with
+// the given ID. Only other active children can be picked
+func canBeChildOf(u models.User, parentID int64) bool {
+	return u.Role == models.RoleChild && u.Active && u.ID != parentID
+}
+
+// validChildren reports whether every ID in childIDs is a user that can be
+// picked as a child of parentID, so a tampered form can't link anyone else
+func (s *Server) validChildren(ctx context.Context, parentID int64, childIDs []int64) (bool, error) {
+	if len(childIDs) == 0 {
+		return true, nil
+	}
+
+	users, err := s.store.Users.List(ctx)
+	if err != nil {
+		return false, err
+	}
+	candidates := make(map[int64]bool)
+	for _, u := range users {
+		if canBeChildOf(u, parentID) {
+			candidates[u.ID] = true
+		}
+	}
+
+	for _, id := range childIDs {
+		if !candidates[id] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// userFromForm reads the fields shared by the create and update forms
+func userFromForm(r *http.Request) (*models.User, []int64) {
+	user := &models.User{
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		IsAdmin: r.FormValue("is_admin") == "on",
+		Role:    models.Role(r.FormValue("role")),
+		Avatar:  r.FormValue("avatar"),
+	}
+	if user.Role != models.RoleParent {
+		user.Role = models.RoleChild
+	}
+
+	// Only parents have children, so a child's selection is cleared
+	childIDs := []int64{}
+	if user.Role != models.RoleParent {
+		return user, childIDs
+	}
+	for _, idStr := range r.Form["children"] {
+		if childID, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			childIDs = append(childIDs, childID)
+		}
+	}
+
+	return user, childIDs
+}
+
+// createUser handles creation of a new user
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	user, childIDs := userFromForm(r)
+
+	password := r.FormValue("password")
+	if user.Name == "" || password == "" {
+		s.renderUserForm(w, r, user, childIDs, "Name and password are required")
+		return
+	}
+	if err := auth.ValidatePassword(password); err != nil {
+		s.renderUserForm(w, r, user, childIDs, "The password must be at least "+strconv.Itoa(auth.MinPasswordLength)+" characters")
+		return
+	}
+
+	valid, err := s.validChildren(r.Context(), user.ID, childIDs)
+	if err != nil {
+		log.Printf("Error checking children: %v", err)
+		http.Error(w, "Failed to save children", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		s.renderUserForm(w, r, user, childIDs, "Only other active children can be picked")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+	user.Password = hashedPassword
+
+	if err := s.store.Users.Create(r.Context(), user, childIDs); err != nil {
+		if errors.Is(err, database.ErrUserNameTaken) {
+			s.renderUserForm(w, r, user, childIDs, "A user with that name already exists")
+			return
+		}
+		log.Printf("Error creating user: %v", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+
+	// Redirect to user list
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", "/admin/users")
+	} else {
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}
+}
+
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request, idStr string) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, childIDs := userFromForm(r)
+	user.ID = id
+	if user.Name == "" {
+		s.renderUserForm(w, r, user, childIDs, "Name is required")
+		return
+	}
+
+	// Admins can't lock themselves out of the admin pages
+	current, ok := r.Context().Value(contextkeys.UserContextKey).(*models.User)
+	if ok && current != nil && current.ID == id && !user.IsAdmin {
+		s.renderUserForm(w, r, user, childIDs, "You can't remove your own admin access")
+		return
+	}
+
+	valid, err := s.validChildren(r.Context(), user.ID, childIDs)
+	if err != nil {
+		log.Printf("Error checking children: %v", err)
+		http.Error(w, "Failed to save children", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		s.renderUserForm(w, r, user, childIDs, "Only other active children can be picked")
+		return
+	}
+
+	if err := s.store.Users.Update(r.Context(), user, childIDs); err != nil {
+		if errors.Is(err, database.ErrUserNameTaken) {
+			s.renderUserForm(w, r, user, childIDs, "A user with that name already exists")
+			return
+		}
+		log.Printf("Error updating user (ID: %d): %v", id, err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToUser(w, r, id)
+}
+
+func (s *Server) resetUserPassword(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	password := r.FormValue("password")
+	if password == "" {
+		http.Error(w, "Password is required", http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidatePassword(password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.store.Users.SetPassword(r.Context(), id, hashedPassword)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error resetting password (ID: %d): %v", id, err)
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password shouldn't stay signed in with it
+	if err := s.authentication.ClearUserSessions(r.Context(), id); err != nil {
+		log.Printf("Error clearing sessions after password reset (ID: %d): %v", id, err)
+		http.Error(w, "Password was reset, but failed to sign the user out", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToUser(w, r, id)
+}
+
+func (s *Server) setUserPIN(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.Users.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to load user (ID: %d): %v", id, err)
+		http.Error(w, "Failed to set PIN", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	// Admins always log in with their full password
+	if user.IsAdmin {
+		http.Error(w, "Admins can't use PIN login", http.StatusBadRequest)
+		return
+	}
+
+	hashedPIN, err := auth.HashPIN(r.FormValue("pin"))
+	if errors.Is(err, auth.ErrInvalidPINFormat) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error hashing PIN: %v", err)
+		http.Error(w, "Failed to set PIN", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.store.Users.SetPIN(r.Context(), id, hashedPIN); err != nil {
+		log.Printf("Error setting PIN (ID: %d): %v", id, err)
+		http.Error(w, "Failed to set PIN", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToUser(w, r, id)
+}
+
+func (s *Server) clearUserPIN(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.Users.SetPIN(r.Context(), id, ""); err != nil {
+		log.Printf("Error clearing PIN (ID: %d): %v", id, err)
+		http.Error(w, "Failed to remove PIN", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToUser(w, r, id)
+}
+
+func (s *Server) setUserActive(w http.ResponseWriter, r *http.Request, idStr string, active bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	current, ok := r.Context().Value(contextkeys.UserContextKey).(*models.User)
+	if !active && ok && current != nil && current.ID == id {
+		http.Error(w, "You can't deactivate yourself", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.Users.SetActive(r.Context(), id, active); err != nil {
+		log.Printf("Error changing active state (ID: %d): %v", id, err)
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToUser(w, r, id)
+}
+
+// redirectToUser sends the browser back to a user's detail page
+func redirectToUser(w http.ResponseWriter, r *http.Request, id int64) {
+	url := fmt.Sprintf("/admin/users/%d", id)
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", url)
+	} else {
+		http.Redirect(w, r, url, http.StatusSeeOther)
+	}
+}
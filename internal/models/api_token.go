@@ -0,0 +1,16 @@
+package models
+
+// APIToken is a named personal access token used with Authorization: Bearer.
+// Only the hash of the token is stored.
+type APIToken struct {
+	ID        int64      `json:"id"`
+	Created   Timestamp  `json:"created"`
+	UserID    int64      `json:"user_id"`
+	Name      string     `json:"name"`
+	TokenHash string     `json:"-"`
+	LastUsed  *Timestamp `json:"last_used,omitempty"`
+	Revoked   *Timestamp `json:"revoked,omitempty"`
+
+	// These fields are not stored in the database but can be populated for convenience
+	User *User `json:"user,omitempty"`
+}
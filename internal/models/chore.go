@@ -1,11 +1,9 @@
 package models
 
-import "time"
-
 type Chore struct {
 	ID            int64     `json:"id"`
-	Created       time.Time `json:"created"`
-	Modified      time.Time `json:"modified"`
+	Created       Timestamp `json:"created"`
+	Modified      Timestamp `json:"modified"`
 	Name          string    `json:"name"`
 	DefaultPoints int       `json:"default_points"`
 	Image         string    `json:"image,omitempty"`
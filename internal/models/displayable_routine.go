@@ -1,6 +1,6 @@
 package models
 
-import "time"
+import "github.com/bagvendt/chores/internal/schedule"
 
 // SourceType identifies whether a DisplayableRoutine comes from a database record or a blueprint
 type SourceType string
@@ -15,20 +15,22 @@ const (
 // generated from a RoutineBlueprint
 type DisplayableRoutine struct {
 	// Fields common to both sources
-	ID              int64  `json:"id"`
-	Name            string `json:"name"`
-	ToBeCompletedBy string `json:"to_be_completed_by"`
-	ImageUrl        string `json:"image_url,omitempty"`
-	OwnerID         int64  `json:"owner_id"`
-	Owner           *User  `json:"owner,omitempty"`
+	ID              int64              `json:"id"`
+	Name            string             `json:"name"`
+	ToBeCompletedBy schedule.TimeOfDay `json:"to_be_completed_by"`
+	ImageUrl        string             `json:"image_url,omitempty"`
+	OwnerID         int64              `json:"owner_id"`
+	Owner           *User              `json:"owner,omitempty"`
+	// Overdue is set for unfinished routines past their deadline
+	Overdue bool `json:"overdue"`
 
 	// Metadata
 	SourceType  SourceType `json:"source_type"`
 	BlueprintID *int64     `json:"blueprint_id,omitempty"` // Only present for blueprint-sourced routines
 
 	// If from a database source, includes these fields
-	Created  *time.Time `json:"created,omitempty"`
-	Modified *time.Time `json:"modified,omitempty"`
+	Created  *Timestamp `json:"created,omitempty"`
+	Modified *Timestamp `json:"modified,omitempty"`
 
 	// Information about chores in this routine
 	ChoreCount      int `json:"chore_count"`
@@ -0,0 +1,18 @@
+package models
+
+// Reasons a login attempt was rejected
+const (
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginInvalidPIN         = "invalid_pin"
+	LoginRateLimited        = "rate_limited"
+)
+
+// LoginAttempt is an audit record of a rejected login
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Created   Timestamp `json:"created"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Reason    string    `json:"reason"`
+}
@@ -2,16 +2,19 @@ package models
 
 import (
 	"database/sql"
-	"time"
+
+	"github.com/bagvendt/chores/internal/schedule"
 )
 
 type Routine struct {
 	ID                 int64         `json:"id"`
-	Created            time.Time     `json:"created"`
-	Modified           time.Time     `json:"modified"`
+	Created            Timestamp     `json:"created"`
+	Modified           Timestamp     `json:"modified"`
 	OwnerID            int64         `json:"owner_id"`
 	RoutineBlueprintID sql.NullInt64 `json:"routine_blueprint_id,omitempty"`
 	ImageUrl           string        `json:"image_url,omitempty"`
+	Day                schedule.Date `json:"day,omitzero"`     // the household day it was started on
+	Missed             bool          `json:"missed,omitempty"` // not finished on its day
 
 	// These fields are not stored in the database but can be populated for convenience
 	Owner *User `json:"owner,omitempty"`
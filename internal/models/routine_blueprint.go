@@ -1,23 +1,21 @@
 package models
 
-import "time"
-
-// RecurrenceType defines the possible recurrence types for a routine blueprint
-type RecurrenceType string
-
-const (
-	Daily   RecurrenceType = "Daily"
-	Weekly  RecurrenceType = "Weekly"
-	Weekday RecurrenceType = "Weekday"
-)
+import "github.com/bagvendt/chores/internal/schedule"
 
 type RoutineBlueprint struct {
-	ID                           int64          `json:"id"`
-	Created                      time.Time      `json:"created"`
-	Modified                     time.Time      `json:"modified"`
-	Name                         string         `json:"name"`
-	ToBeCompletedBy              string         `json:"to_be_completed_by"`
-	AllowMultipleInstancesPerDay bool           `json:"allow_multiple_instances_per_day"`
-	Recurrence                   RecurrenceType `json:"recurrence,omitempty"`
-	Image                        string         `json:"image,omitempty"`
+	ID       int64     `json:"id"`
+	Created  Timestamp `json:"created"`
+	Modified Timestamp `json:"modified"`
+	Name     string    `json:"name"`
+	// AvailableFrom is when the routine can be started on a due day. nil means
+	// from midnight
+	AvailableFrom *schedule.TimeOfDay `json:"available_from,omitempty"`
+	// ToBeCompletedBy is the deadline on a due day
+	ToBeCompletedBy schedule.TimeOfDay `json:"to_be_completed_by"`
+	// HideWhenOverdue hides unfinished routines after the deadline instead of
+	// showing them as overdue
+	HideWhenOverdue              bool              `json:"hide_when_overdue"`
+	AllowMultipleInstancesPerDay bool              `json:"allow_multiple_instances_per_day"`
+	Schedule                     schedule.Schedule `json:"schedule"`
+	Image                        string            `json:"image,omitempty"`
 }
@@ -1,11 +1,9 @@
 package models
 
-import "time"
-
 type RoutineBlueprintChore struct {
 	ID                 int64     `json:"id"`
-	Created            time.Time `json:"created"`
-	Modified           time.Time `json:"modified"`
+	Created            Timestamp `json:"created"`
+	Modified           Timestamp `json:"modified"`
 	RoutineBlueprintID int64     `json:"routine_blueprint_id"`
 	ChoreID            int64     `json:"chore_id"`
 	Image              string    `json:"image,omitempty"`
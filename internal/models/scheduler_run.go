@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bagvendt/chores/internal/schedule"
+)
+
+// SchedulerRun tells what a scheduler run did
+type SchedulerRun struct {
+	Day     schedule.Date // the household day the run was for
+	At      time.Time     // when the run finished
+	Started int           // routines started for the day
+	Missed  int64         // routines from earlier days marked missed
+}
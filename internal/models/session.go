@@ -0,0 +1,18 @@
+package models
+
+// Session is a logged in browser. Only the hash of the session token is stored.
+type Session struct {
+	ID        int64     `json:"id"`
+	TokenHash string    `json:"-"`
+	UserID    int64     `json:"user_id"`
+	Created   Timestamp `json:"created"`
+	LastSeen  Timestamp `json:"last_seen"`
+	Expires   Timestamp `json:"expires"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CSRFToken string    `json:"-"`
+
+	// These fields are not stored in the database but can be populated for convenience
+	User *User `json:"user,omitempty"`
+	// Extended is set when looking the session up pushed its expiry forward
+	Extended bool `json:"-"`
+}
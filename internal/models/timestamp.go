@@ -0,0 +1,69 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// timestampLayouts are the formats timestamps are found in. The app writes
+// RFC3339 in UTC, while SQL defaults (CURRENT_TIMESTAMP) write the second
+// layout and the driver writes time.Time arguments in the third
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05",
+}
+
+// Timestamp is a time stored as RFC3339 text in UTC. Scanning accepts every
+// layout found in the database and fails instead of yielding the zero time
+type Timestamp struct {
+	time.Time
+}
+
+// NewTimestamp wraps t in UTC, at the whole seconds timestamps are stored with
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Time: t.UTC().Truncate(time.Second)}
+}
+
+// ParseTimestamp parses s in any of the stored layouts and returns it in UTC
+func ParseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("models: invalid timestamp %q", s)
+}
+
+// Scan implements sql.Scanner. Use *Timestamp for nullable columns
+func (t *Timestamp) Scan(src any) error {
+	switch v := src.(type) {
+	case time.Time:
+		// The driver parses columns declared TIMESTAMP itself, and hands over
+		// the zero time without an error when it can't
+		if v.IsZero() {
+			return fmt.Errorf("models: invalid timestamp in a TIMESTAMP column")
+		}
+		t.Time = v.UTC()
+		return nil
+	case string:
+		parsed, err := ParseTimestamp(v)
+		t.Time = parsed
+		return err
+	case []byte:
+		parsed, err := ParseTimestamp(string(v))
+		t.Time = parsed
+		return err
+	case nil:
+		return fmt.Errorf("models: cannot scan NULL into Timestamp, use *Timestamp")
+	default:
+		return fmt.Errorf("models: cannot scan %T into Timestamp", src)
+	}
+}
+
+// Value implements driver.Valuer
+func (t Timestamp) Value() (driver.Value, error) {
+	return t.UTC().Format(time.RFC3339), nil
+}
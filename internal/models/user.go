@@ -1,11 +1,27 @@
 package models
 
-import "time"
+// Role defines whether a user is a parent or a child
+type Role string
+
+const (
+	RoleParent Role = "parent"
+	RoleChild  Role = "child"
+)
 
 type User struct {
-	ID        int64     `json:"id"`
-	Created   time.Time `json:"created"`
-	Modified  time.Time `json:"modified"`
-	Name      string    `json:"name"`
-	Password  string    `json:"-"` // Password is never serialized to JSON
+	ID       int64     `json:"id"`
+	Created  Timestamp `json:"created"`
+	Modified Timestamp `json:"modified"`
+	Name     string    `json:"name"`
+	Password string    `json:"-"` // Password is never serialized to JSON
+	IsAdmin  bool      `json:"is_admin"`
+	Role     Role      `json:"role"`
+	Active   bool      `json:"active"`
+	Avatar   string    `json:"avatar,omitempty"`
+	HasPIN   bool      `json:"has_pin"`
+}
+
+// IsParent returns true if the user can act on behalf of children
+func (u *User) IsParent() bool {
+	return u.Role == RoleParent
 } 
\ No newline at end of file
@@ -0,0 +1,122 @@
+package schedule
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// dateLayout is how dates are written in forms and the database
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day without a time of day or location. The zero Date
+// means no date and is stored as NULL
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// DateOf returns the day t falls on in its own location
+func DateOf(t time.Time) Date {
+	year, month, day := t.Date()
+	return Date{Year: year, Month: month, Day: day}
+}
+
+// ParseDate parses a YYYY-MM-DD date. The empty string is the zero Date
+func ParseDate(s string) (Date, error) {
+	if s == "" {
+		return Date{}, nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return Date{}, fmt.Errorf("schedule: invalid date %q", s)
+	}
+	return DateOf(t), nil
+}
+
+// IsZero reports whether d is the zero Date
+func (d Date) IsZero() bool {
+	return d == Date{}
+}
+
+// String returns d as YYYY-MM-DD, or the empty string for the zero Date
+func (d Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.time().Format(dateLayout)
+}
+
+// Weekday returns the day of the week d falls on
+func (d Date) Weekday() time.Weekday {
+	return d.time().Weekday()
+}
+
+// AddDays returns the date n days after d
+func (d Date) AddDays(n int) Date {
+	return DateOf(d.time().AddDate(0, 0, n))
+}
+
+// Before reports whether d is earlier than other
+func (d Date) Before(other Date) bool {
+	return d.time().Before(other.time())
+}
+
+// After reports whether d is later than other
+func (d Date) After(other Date) bool {
+	return d.time().After(other.time())
+}
+
+// time returns midnight UTC of d, which is free of DST so whole days can be
+// counted by dividing
+func (d Date) time() time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.UTC)
+}
+
+// daysSince returns the number of days from other to d
+func (d Date) daysSince(other Date) int {
+	return int(d.time().Sub(other.time()) / (24 * time.Hour))
+}
+
+// Scan implements sql.Scanner. NULL scans as the zero Date
+func (d *Date) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Date{}
+		return nil
+	case string:
+		parsed, err := ParseDate(v)
+		*d = parsed
+		return err
+	case []byte:
+		parsed, err := ParseDate(string(v))
+		*d = parsed
+		return err
+	case time.Time:
+		*d = DateOf(v)
+		return nil
+	default:
+		return fmt.Errorf("schedule: cannot scan %T into Date", src)
+	}
+}
+
+// Value implements driver.Valuer. The zero Date is stored as NULL
+func (d Date) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.String(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler, so JSON shows YYYY-MM-DD
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Date) UnmarshalText(text []byte) error {
+	parsed, err := ParseDate(string(text))
+	*d = parsed
+	return err
+}
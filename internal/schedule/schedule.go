@@ -0,0 +1,212 @@
+// Package schedule decides on which days a routine blueprint is due. A
+// Schedule is a small subset of an iCalendar RRULE: daily, weekly on chosen
+// weekdays or monthly on a day of the month, every N periods counted from a
+// start date, optionally until an end date
+package schedule
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the period a schedule repeats in
+type Frequency string
+
+const (
+	Daily   Frequency = "Daily"
+	Weekly  Frequency = "Weekly"
+	Monthly Frequency = "Monthly"
+)
+
+// Frequencies lists every frequency, in the order forms offer them
+var Frequencies = []Frequency{Daily, Weekly, Monthly}
+
+// Weekdays is a set of days of the week, with bit n set for time.Weekday(n)
+type Weekdays uint8
+
+// WorkDays is Monday to Friday
+const WorkDays Weekdays = 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday
+
+// WeekdaysOf returns the set of the given days
+func WeekdaysOf(days ...time.Weekday) Weekdays {
+	var set Weekdays
+	for _, day := range days {
+		set |= 1 << day
+	}
+	return set
+}
+
+// Has reports whether day is in the set
+func (w Weekdays) Has(day time.Weekday) bool {
+	return w&(1<<day) != 0
+}
+
+// String lists the days Monday first, like "Mon, Wed, Fri"
+func (w Weekdays) String() string {
+	var names []string
+	for _, day := range mondayFirst {
+		if w.Has(day) {
+			names = append(names, day.String()[:3])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// mondayFirst is the week as it is shown and counted, starting on Monday
+var mondayFirst = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// MondayFirst returns the days of the week starting on Monday
+func MondayFirst() []time.Weekday {
+	return mondayFirst
+}
+
+// Schedule says on which days something is due
+type Schedule struct {
+	// Frequency is the period the schedule repeats in. A schedule without
+	// one is never due
+	Frequency Frequency `json:"frequency,omitempty"`
+	// Interval repeats the schedule every Interval periods. 0 and 1 both mean
+	// every period
+	Interval int `json:"interval,omitempty"`
+	// Weekdays are the days a weekly schedule is due. When empty it is due on
+	// the weekday of Start
+	Weekdays Weekdays `json:"weekdays,omitempty"`
+	// MonthDay is the day of the month a monthly schedule is due. Months
+	// shorter than that use their last day. When 0 the day of Start is used
+	MonthDay int `json:"month_day,omitempty"`
+	// Start is the first day the schedule can be due and the day intervals are
+	// counted from. Weeks are counted from the Monday of its week
+	Start Date `json:"start,omitzero"`
+	// End is the last day the schedule can be due. The zero Date means never
+	End Date `json:"end,omitzero"`
+}
+
+// Validation errors returned by Validate
+var (
+	ErrUnknownFrequency = errors.New("unknown frequency")
+	ErrInvalidInterval  = errors.New("interval must be at least 1")
+	ErrInvalidMonthDay  = errors.New("day of the month must be between 1 and 31")
+	ErrStartRequired    = errors.New("a start date is required")
+	ErrEndBeforeStart   = errors.New("the end date is before the start date")
+)
+
+// Validate checks that s can be evaluated. The empty schedule is valid and
+// never due
+func (s Schedule) Validate() error {
+	switch s.Frequency {
+	case "":
+		return nil
+	case Daily, Weekly, Monthly:
+	default:
+		return ErrUnknownFrequency
+	}
+	if s.Interval < 0 {
+		return ErrInvalidInterval
+	}
+	if s.MonthDay < 0 || s.MonthDay > 31 {
+		return ErrInvalidMonthDay
+	}
+	if s.Start.IsZero() {
+		return ErrStartRequired
+	}
+	if !s.End.IsZero() && s.End.Before(s.Start) {
+		return ErrEndBeforeStart
+	}
+	return nil
+}
+
+// IsDue reports whether the schedule is due on day. Schedules that don't
+// Validate give no guarantees
+func (s Schedule) IsDue(day Date) bool {
+	if s.Frequency == "" {
+		return false
+	}
+	if day.Before(s.Start) {
+		return false
+	}
+	if !s.End.IsZero() && day.After(s.End) {
+		return false
+	}
+
+	interval := max(s.Interval, 1)
+
+	switch s.Frequency {
+	case Daily:
+		return day.daysSince(s.Start)%interval == 0
+
+	case Weekly:
+		weekdays := s.Weekdays
+		if weekdays == 0 {
+			weekdays = WeekdaysOf(s.Start.Weekday())
+		}
+		if !weekdays.Has(day.Weekday()) {
+			return false
+		}
+		weeks := day.daysSince(startOfWeek(s.Start)) / 7
+		return weeks%interval == 0
+
+	case Monthly:
+		months := (day.Year-s.Start.Year)*12 + int(day.Month-s.Start.Month)
+		if months%interval != 0 {
+			return false
+		}
+		monthDay := s.MonthDay
+		if monthDay == 0 {
+			monthDay = s.Start.Day
+		}
+		return day.Day == min(monthDay, daysIn(day.Year, day.Month))
+	}
+
+	return false
+}
+
+// Describe returns a short human description like "Every 2 weeks on Mon, Thu"
+func (s Schedule) Describe() string {
+	if s.Frequency == "" {
+		return "Never"
+	}
+
+	interval := max(s.Interval, 1)
+	var b strings.Builder
+	switch s.Frequency {
+	case Daily:
+		b.WriteString(every(interval, "day", "Daily"))
+	case Weekly:
+		b.WriteString(every(interval, "week", "Weekly"))
+		if s.Weekdays != 0 {
+			b.WriteString(" on " + s.Weekdays.String())
+		}
+	case Monthly:
+		b.WriteString(every(interval, "month", "Monthly"))
+		if s.MonthDay != 0 {
+			b.WriteString(" on day " + strconv.Itoa(s.MonthDay))
+		}
+	default:
+		b.WriteString(string(s.Frequency))
+	}
+	if !s.End.IsZero() {
+		b.WriteString(" until " + s.End.String())
+	}
+	return b.String()
+}
+
+func every(interval int, unit, once string) string {
+	if interval == 1 {
+		return once
+	}
+	return "Every " + strconv.Itoa(interval) + " " + unit + "s"
+}
+
+// startOfWeek returns the Monday of the week d is in
+func startOfWeek(d Date) Date {
+	return d.AddDays(-((int(d.Weekday()) + 6) % 7))
+}
+
+// daysIn returns the number of days in the month
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
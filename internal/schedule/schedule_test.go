@@ -0,0 +1,216 @@
+package schedule
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// date parses a YYYY-MM-DD date or fails the test
+func date(t *testing.T, s string) Date {
+	t.Helper()
+	d, err := ParseDate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestIsDue(t *testing.T) {
+	// 2024-01-01 is a Monday, and 2024 is a leap year
+	tests := []struct {
+		name     string
+		schedule Schedule
+		day      string
+		want     bool
+	}{
+		// Empty and bounds
+		{"no frequency", Schedule{Start: Date{2024, 1, 1}}, "2024-01-01", false},
+		{"daily on start", Schedule{Frequency: Daily, Start: Date{2024, 1, 1}}, "2024-01-01", true},
+		{"daily before start", Schedule{Frequency: Daily, Start: Date{2024, 1, 1}}, "2023-12-31", false},
+		{"daily long after start", Schedule{Frequency: Daily, Start: Date{2024, 1, 1}}, "2031-07-19", true},
+		{"daily on end", Schedule{Frequency: Daily, Start: Date{2024, 1, 1}, End: Date{2024, 1, 31}}, "2024-01-31", true},
+		{"daily after end", Schedule{Frequency: Daily, Start: Date{2024, 1, 1}, End: Date{2024, 1, 31}}, "2024-02-01", false},
+		{"one day range", Schedule{Frequency: Daily, Start: Date{2024, 5, 5}, End: Date{2024, 5, 5}}, "2024-05-05", true},
+
+		// Daily intervals
+		{"every 2 days on start", Schedule{Frequency: Daily, Interval: 2, Start: Date{2024, 1, 1}}, "2024-01-01", true},
+		{"every 2 days off day", Schedule{Frequency: Daily, Interval: 2, Start: Date{2024, 1, 1}}, "2024-01-02", false},
+		{"every 2 days next", Schedule{Frequency: Daily, Interval: 2, Start: Date{2024, 1, 1}}, "2024-01-03", true},
+		{"every 2 days across leap day", Schedule{Frequency: Daily, Interval: 2, Start: Date{2024, 2, 27}}, "2024-03-02", true},
+		{"every 3 days across new year", Schedule{Frequency: Daily, Interval: 3, Start: Date{2023, 12, 30}}, "2024-01-02", true},
+		{"every 3 days across new year off", Schedule{Frequency: Daily, Interval: 3, Start: Date{2023, 12, 30}}, "2024-01-03", false},
+		{"interval 0 means every day", Schedule{Frequency: Daily, Interval: 0, Start: Date{2024, 1, 1}}, "2024-01-02", true},
+		{"every 2 days across DST start", Schedule{Frequency: Daily, Interval: 2, Start: Date{2024, 3, 29}}, "2024-04-02", true},
+
+		// Weekly on chosen days
+		{"weekdays on monday", Schedule{Frequency: Weekly, Weekdays: WorkDays, Start: Date{2024, 1, 1}}, "2024-01-08", true},
+		{"weekdays on friday", Schedule{Frequency: Weekly, Weekdays: WorkDays, Start: Date{2024, 1, 1}}, "2024-01-12", true},
+		{"weekdays on saturday", Schedule{Frequency: Weekly, Weekdays: WorkDays, Start: Date{2024, 1, 1}}, "2024-01-13", false},
+		{"weekdays on sunday", Schedule{Frequency: Weekly, Weekdays: WorkDays, Start: Date{2024, 1, 1}}, "2024-01-14", false},
+		{"sunday only", Schedule{Frequency: Weekly, Weekdays: WeekdaysOf(time.Sunday), Start: Date{2024, 1, 1}}, "2024-01-07", true},
+		{"chosen day before start", Schedule{Frequency: Weekly, Weekdays: WorkDays, Start: Date{2024, 1, 3}}, "2024-01-02", false},
+		{"no days uses start weekday", Schedule{Frequency: Weekly, Start: Date{2024, 1, 3}}, "2024-01-10", true},
+		{"no days other weekday", Schedule{Frequency: Weekly, Start: Date{2024, 1, 3}}, "2024-01-11", false},
+
+		// Every N weeks, counted in Monday to Sunday weeks from the start's week
+		{"every 2 weeks start week", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Monday, time.Thursday), Start: Date{2024, 1, 1}}, "2024-01-04", true},
+		{"every 2 weeks off week", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Monday, time.Thursday), Start: Date{2024, 1, 1}}, "2024-01-08", false},
+		{"every 2 weeks on week", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Monday, time.Thursday), Start: Date{2024, 1, 1}}, "2024-01-15", true},
+		{"start midweek counts its monday", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Monday, time.Friday), Start: Date{2024, 1, 5}}, "2024-01-15", true},
+		{"start midweek off week", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Monday, time.Friday), Start: Date{2024, 1, 5}}, "2024-01-08", false},
+		{"sunday ends the start week", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Sunday), Start: Date{2024, 1, 3}}, "2024-01-07", true},
+		{"sunday of the off week", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Sunday), Start: Date{2024, 1, 3}}, "2024-01-14", false},
+		{"every 3 weeks across new year", Schedule{Frequency: Weekly, Interval: 3, Weekdays: WeekdaysOf(time.Wednesday), Start: Date{2023, 12, 13}}, "2024-01-03", true},
+		{"every 3 weeks across new year off", Schedule{Frequency: Weekly, Interval: 3, Weekdays: WeekdaysOf(time.Wednesday), Start: Date{2023, 12, 13}}, "2023-12-27", false},
+		{"every 2 weeks across DST end", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Monday), Start: Date{2024, 10, 21}}, "2024-11-04", true},
+
+		// Monthly by day
+		{"monthly on the day", Schedule{Frequency: Monthly, MonthDay: 15, Start: Date{2024, 1, 1}}, "2024-03-15", true},
+		{"monthly other day", Schedule{Frequency: Monthly, MonthDay: 15, Start: Date{2024, 1, 1}}, "2024-03-16", false},
+		{"monthly day before start", Schedule{Frequency: Monthly, MonthDay: 15, Start: Date{2024, 1, 20}}, "2024-01-15", false},
+		{"monthly no day uses start day", Schedule{Frequency: Monthly, Start: Date{2024, 1, 20}}, "2024-02-20", true},
+		{"day 31 in april is the 30th", Schedule{Frequency: Monthly, MonthDay: 31, Start: Date{2024, 1, 1}}, "2024-04-30", true},
+		{"day 31 in january", Schedule{Frequency: Monthly, MonthDay: 31, Start: Date{2024, 1, 1}}, "2024-01-31", true},
+		{"day 31 not on the 30th of january", Schedule{Frequency: Monthly, MonthDay: 31, Start: Date{2024, 1, 1}}, "2024-01-30", false},
+		{"day 30 in leap february", Schedule{Frequency: Monthly, MonthDay: 30, Start: Date{2024, 1, 1}}, "2024-02-29", true},
+		{"day 30 in february", Schedule{Frequency: Monthly, MonthDay: 30, Start: Date{2023, 1, 1}}, "2023-02-28", true},
+		{"day 29 not on the 28th of leap february", Schedule{Frequency: Monthly, MonthDay: 29, Start: Date{2024, 1, 1}}, "2024-02-28", false},
+		{"every 3 months on", Schedule{Frequency: Monthly, Interval: 3, MonthDay: 1, Start: Date{2024, 1, 1}}, "2024-04-01", true},
+		{"every 3 months off", Schedule{Frequency: Monthly, Interval: 3, MonthDay: 1, Start: Date{2024, 1, 1}}, "2024-03-01", false},
+		{"every 3 months across new year", Schedule{Frequency: Monthly, Interval: 3, MonthDay: 1, Start: Date{2024, 11, 1}}, "2025-02-01", true},
+		{"every 2 months after end", Schedule{Frequency: Monthly, Interval: 2, MonthDay: 1, Start: Date{2024, 1, 1}, End: Date{2024, 4, 30}}, "2024-05-01", false},
+
+		// Unknown frequencies are never due
+		{"unknown frequency", Schedule{Frequency: "Yearly", Start: Date{2024, 1, 1}}, "2024-01-01", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.IsDue(date(t, tt.day)); got != tt.want {
+				t.Errorf("IsDue(%s) = %v, want %v for %+v", tt.day, got, tt.want, tt.schedule)
+			}
+		})
+	}
+}
+
+func TestIsDueCountsAcrossAYear(t *testing.T) {
+	// Counting the due days of a whole year catches off-by-ones the single
+	// cases above might miss
+	tests := []struct {
+		name     string
+		schedule Schedule
+		want     int
+	}{
+		{"daily", Schedule{Frequency: Daily, Start: Date{2024, 1, 1}}, 366},
+		{"every other day", Schedule{Frequency: Daily, Interval: 2, Start: Date{2024, 1, 1}}, 183},
+		{"work days", Schedule{Frequency: Weekly, Weekdays: WorkDays, Start: Date{2024, 1, 1}}, 262},
+		{"every other saturday", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Saturday), Start: Date{2024, 1, 1}}, 26},
+		{"monthly on the 31st", Schedule{Frequency: Monthly, MonthDay: 31, Start: Date{2024, 1, 1}}, 12},
+		{"quarterly", Schedule{Frequency: Monthly, Interval: 3, MonthDay: 1, Start: Date{2024, 1, 1}}, 4},
+		{"june only", Schedule{Frequency: Daily, Start: Date{2024, 6, 1}, End: Date{2024, 6, 30}}, 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count := 0
+			for day := (Date{2024, 1, 1}); day.Year == 2024; day = day.AddDays(1) {
+				if tt.schedule.IsDue(day) {
+					count++
+				}
+			}
+			if count != tt.want {
+				t.Errorf("Expected %d due days in 2024, got %d", tt.want, count)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	start := Date{2024, 1, 1}
+	tests := []struct {
+		name     string
+		schedule Schedule
+		want     error
+	}{
+		{"empty", Schedule{}, nil},
+		{"daily", Schedule{Frequency: Daily, Start: start}, nil},
+		{"weekly with days", Schedule{Frequency: Weekly, Interval: 2, Weekdays: WorkDays, Start: start}, nil},
+		{"monthly with end", Schedule{Frequency: Monthly, MonthDay: 31, Start: start, End: Date{2024, 12, 31}}, nil},
+		{"unknown frequency", Schedule{Frequency: "Weekday", Start: start}, ErrUnknownFrequency},
+		{"negative interval", Schedule{Frequency: Daily, Interval: -1, Start: start}, ErrInvalidInterval},
+		{"month day too large", Schedule{Frequency: Monthly, MonthDay: 32, Start: start}, ErrInvalidMonthDay},
+		{"negative month day", Schedule{Frequency: Monthly, MonthDay: -1, Start: start}, ErrInvalidMonthDay},
+		{"no start", Schedule{Frequency: Daily}, ErrStartRequired},
+		{"end before start", Schedule{Frequency: Daily, Start: start, End: Date{2023, 12, 31}}, ErrEndBeforeStart},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Validate(); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		schedule Schedule
+		want     string
+	}{
+		{Schedule{}, "Never"},
+		{Schedule{Frequency: Daily}, "Daily"},
+		{Schedule{Frequency: Daily, Interval: 3}, "Every 3 days"},
+		{Schedule{Frequency: Weekly, Weekdays: WorkDays}, "Weekly on Mon, Tue, Wed, Thu, Fri"},
+		{Schedule{Frequency: Weekly, Interval: 2, Weekdays: WeekdaysOf(time.Sunday, time.Monday)}, "Every 2 weeks on Mon, Sun"},
+		{Schedule{Frequency: Monthly, MonthDay: 15, End: Date{2024, 6, 30}}, "Monthly on day 15 until 2024-06-30"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.schedule.Describe(); got != tt.want {
+			t.Errorf("Expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestDate(t *testing.T) {
+	if d := date(t, "2024-02-29"); d != (Date{2024, 2, 29}) || d.String() != "2024-02-29" {
+		t.Errorf("Expected 2024-02-29 to round trip, got %+v", d)
+	}
+	if d := date(t, ""); !d.IsZero() || d.String() != "" {
+		t.Errorf("Expected the empty string to be the zero date, got %+v", d)
+	}
+	for _, bad := range []string{"2023-02-29", "29-02-2024", "tomorrow"} {
+		if _, err := ParseDate(bad); err == nil {
+			t.Errorf("Expected an error parsing %q", bad)
+		}
+	}
+
+	// The day is taken in the time's own location
+	late := time.Date(2024, 3, 31, 23, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	if d := DateOf(late); d != (Date{2024, 3, 31}) {
+		t.Errorf("Expected 2024-03-31, got %v", d)
+	}
+
+	var scanned Date
+	for _, src := range []any{"2024-05-17", []byte("2024-05-17"), time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)} {
+		if err := scanned.Scan(src); err != nil || scanned != (Date{2024, 5, 17}) {
+			t.Errorf("Expected %v to scan as 2024-05-17, got %v (%v)", src, scanned, err)
+		}
+	}
+	if err := scanned.Scan(nil); err != nil || !scanned.IsZero() {
+		t.Errorf("Expected NULL to scan as the zero date, got %v (%v)", scanned, err)
+	}
+	if value, _ := (Date{}).Value(); value != nil {
+		t.Errorf("Expected the zero date to be stored as NULL, got %v", value)
+	}
+
+	out, err := json.Marshal(Schedule{Frequency: Daily, Start: Date{2024, 1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"frequency":"Daily","start":"2024-01-01"}` {
+		t.Errorf("Unexpected JSON %s", out)
+	}
+}
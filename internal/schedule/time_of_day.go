@@ -0,0 +1,97 @@
+package schedule
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidTimeOfDay is returned for times that aren't a valid HH:MM
+var ErrInvalidTimeOfDay = errors.New("time must be between 00:00 and 23:59")
+
+// TimeOfDay is a wall clock time, counted in minutes after midnight. It is
+// stored as HH:MM
+type TimeOfDay int
+
+// EndOfDay is the last minute of the day
+const EndOfDay TimeOfDay = 23*60 + 59
+
+// NewTimeOfDay returns the time hour:minute
+func NewTimeOfDay(hour, minute int) TimeOfDay {
+	return TimeOfDay(hour*60 + minute)
+}
+
+// TimeOfDayOf returns the wall clock time of t in its own location
+func TimeOfDayOf(t time.Time) TimeOfDay {
+	return NewTimeOfDay(t.Hour(), t.Minute())
+}
+
+// ParseTimeOfDay parses HH:MM, as sent by time inputs, or HH:MM:SS, whose
+// seconds are dropped
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return TimeOfDayOf(t), nil
+		}
+	}
+	return 0, fmt.Errorf("%w, got %q", ErrInvalidTimeOfDay, s)
+}
+
+// Hour returns the hour, 0 to 23
+func (t TimeOfDay) Hour() int {
+	return int(t) / 60
+}
+
+// Minute returns the minute within the hour, 0 to 59
+func (t TimeOfDay) Minute() int {
+	return int(t) % 60
+}
+
+// Valid reports whether t is within a day
+func (t TimeOfDay) Valid() bool {
+	return t >= 0 && t <= EndOfDay
+}
+
+// String returns t as HH:MM
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute())
+}
+
+// Scan implements sql.Scanner. Use *TimeOfDay for nullable columns
+func (t *TimeOfDay) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+		return fmt.Errorf("schedule: cannot scan NULL into TimeOfDay, use *TimeOfDay")
+	default:
+		return fmt.Errorf("schedule: cannot scan %T into TimeOfDay", src)
+	}
+	parsed, err := ParseTimeOfDay(s)
+	*t = parsed
+	return err
+}
+
+// Value implements driver.Valuer
+func (t TimeOfDay) Value() (driver.Value, error) {
+	if !t.Valid() {
+		return nil, ErrInvalidTimeOfDay
+	}
+	return t.String(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler, so JSON shows HH:MM
+func (t TimeOfDay) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *TimeOfDay) UnmarshalText(text []byte) error {
+	parsed, err := ParseTimeOfDay(string(text))
+	*t = parsed
+	return err
+}
@@ -0,0 +1,72 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		in   string
+		want TimeOfDay
+		err  bool
+	}{
+		{"00:00", 0, false},
+		{"08:00", NewTimeOfDay(8, 0), false},
+		{"08:00:59", NewTimeOfDay(8, 0), false},
+		{"23:59", EndOfDay, false},
+		{"24:00", 0, true},
+		{"12:60", 0, true},
+		{"8:05", NewTimeOfDay(8, 5), false},
+		{"morning", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimeOfDay(tt.in)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidTimeOfDay) {
+				t.Errorf("Expected ErrInvalidTimeOfDay for %q, got %v", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Expected %q to parse as %v, got %v (%v)", tt.in, tt.want, got, err)
+		}
+	}
+}
+
+func TestTimeOfDay(t *testing.T) {
+	half := NewTimeOfDay(7, 30)
+	if half.Hour() != 7 || half.Minute() != 30 || half.String() != "07:30" {
+		t.Errorf("Expected 07:30, got %d:%d (%s)", half.Hour(), half.Minute(), half)
+	}
+	if !(NewTimeOfDay(7, 59) < NewTimeOfDay(8, 0)) {
+		t.Errorf("Expected times to order by the clock")
+	}
+
+	// The time is read in the time's own location, to the minute
+	evening := time.Date(2024, 3, 31, 21, 45, 59, 0, time.FixedZone("CEST", 2*60*60))
+	if got := TimeOfDayOf(evening); got != NewTimeOfDay(21, 45) {
+		t.Errorf("Expected 21:45, got %v", got)
+	}
+
+	var scanned TimeOfDay
+	if err := scanned.Scan("17:00:00"); err != nil || scanned != NewTimeOfDay(17, 0) {
+		t.Errorf("Expected 17:00, got %v (%v)", scanned, err)
+	}
+	if err := scanned.Scan(nil); err == nil {
+		t.Errorf("Expected an error scanning NULL into TimeOfDay")
+	}
+	if err := scanned.Scan("bedtime"); err == nil {
+		t.Errorf("Expected an error scanning an unparseable time")
+	}
+
+	if value, err := NewTimeOfDay(6, 5).Value(); err != nil || value != "06:05" {
+		t.Errorf("Expected 06:05 to be stored, got %v (%v)", value, err)
+	}
+	if _, err := TimeOfDay(24 * 60).Value(); err == nil {
+		t.Errorf("Expected an error storing a time past the end of the day")
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bagvendt/chores/internal/models"
+)
+
+// APITokenPrefix marks API tokens so they are easy to recognise in scripts and
+// secret scanners
+const APITokenPrefix = "chores_"
+
+// apiTokenTouchInterval limits how often last_used is written back
+const apiTokenTouchInterval = time.Minute
+
+// ErrAPITokenNameRequired is returned when minting a token without a name
+var ErrAPITokenNameRequired = errors.New("API token name is required")
+
+// CreateAPIToken mints a new API token for a user. The returned token is only
+// available now, since only its hash is stored.
+func (s *AuthenticationService) CreateAPIToken(ctx context.Context, userID int64, name string) (*models.APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrAPITokenNameRequired
+	}
+
+	random, err := GenerateSessionToken()
+	if err != nil {
+		return nil, "", err
+	}
+	token := APITokenPrefix + random
+
+	apiToken := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(token),
+	}
+	if err := s.apiTokens.Create(ctx, apiToken); err != nil {
+		return nil, "", err
+	}
+
+	return apiToken, token, nil
+}
+
+// AuthenticateAPIToken returns the user of a valid, unrevoked API token and
+// records that the token was used
+func (s *AuthenticationService) AuthenticateAPIToken(ctx context.Context, token string) (*models.User, bool) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return nil, false
+	}
+
+	apiToken, err := s.apiTokens.GetByHash(ctx, hashToken(token))
+	if err != nil {
+		log.Printf("Error looking up API token: %v", err)
+		return nil, false
+	}
+	if apiToken == nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	if apiToken.LastUsed == nil || now.Sub(apiToken.LastUsed.Time) >= apiTokenTouchInterval {
+		if err := s.apiTokens.Touch(ctx, apiToken.ID, now); err != nil {
+			// The token is still valid, we just don't know it was used
+			log.Printf("Error recording use of API token %d: %v", apiToken.ID, err)
+		}
+	}
+
+	return apiToken.User, true
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bagvendt/chores/internal/database"
+	"github.com/bagvendt/chores/internal/models"
+)
+
+var (
+	ErrForbidden       = errors.New("forbidden")
+	ErrRoutineNotFound = errors.New("routine not found")
+)
+
+// AuthorizationService decides who may see and change whose routines.
+// A routine is open to its owner, the owner's parents and admins.
+type AuthorizationService struct {
+	routines database.RoutineRepository
+	users    database.UserRepository
+}
+
+// NewAuthorizationService creates a new instance of AuthorizationService
+func NewAuthorizationService(routines database.RoutineRepository, users database.UserRepository) *AuthorizationService {
+	return &AuthorizationService{
+		routines: routines,
+		users:    users,
+	}
+}
+
+// CanActFor reports whether user may view and change routines owned by ownerID
+func (s *AuthorizationService) CanActFor(ctx context.Context, user *models.User, ownerID int64) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	if user.ID == ownerID || user.IsAdmin {
+		return true, nil
+	}
+	return s.users.IsParentOf(ctx, user.ID, ownerID)
+}
+
+// AuthorizeRoutine loads a routine the user may view and change. It returns
+// ErrRoutineNotFound if there is no such routine and ErrForbidden if the user
+// isn't allowed to touch it.
+func (s *AuthorizationService) AuthorizeRoutine(ctx context.Context, user *models.User, routineID int64) (*models.Routine, error) {
+	routine, err := s.routines.Get(ctx, routineID)
+	if err != nil {
+		return nil, err
+	}
+	if routine == nil {
+		return nil, ErrRoutineNotFound
+	}
+
+	allowed, err := s.CanActFor(ctx, user, routine.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+
+	return routine, nil
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/bagvendt/chores/internal/database"
+	"github.com/bagvendt/chores/internal/models"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// setupAuthorizationTestDB creates a family of a parent (1) with two children
+// (2 and 3), an unrelated parent (4) and an admin (5). Each child owns a routine
+// with the same ID as the child.
+func setupAuthorizationTestDB(t *testing.T) *database.Store {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'child',
+			active BOOLEAN NOT NULL DEFAULT 1
+		);
+		CREATE TABLE user_children (
+			id INTEGER PRIMARY KEY,
+			parent_id INTEGER NOT NULL,
+			child_id INTEGER NOT NULL
+		);
+		CREATE TABLE routine_blueprints (
+			id INTEGER PRIMARY KEY,
+			image TEXT NOT NULL
+		);
+		CREATE TABLE routines (
+			id INTEGER PRIMARY KEY,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			owner_id INTEGER NOT NULL,
+			routine_blueprint_id INTEGER,
+			day TEXT,
+			missed BOOLEAN NOT NULL DEFAULT 0
+		);
+		INSERT INTO users (id, name, role) VALUES (1, 'mor', 'parent'), (2, 'poul', 'child'), (3, 'ulla', 'child'), (4, 'nabo', 'parent'), (5, 'admin', 'parent');
+		INSERT INTO user_children (parent_id, child_id) VALUES (1, 2), (1, 3);
+		INSERT INTO routines (id, owner_id) VALUES (2, 2), (3, 3);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to set up tables: %v", err)
+	}
+
+	return database.NewStore(db, db)
+}
+
+func TestAuthorizeRoutine(t *testing.T) {
+	store := setupAuthorizationTestDB(t)
+	auth := NewAuthorizationService(store.Routines, store.Users)
+
+	parent := &models.User{ID: 1, Role: models.RoleParent}
+	poul := &models.User{ID: 2, Role: models.RoleChild}
+	ulla := &models.User{ID: 3, Role: models.RoleChild}
+	neighbour := &models.User{ID: 4, Role: models.RoleParent}
+	admin := &models.User{ID: 5, Role: models.RoleParent, IsAdmin: true}
+
+	tests := []struct {
+		name      string
+		user      *models.User
+		routineID int64
+		expected  error
+	}{
+		{"owner", poul, 2, nil},
+		{"sibling", ulla, 2, ErrForbidden},
+		{"parent of owner", parent, 3, nil},
+		{"unrelated parent", neighbour, 2, ErrForbidden},
+		{"admin", admin, 3, nil},
+		{"anonymous", nil, 2, ErrForbidden},
+		{"missing routine", poul, 99, ErrRoutineNotFound},
+		{"missing routine for admin", admin, 99, ErrRoutineNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routine, err := auth.AuthorizeRoutine(t.Context(), tt.user, tt.routineID)
+			if err != tt.expected {
+				t.Fatalf("Expected error %v, got %v", tt.expected, err)
+			}
+			if err == nil && routine.ID != tt.routineID {
+				t.Errorf("Expected routine %d, got %d", tt.routineID, routine.ID)
+			}
+			if err != nil && routine != nil {
+				t.Errorf("Expected no routine on error, got %+v", routine)
+			}
+		})
+	}
+}
+
+func TestCanActFor(t *testing.T) {
+	store := setupAuthorizationTestDB(t)
+	auth := NewAuthorizationService(store.Routines, store.Users)
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		ownerID  int64
+		expected bool
+	}{
+		{"self", &models.User{ID: 2}, 2, true},
+		{"sibling", &models.User{ID: 2}, 3, false},
+		{"child for parent", &models.User{ID: 2}, 1, false},
+		{"parent for child", &models.User{ID: 1}, 2, true},
+		{"unrelated parent", &models.User{ID: 4}, 2, false},
+		{"admin", &models.User{ID: 5, IsAdmin: true}, 2, true},
+		{"anonymous", nil, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := auth.CanActFor(t.Context(), tt.user, tt.ownerID)
+			if err != nil {
+				t.Fatalf("Failed to check access: %v", err)
+			}
+			if allowed != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, allowed)
+			}
+		})
+	}
+}
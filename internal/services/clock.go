@@ -0,0 +1,15 @@
+package services
+
+import "time"
+
+// Clock tells the time. It lets tests control time instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the real wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
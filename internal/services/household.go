@@ -0,0 +1,26 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// HouseholdTimezoneEnv names the environment variable with the household's
+// time zone, like "Europe/Copenhagen". Days start at midnight there, and
+// deadlines are read on its clocks
+const HouseholdTimezoneEnv = "HOUSEHOLD_TIMEZONE"
+
+// HouseholdLocation returns the time zone from HOUSEHOLD_TIMEZONE, or the
+// server's local time zone when it is unset
+func HouseholdLocation() (*time.Location, error) {
+	name := os.Getenv(HouseholdTimezoneEnv)
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", HouseholdTimezoneEnv, name, err)
+	}
+	return loc, nil
+}
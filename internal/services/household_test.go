@@ -0,0 +1,23 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHouseholdLocation(t *testing.T) {
+	t.Setenv(HouseholdTimezoneEnv, "")
+	if loc, err := HouseholdLocation(); err != nil || loc != time.Local {
+		t.Errorf("Expected the local time zone when unset, got %v (%v)", loc, err)
+	}
+
+	t.Setenv(HouseholdTimezoneEnv, "Europe/Copenhagen")
+	if loc, err := HouseholdLocation(); err != nil || loc.String() != "Europe/Copenhagen" {
+		t.Errorf("Expected Europe/Copenhagen, got %v (%v)", loc, err)
+	}
+
+	t.Setenv(HouseholdTimezoneEnv, "Europe/Atlantis")
+	if _, err := HouseholdLocation(); err == nil {
+		t.Errorf("Expected an error for an unknown time zone")
+	}
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig controls how LoginRateLimiter backs off
+type RateLimitConfig struct {
+	// FreeAttempts is how many failures are allowed before backoff starts
+	FreeAttempts int
+	// BaseDelay is the lockout after the first failure past FreeAttempts. It
+	// doubles with every further failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the lockout
+	MaxDelay time.Duration
+	// ResetAfter forgets failures when there hasn't been one for this long
+	ResetAfter time.Duration
+}
+
+// DefaultRateLimitConfig allows a handful of typos and then backs off from
+// 30 seconds up to 15 minutes
+var DefaultRateLimitConfig = RateLimitConfig{
+	FreeAttempts: 5,
+	BaseDelay:    30 * time.Second,
+	MaxDelay:     15 * time.Minute,
+	ResetAfter:   time.Hour,
+}
+
+const (
+	// LoginMaxAttemptsEnv names the environment variable with how many failed
+	// logins are free before backoff starts
+	LoginMaxAttemptsEnv = "LOGIN_MAX_ATTEMPTS"
+	// LoginBackoffEnv names the environment variable with the first lockout,
+	// like "30s"
+	LoginBackoffEnv = "LOGIN_BACKOFF"
+	// LoginMaxBackoffEnv names the environment variable with the longest
+	// lockout, like "15m"
+	LoginMaxBackoffEnv = "LOGIN_MAX_BACKOFF"
+)
+
+// LoginRateLimitConfig returns DefaultRateLimitConfig with the attempts and
+// backoff from LOGIN_MAX_ATTEMPTS, LOGIN_BACKOFF and LOGIN_MAX_BACKOFF
+func LoginRateLimitConfig() (RateLimitConfig, error) {
+	config := DefaultRateLimitConfig
+
+	if value := os.Getenv(LoginMaxAttemptsEnv); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 0 {
+			return RateLimitConfig{}, fmt.Errorf("invalid %s %q, expected a number", LoginMaxAttemptsEnv, value)
+		}
+		config.FreeAttempts = attempts
+	}
+
+	for _, setting := range []struct {
+		env   string
+		delay *time.Duration
+	}{
+		{LoginBackoffEnv, &config.BaseDelay},
+		{LoginMaxBackoffEnv, &config.MaxDelay},
+	} {
+		value := os.Getenv(setting.env)
+		if value == "" {
+			continue
+		}
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay <= 0 {
+			return RateLimitConfig{}, fmt.Errorf("invalid %s %q, expected a duration like 30s", setting.env, value)
+		}
+		*setting.delay = delay
+	}
+
+	if config.MaxDelay < config.BaseDelay {
+		return RateLimitConfig{}, fmt.Errorf("%s %v is shorter than %s %v", LoginMaxBackoffEnv, config.MaxDelay, LoginBackoffEnv, config.BaseDelay)
+	}
+
+	return config, nil
+}
+
+// rateLimitSweepSize is how many tracked keys trigger a sweep of stale entries
+const rateLimitSweepSize = 1024
+
+type rateLimitEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginRateLimiter tracks failed logins per IP address and per username and
+// locks both out with exponential backoff. It is safe for concurrent use.
+type LoginRateLimiter struct {
+	mu      sync.Mutex
+	config  RateLimitConfig
+	clock   Clock
+	entries map[string]*rateLimitEntry
+}
+
+// NewLoginRateLimiter creates a rate limiter using the given config and clock
+func NewLoginRateLimiter(config RateLimitConfig, clock Clock) *LoginRateLimiter {
+	return &LoginRateLimiter{
+		config:  config,
+		clock:   clock,
+		entries: make(map[string]*rateLimitEntry),
+	}
+}
+
+// rateLimitKeys returns the keys a password login is tracked under
+func rateLimitKeys(ip, username string) []string {
+	return []string{
+		"ip:" + ip,
+		userRateLimitKey(username),
+	}
+}
+
+// userRateLimitKey returns the key a user's failed logins are tracked under
+func userRateLimitKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// Allow reports whether a login for username from ip may be attempted now.
+// If not, it returns how long the caller has to wait.
+func (l *LoginRateLimiter) Allow(ip, username string) (time.Duration, bool) {
+	return l.allow(rateLimitKeys(ip, username))
+}
+
+// AllowPIN reports whether a PIN login for username may be attempted now.
+// PIN logins only count against the user, since the children of a household
+// usually share one tablet and one address.
+func (l *LoginRateLimiter) AllowPIN(username string) (time.Duration, bool) {
+	return l.allow([]string{userRateLimitKey(username)})
+}
+
+func (l *LoginRateLimiter) allow(keys []string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		entry, exists := l.entries[key]
+		if !exists {
+			continue
+		}
+		if remaining := entry.lockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait, wait <= 0
+}
+
+// RecordFailure counts a failed login and returns how long the caller now
+// has to wait before trying again, which is zero while failures are still free
+func (l *LoginRateLimiter) RecordFailure(ip, username string) time.Duration {
+	return l.recordFailure(rateLimitKeys(ip, username))
+}
+
+// RecordPINFailure counts a wrong PIN against the user only, like AllowPIN
+func (l *LoginRateLimiter) RecordPINFailure(username string) time.Duration {
+	return l.recordFailure([]string{userRateLimitKey(username)})
+}
+
+func (l *LoginRateLimiter) recordFailure(keys []string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if len(l.entries) >= rateLimitSweepSize {
+		l.sweep(now)
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		entry, exists := l.entries[key]
+		if !exists || now.Sub(entry.lastFailure) > l.config.ResetAfter {
+			entry = &rateLimitEntry{}
+			l.entries[key] = entry
+		}
+
+		entry.failures++
+		entry.lastFailure = now
+		if delay := l.delay(entry.failures); delay > 0 {
+			entry.lockedUntil = now.Add(delay)
+			if delay > wait {
+				wait = delay
+			}
+		}
+	}
+
+	return wait
+}
+
+// RecordSuccess forgets the failures for a username after a successful login.
+// The IP address keeps its history so one good account can't be used to
+// reset guessing against others.
+func (l *LoginRateLimiter) RecordSuccess(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, userRateLimitKey(username))
+}
+
+// delay returns the lockout after the given number of consecutive failures
+func (l *LoginRateLimiter) delay(failures int) time.Duration {
+	over := failures - l.config.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+
+	delay := l.config.BaseDelay
+	for i := 1; i < over; i++ {
+		delay *= 2
+		if delay >= l.config.MaxDelay {
+			return l.config.MaxDelay
+		}
+	}
+	return min(delay, l.config.MaxDelay)
+}
+
+// sweep removes entries that are neither locked nor recent enough to matter.
+// The caller must hold l.mu.
+func (l *LoginRateLimiter) sweep(now time.Time) {
+	for key, entry := range l.entries {
+		if now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > l.config.ResetAfter {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+var testRateLimitConfig = RateLimitConfig{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     10 * time.Second,
+	ResetAfter:   time.Hour,
+}
+
+func TestLoginRateLimiterBackoff(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 4, 20, 12, 0, 0, 0, time.UTC)}
+	limiter := NewLoginRateLimiter(testRateLimitConfig, clock)
+
+	// Free attempts don't lock
+	for i := 0; i < testRateLimitConfig.FreeAttempts; i++ {
+		if wait := limiter.RecordFailure("1.2.3.4", "poul"); wait != 0 {
+			t.Fatalf("Expected no lockout after %d failures, got %v", i+1, wait)
+		}
+		if _, ok := limiter.Allow("1.2.3.4", "poul"); !ok {
+			t.Fatalf("Expected login to be allowed after %d failures", i+1)
+		}
+	}
+
+	// Every further failure doubles the lockout up to the maximum
+	for _, expected := range []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if wait := limiter.RecordFailure("1.2.3.4", "poul"); wait != expected {
+			t.Fatalf("Expected lockout of %v, got %v", expected, wait)
+		}
+		wait, ok := limiter.Allow("1.2.3.4", "poul")
+		if ok || wait != expected {
+			t.Fatalf("Expected to wait %v, got %v (allowed %v)", expected, wait, ok)
+		}
+		clock.Advance(expected)
+		if _, ok := limiter.Allow("1.2.3.4", "poul"); !ok {
+			t.Fatalf("Expected lockout of %v to have expired", expected)
+		}
+	}
+}
+
+func TestLoginRateLimiterKeys(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 4, 20, 12, 0, 0, 0, time.UTC)}
+	limiter := NewLoginRateLimiter(testRateLimitConfig, clock)
+
+	for i := 0; i <= testRateLimitConfig.FreeAttempts; i++ {
+		limiter.RecordFailure("1.2.3.4", "poul")
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		username string
+		allowed  bool
+	}{
+		{"same IP and user", "1.2.3.4", "poul", false},
+		{"same user from another IP", "5.6.7.8", "poul", false},
+		{"username is case insensitive", "5.6.7.8", " Poul", false},
+		{"another user from the same IP", "1.2.3.4", "ulla", false},
+		{"another user from another IP", "5.6.7.8", "ulla", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := limiter.Allow(tt.ip, tt.username); ok != tt.allowed {
+				t.Errorf("Expected allowed to be %v, got %v", tt.allowed, ok)
+			}
+		})
+	}
+}
+
+func TestLoginRateLimiterReset(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 4, 20, 12, 0, 0, 0, time.UTC)}
+	limiter := NewLoginRateLimiter(testRateLimitConfig, clock)
+
+	for i := 0; i < testRateLimitConfig.FreeAttempts; i++ {
+		limiter.RecordFailure("1.2.3.4", "poul")
+	}
+
+	// Failures are forgotten after a quiet period
+	clock.Advance(testRateLimitConfig.ResetAfter + time.Second)
+	if wait := limiter.RecordFailure("1.2.3.4", "poul"); wait != 0 {
+		t.Errorf("Expected old failures to be forgotten, got lockout of %v", wait)
+	}
+
+	// A successful login clears the username but not the IP address
+	for i := 0; i < testRateLimitConfig.FreeAttempts; i++ {
+		limiter.RecordFailure("1.2.3.4", "poul")
+	}
+	limiter.RecordSuccess("poul")
+	if _, ok := limiter.Allow("5.6.7.8", "poul"); !ok {
+		t.Errorf("Expected username to be cleared after a successful login")
+	}
+	if _, ok := limiter.Allow("1.2.3.4", "ulla"); ok {
+		t.Errorf("Expected IP address to stay locked after a successful login")
+	}
+}
+
+func TestLoginRateLimiterPIN(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 4, 20, 12, 0, 0, 0, time.UTC)}
+	limiter := NewLoginRateLimiter(testRateLimitConfig, clock)
+
+	for i := 0; i <= testRateLimitConfig.FreeAttempts; i++ {
+		limiter.RecordPINFailure("poul")
+	}
+
+	// A child guessing at the shared tablet only locks themselves out
+	if _, ok := limiter.AllowPIN("poul"); ok {
+		t.Errorf("Expected PIN login to be locked for poul")
+	}
+	if _, ok := limiter.AllowPIN("ulla"); !ok {
+		t.Errorf("Expected PIN login to be allowed for ulla")
+	}
+	if _, ok := limiter.Allow("1.2.3.4", "bagvendt"); !ok {
+		t.Errorf("Expected wrong PINs not to lock the address")
+	}
+}
+
+func TestLoginRateLimitConfig(t *testing.T) {
+	t.Setenv(LoginMaxAttemptsEnv, "")
+	t.Setenv(LoginBackoffEnv, "")
+	t.Setenv(LoginMaxBackoffEnv, "")
+	if config, err := LoginRateLimitConfig(); err != nil || config != DefaultRateLimitConfig {
+		t.Errorf("Expected the defaults when unset, got %+v (%v)", config, err)
+	}
+
+	t.Setenv(LoginMaxAttemptsEnv, "10")
+	t.Setenv(LoginBackoffEnv, "1m")
+	t.Setenv(LoginMaxBackoffEnv, "1h")
+	config, err := LoginRateLimitConfig()
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if config.FreeAttempts != 10 || config.BaseDelay != time.Minute || config.MaxDelay != time.Hour || config.ResetAfter != DefaultRateLimitConfig.ResetAfter {
+		t.Errorf("Expected the settings from the environment, got %+v", config)
+	}
+
+	tests := []struct {
+		env   string
+		value string
+	}{
+		{LoginMaxAttemptsEnv, "many"},
+		{LoginMaxAttemptsEnv, "-1"},
+		{LoginBackoffEnv, "30"},
+		{LoginBackoffEnv, "0s"},
+		{LoginMaxBackoffEnv, "10s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			if _, err := LoginRateLimitConfig(); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
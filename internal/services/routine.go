@@ -1,44 +1,60 @@
 package services
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"log"
-	"regexp"
 	"sort"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/bagvendt/chores/internal/database"
 	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
 )
 
+// ErrBlueprintNotFound is returned when starting a routine from a blueprint
+// that doesn't exist
+var ErrBlueprintNotFound = errors.New("blueprint not found")
+
 // RoutineService handles business logic related to routines
 type RoutineService struct {
-	db *sql.DB
+	routines      database.RoutineRepository
+	blueprints    database.BlueprintRepository
+	choreRoutines database.ChoreRoutineRepository
+	// clock tells the time deadlines are checked against
+	clock Clock
+	// household is the time zone days start and deadlines pass in
+	household *time.Location
 }
 
-// NewRoutineService creates a new instance of RoutineService
-func NewRoutineService(db *sql.DB) *RoutineService {
+// NewRoutineService creates a new instance of RoutineService. Days are
+// counted from midnight in household
+func NewRoutineService(routines database.RoutineRepository, blueprints database.BlueprintRepository, choreRoutines database.ChoreRoutineRepository, clock Clock, household *time.Location) *RoutineService {
 	return &RoutineService{
-		db: db,
+		routines:      routines,
+		blueprints:    blueprints,
+		choreRoutines: choreRoutines,
+		clock:         clock,
+		household:     household,
 	}
 }
 
 // GetRelevantRoutines returns a mix of concrete routines that are still active
 // and virtual routines generated from blueprints applicable today.
-func (s *RoutineService) GetRelevantRoutines(userID int64) ([]models.DisplayableRoutine, error) {
-	now := time.Now() // Get the current time
-	today := now.Weekday()
+func (s *RoutineService) GetRelevantRoutines(ctx context.Context, userID int64) ([]models.DisplayableRoutine, error) {
+	// Today, its weekday and the time are all read on the household's clocks
+	now := s.clock.Now().In(s.household)
+	today := schedule.DateOf(now)
+	clock := schedule.TimeOfDayOf(now)
 
 	// 1. Get relevant routines from the database for the user
-	dbRoutines, err := database.GetRelevantRoutines(s.db, userID, now)
+	dbRoutines, err := s.routines.Relevant(ctx, userID, today)
 	if err != nil {
 		return nil, err
 	}
 
 	// 2. Get all blueprints
-	blueprints, err := database.GetBlueprints(s.db)
+	blueprints, err := s.blueprints.List(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -65,13 +81,8 @@ func (s *RoutineService) GetRelevantRoutines(userID int64) ([]models.Displayable
 			continue
 		}
 
-		// TODO: Add more sophisticated relevance check for concrete routines
-		// (e.g., check deadline based on blueprint.ToBeCompletedBy and routine.Created,
-		// check if already completed today if AllowMultipleInstancesPerDay is false)
-		// For now, include all concrete routines linked to a blueprint.
-
 		// Fetch chore counts (Placeholder - needs implementation)
-		choreCount, completedChores := s.getChoreCountsForRoutine(routine.ID)
+		choreCount, completedChores := s.getChoreCountsForRoutine(ctx, routine.ID)
 
 		displayable := models.DisplayableRoutine{
 			ID:              routine.ID,
@@ -93,138 +104,110 @@ func (s *RoutineService) GetRelevantRoutines(userID int64) ([]models.Displayable
 			displayable.ImageUrl = blueprint.Image // Fallback to blueprint image
 		}
 
+		// Routines the scheduler started wait for the blueprint's window to open,
+		// like the card would
+		if blueprint.AvailableFrom != nil && clock < *blueprint.AvailableFrom {
+			continue
+		}
+
+		// A blueprint done once a day is replaced by its routine, finished or
+		// not. One that may be done several times shows the routines in
+		// progress next to a fresh card, and finished ones drop off
+		if !blueprint.AllowMultipleInstancesPerDay {
+			processedBlueprintIDs[blueprintID] = true
+		} else if displayable.IsComplete() {
+			continue
+		}
+
+		// Past the deadline an unfinished routine is overdue, or hidden if the blueprint says so
+		if clock > blueprint.ToBeCompletedBy && !displayable.IsComplete() {
+			if blueprint.HideWhenOverdue {
+				continue
+			}
+			displayable.Overdue = true
+		}
+
 		relevantRoutines = append(relevantRoutines, displayable)
-		processedBlueprintIDs[blueprintID] = true // Mark this blueprint as processed
 	}
 
 	// 4. Process blueprints to generate virtual routines for today
 	for _, blueprint := range blueprints {
-		// Skip if a concrete instance for this blueprint was already added
+		// Skip if today's routine for this blueprint was already added
 		if processedBlueprintIDs[blueprint.ID] {
-			// TODO: Revisit this based on AllowMultipleInstancesPerDay
 			continue
 		}
 
-		// Check if blueprint is applicable today
-		isApplicable := false
-		switch blueprint.Recurrence {
-		case models.Daily:
-			isApplicable = true
-		case models.Weekday:
-			if today >= time.Monday && today <= time.Friday {
-				isApplicable = true
-			}
-		case models.Weekly:
-			// TODO: Implement weekly logic (e.g., check specific day)
-			// isApplicable = (today == blueprint.TargetWeekday) // Example
-			isApplicable = true // Assuming applicable for now
-		}
-
-		if isApplicable {
-			// Fetch blueprint chores to get count and potentially image
-			blueprintChores, err := database.GetBlueprintChores(s.db, blueprint.ID)
-			if err != nil {
-				// Log error but continue if possible
-				log.Printf("Error fetching chores for blueprint %d: %v", blueprint.ID, err)
-			}
-
-			blueprintID := blueprint.ID
-			displayable := models.DisplayableRoutine{
-				ID:              -blueprintID, // Negative ID for virtual routines
-				Name:            blueprint.Name,
-				ToBeCompletedBy: blueprint.ToBeCompletedBy,
-				ImageUrl:        blueprint.Image, // Use blueprint image by default
-				OwnerID:         userID,
-				SourceType:      models.BlueprintSource,
-				BlueprintID:     &blueprintID,
-				ChoreCount:      len(blueprintChores),
-				CompletedChores: 0, // Virtual routines start incomplete
-				FromBlueprint:   &blueprint,
-			}
-
-			// Try to get a better image from the first chore if blueprint image is missing
-			if displayable.ImageUrl == "" && len(blueprintChores) > 0 {
-				if blueprintChores[0].Image != "" {
-					displayable.ImageUrl = blueprintChores[0].Image
-				} else if blueprintChores[0].Chore != nil && blueprintChores[0].Chore.Image != "" {
-					displayable.ImageUrl = blueprintChores[0].Chore.Image
-				}
-			}
-
-			relevantRoutines = append(relevantRoutines, displayable)
+		// Only blueprints scheduled for today become virtual routines, once
+		// their window has opened
+		if !blueprint.Schedule.IsDue(today) {
+			continue
+		}
+		if blueprint.AvailableFrom != nil && clock < *blueprint.AvailableFrom {
+			continue
+		}
+		overdue := clock > blueprint.ToBeCompletedBy
+		if overdue && blueprint.HideWhenOverdue {
+			continue
 		}
-	}
 
-	// Sort routines by ToBeCompletedBy
-	sort.Slice(relevantRoutines, func(i, j int) bool {
-		return getTimeOfDayPriority(relevantRoutines[i].ToBeCompletedBy) < getTimeOfDayPriority(relevantRoutines[j].ToBeCompletedBy)
-	})
+		// Fetch blueprint chores to get count and potentially image
+		blueprintChores, err := s.blueprints.Chores(ctx, blueprint.ID)
+		if err != nil {
+			// Log error but continue if possible
+			log.Printf("Error fetching chores for blueprint %d: %v", blueprint.ID, err)
+		}
 
-	return relevantRoutines, nil
-}
+		blueprintID := blueprint.ID
+		displayable := models.DisplayableRoutine{
+			ID:              -blueprintID, // Negative ID for virtual routines
+			Name:            blueprint.Name,
+			ToBeCompletedBy: blueprint.ToBeCompletedBy,
+			ImageUrl:        blueprint.Image, // Use blueprint image by default
+			OwnerID:         userID,
+			SourceType:      models.BlueprintSource,
+			BlueprintID:     &blueprintID,
+			ChoreCount:      len(blueprintChores),
+			CompletedChores: 0, // Virtual routines start incomplete
+			Overdue:         overdue,
+			FromBlueprint:   &blueprint,
+		}
 
-// getTimeOfDayPriority returns a numerical priority for a given time of day string
-// Lower numbers will be displayed first in the UI
-func getTimeOfDayPriority(timeStr string) int {
-	// Convert to lowercase for case-insensitive matching
-	timeStr = strings.ToLower(timeStr)
-
-	// Define priority map for common time periods
-	priorities := map[string]int{
-		"morning":   10,
-		"breakfast": 20,
-		"noon":      30,
-		"lunch":     40,
-		"afternoon": 50,
-		"evening":   60,
-		"dinner":    70,
-		"night":     80,
-		"bedtime":   90,
-	}
+		// Try to get a better image from the first chore if blueprint image is missing
+		if displayable.ImageUrl == "" && len(blueprintChores) > 0 {
+			if blueprintChores[0].Image != "" {
+				displayable.ImageUrl = blueprintChores[0].Image
+			} else if blueprintChores[0].Chore != nil && blueprintChores[0].Chore.Image != "" {
+				displayable.ImageUrl = blueprintChores[0].Chore.Image
+			}
+		}
 
-	// Check for exact matches first
-	if priority, exists := priorities[timeStr]; exists {
-		return priority
+		relevantRoutines = append(relevantRoutines, displayable)
 	}
 
-	// Check for partial matches
-	for key, priority := range priorities {
-		if strings.Contains(timeStr, key) {
-			return priority
+	// Sort routines by deadline, and by name when due at the same time.
+	// Several routines from one blueprint go in the order they were started,
+	// with the fresh card last
+	sort.Slice(relevantRoutines, func(i, j int) bool {
+		a, b := relevantRoutines[i], relevantRoutines[j]
+		if a.ToBeCompletedBy != b.ToBeCompletedBy {
+			return a.ToBeCompletedBy < b.ToBeCompletedBy
 		}
-	}
-
-	// Handle specific times (like "8:00", "14:30", etc.)
-	timeRegex := regexp.MustCompile(`(\d{1,2})[:\.]?(\d{2})?\s*(am|pm)?`)
-	if matches := timeRegex.FindStringSubmatch(timeStr); matches != nil {
-		hour, _ := strconv.Atoi(matches[1])
-
-		// Handle AM/PM if present
-		if len(matches) >= 4 && matches[3] != "" {
-			if strings.ToLower(matches[3]) == "pm" && hour < 12 {
-				hour += 12
-			} else if strings.ToLower(matches[3]) == "am" && hour == 12 {
-				hour = 0
-			}
+		if a.Name != b.Name {
+			return a.Name < b.Name
 		}
-
-		// Calculate priority: hours converted to minutes (0-23 hours -> 0-1380 minutes)
-		minutes := 0
-		if len(matches) >= 3 && matches[2] != "" {
-			minutes, _ = strconv.Atoi(matches[2])
+		if a.SourceType != b.SourceType {
+			return a.SourceType == models.DatabaseSource
 		}
+		return a.ID < b.ID
+	})
 
-		return hour*60 + minutes
-	}
-
-	// Default priority for unknown time strings - put them at the end
-	return 1000
+	return relevantRoutines, nil
 }
 
 // getChoreCountsForRoutine fetches the total and completed chore counts for a specific routine instance.
-func (s *RoutineService) getChoreCountsForRoutine(routineID int64) (total int, completed int) {
+func (s *RoutineService) getChoreCountsForRoutine(ctx context.Context, routineID int64) (total int, completed int) {
 	// Call the database function to get the counts
-	total, completed, err := database.GetChoreCountsForRoutine(s.db, routineID)
+	total, completed, err := s.choreRoutines.Counts(ctx, routineID)
 	if err != nil {
 		log.Printf("Error counting chores for routine %d: %v", routineID, err)
 		return 0, 0
@@ -232,3 +215,18 @@ func (s *RoutineService) getChoreCountsForRoutine(routineID int64) (total int, c
 
 	return total, completed
 }
+
+// StartRoutine starts the blueprint's routine for the owner today. Starting
+// it again the same day gives the same routine, unless the blueprint allows
+// several a day
+func (s *RoutineService) StartRoutine(ctx context.Context, ownerID, blueprintID int64) (*models.Routine, error) {
+	today := schedule.DateOf(s.clock.Now().In(s.household))
+	routine, _, err := s.routines.Start(ctx, ownerID, blueprintID, today)
+	if err != nil {
+		return nil, err
+	}
+	if routine == nil {
+		return nil, ErrBlueprintNotFound
+	}
+	return routine, nil
+}
@@ -0,0 +1,436 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/bagvendt/chores"
+	"github.com/bagvendt/chores/internal/database"
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
+)
+
+// The fakes embed their interface so methods a test doesn't need panic if called
+
+type fakeRoutines struct {
+	database.RoutineRepository
+	routines map[int64]models.Routine
+}
+
+func (f fakeRoutines) Get(ctx context.Context, id int64) (*models.Routine, error) {
+	routine, ok := f.routines[id]
+	if !ok {
+		return nil, nil
+	}
+	return &routine, nil
+}
+
+func (f fakeRoutines) Relevant(ctx context.Context, userID int64, day schedule.Date) ([]models.Routine, error) {
+	var routines []models.Routine
+	for _, routine := range f.routines {
+		if routine.OwnerID == userID && routine.Day == day {
+			routines = append(routines, routine)
+		}
+	}
+	return routines, nil
+}
+
+type fakeBlueprints struct {
+	database.BlueprintRepository
+	blueprints []models.RoutineBlueprint
+	chores     map[int64][]models.RoutineBlueprintChore
+}
+
+func (f fakeBlueprints) List(ctx context.Context) ([]models.RoutineBlueprint, error) {
+	return f.blueprints, nil
+}
+
+func (f fakeBlueprints) Chores(ctx context.Context, blueprintID int64) ([]models.RoutineBlueprintChore, error) {
+	return f.chores[blueprintID], nil
+}
+
+type fakeChoreRoutines struct {
+	database.ChoreRoutineRepository
+	choreRoutines map[int64][]models.ChoreRoutine
+}
+
+func (f fakeChoreRoutines) ForRoutine(ctx context.Context, routineID int64) ([]models.ChoreRoutine, error) {
+	return f.choreRoutines[routineID], nil
+}
+
+func (f fakeChoreRoutines) Counts(ctx context.Context, routineID int64) (int, int, error) {
+	completed := 0
+	for _, cr := range f.choreRoutines[routineID] {
+		if cr.CompletedAt != nil {
+			completed++
+		}
+	}
+	return len(f.choreRoutines[routineID]), completed, nil
+}
+
+// familyMorning is when newFakeFamily's routine was started, and the time
+// its tests run at
+var familyMorning = time.Date(2025, 4, 22, 7, 0, 0, 0, time.UTC)
+
+// newFakeFamily returns fakes for a child (2) with a morning and an evening
+// blueprint, where the evening routine has been started and one of its chores done
+func newFakeFamily() (fakeRoutines, fakeBlueprints, fakeChoreRoutines) {
+	teeth := &models.Chore{ID: 1, Name: "Brush teeth", DefaultPoints: 2}
+	dress := &models.Chore{ID: 2, Name: "Get dressed", DefaultPoints: 3}
+	done := models.NewTimestamp(familyMorning)
+
+	routines := fakeRoutines{routines: map[int64]models.Routine{
+		10: {ID: 10, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 2, Valid: true}, Created: models.NewTimestamp(familyMorning), Day: schedule.DateOf(familyMorning)},
+	}}
+	blueprints := fakeBlueprints{
+		blueprints: []models.RoutineBlueprint{
+			{ID: 1, Name: "Morning", ToBeCompletedBy: schedule.NewTimeOfDay(8, 0), Schedule: schedule.Schedule{Frequency: schedule.Daily}},
+			{ID: 2, Name: "Evening", ToBeCompletedBy: schedule.NewTimeOfDay(18, 0), Schedule: schedule.Schedule{Frequency: schedule.Daily}},
+		},
+		chores: map[int64][]models.RoutineBlueprintChore{
+			1: {{RoutineBlueprintID: 1, ChoreID: 1, Chore: teeth}, {RoutineBlueprintID: 1, ChoreID: 2, Chore: dress}},
+			2: {{RoutineBlueprintID: 2, ChoreID: 1, Chore: teeth}, {RoutineBlueprintID: 2, ChoreID: 2, Chore: dress}},
+		},
+	}
+	choreRoutines := fakeChoreRoutines{choreRoutines: map[int64][]models.ChoreRoutine{
+		10: {{ID: 100, RoutineID: 10, ChoreID: 1, PointsAwarded: 2, CompletedAt: &done, Chore: teeth}},
+	}}
+	return routines, blueprints, choreRoutines
+}
+
+func TestGetRelevantRoutines(t *testing.T) {
+	routines, blueprints, choreRoutines := newFakeFamily()
+	service := NewRoutineService(routines, blueprints, choreRoutines, &fakeClock{now: familyMorning}, time.UTC)
+
+	displayed, err := service.GetRelevantRoutines(t.Context(), 2)
+	if err != nil {
+		t.Fatalf("Failed to get relevant routines: %v", err)
+	}
+	if len(displayed) != 2 {
+		t.Fatalf("Expected 2 routines, got %d", len(displayed))
+	}
+
+	// Morning sorts first and has not been started, so it comes from the blueprint
+	morning, evening := displayed[0], displayed[1]
+	if morning.Name != "Morning" || morning.SourceType != models.BlueprintSource || morning.ID != -1 {
+		t.Errorf("Expected a virtual morning routine first, got %+v", morning)
+	}
+	if morning.ChoreCount != 2 || morning.CompletedChores != 0 {
+		t.Errorf("Expected 0 of 2 morning chores done, got %d of %d", morning.CompletedChores, morning.ChoreCount)
+	}
+
+	// The started evening routine replaces its blueprint
+	if evening.Name != "Evening" || evening.SourceType != models.DatabaseSource || evening.ID != 10 {
+		t.Errorf("Expected the stored evening routine second, got %+v", evening)
+	}
+	if evening.ChoreCount != 1 || evening.CompletedChores != 1 {
+		t.Errorf("Expected 1 of 1 evening chores done, got %d of %d", evening.CompletedChores, evening.ChoreCount)
+	}
+
+	// Another child sees only the blueprints
+	displayed, err = service.GetRelevantRoutines(t.Context(), 3)
+	if err != nil {
+		t.Fatalf("Failed to get relevant routines: %v", err)
+	}
+	for _, routine := range displayed {
+		if routine.SourceType != models.BlueprintSource {
+			t.Errorf("Expected only virtual routines for a child without routines, got %+v", routine)
+		}
+	}
+}
+
+func TestGetRelevantRoutinesDeadlines(t *testing.T) {
+	at := func(hour, minute int) schedule.TimeOfDay { return schedule.NewTimeOfDay(hour, minute) }
+	six := at(6, 0)
+	daily := schedule.Schedule{Frequency: schedule.Daily}
+
+	// Listed out of order so sorting by deadline is visible
+	blueprints := fakeBlueprints{blueprints: []models.RoutineBlueprint{
+		{ID: 1, Name: "Bedtime", ToBeCompletedBy: at(20, 0), Schedule: daily},
+		{ID: 2, Name: "Homework", ToBeCompletedBy: at(17, 0), HideWhenOverdue: true, Schedule: daily},
+		{ID: 3, Name: "Breakfast", AvailableFrom: &six, ToBeCompletedBy: at(8, 0), Schedule: daily},
+		{ID: 4, Name: "Tidy up", ToBeCompletedBy: at(18, 0), HideWhenOverdue: true, Schedule: daily},
+		{ID: 5, Name: "Teeth", ToBeCompletedBy: at(18, 0), Schedule: daily},
+	}}
+	// Tidy up was started and finished, Teeth was started and not finished,
+	// both just after midnight. The scheduler started Breakfast at midnight
+	started := models.NewTimestamp(time.Date(2025, 4, 22, 0, 0, 0, 0, time.UTC))
+	done := started
+	routines := fakeRoutines{routines: map[int64]models.Routine{
+		30: {ID: 30, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 3, Valid: true}, Created: started, Day: schedule.DateOf(started.Time)},
+		40: {ID: 40, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 4, Valid: true}, Created: started, Day: schedule.DateOf(started.Time)},
+		50: {ID: 50, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 5, Valid: true}, Created: started, Day: schedule.DateOf(started.Time)},
+	}}
+	choreRoutines := fakeChoreRoutines{choreRoutines: map[int64][]models.ChoreRoutine{
+		30: {{ID: 300, RoutineID: 30, ChoreID: 1}},
+		40: {{ID: 400, RoutineID: 40, ChoreID: 1, CompletedAt: &done}},
+		50: {{ID: 500, RoutineID: 50, ChoreID: 1}},
+	}}
+
+	tests := []struct {
+		name    string
+		clock   schedule.TimeOfDay
+		want    []string
+		overdue []string
+	}{
+		{"before breakfast opens", at(5, 59), []string{"Homework", "Teeth", "Tidy up", "Bedtime"}, nil},
+		{"breakfast open", at(6, 0), []string{"Breakfast", "Homework", "Teeth", "Tidy up", "Bedtime"}, nil},
+		{"at the deadline", at(8, 0), []string{"Breakfast", "Homework", "Teeth", "Tidy up", "Bedtime"}, nil},
+		{"breakfast overdue", at(8, 1), []string{"Breakfast", "Homework", "Teeth", "Tidy up", "Bedtime"}, []string{"Breakfast"}},
+		{"homework hidden", at(17, 30), []string{"Breakfast", "Teeth", "Tidy up", "Bedtime"}, []string{"Breakfast"}},
+		{"finished routines stay", at(19, 0), []string{"Breakfast", "Teeth", "Tidy up", "Bedtime"}, []string{"Breakfast", "Teeth"}},
+		{"end of day", schedule.EndOfDay, []string{"Breakfast", "Teeth", "Tidy up", "Bedtime"}, []string{"Breakfast", "Teeth", "Bedtime"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2025, 4, 22, tt.clock.Hour(), tt.clock.Minute(), 0, 0, time.UTC)
+			service := NewRoutineService(routines, blueprints, choreRoutines, &fakeClock{now: now}, time.UTC)
+
+			displayed, err := service.GetRelevantRoutines(t.Context(), 2)
+			if err != nil {
+				t.Fatalf("Failed to get relevant routines: %v", err)
+			}
+
+			var names, overdue []string
+			for _, routine := range displayed {
+				names = append(names, routine.Name)
+				if routine.Overdue {
+					overdue = append(overdue, routine.Name)
+				}
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, names)
+			}
+			if !slices.Equal(overdue, tt.overdue) {
+				t.Errorf("Expected %v overdue, got %v", tt.overdue, overdue)
+			}
+		})
+	}
+}
+
+func TestGetRelevantRoutinesHouseholdDay(t *testing.T) {
+	copenhagen, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, copenhagen)
+	}
+
+	blueprints := fakeBlueprints{blueprints: []models.RoutineBlueprint{
+		{ID: 1, Name: "Breakfast", ToBeCompletedBy: schedule.NewTimeOfDay(8, 0), Schedule: schedule.Schedule{Frequency: schedule.Daily}},
+		{ID: 2, Name: "Evening", ToBeCompletedBy: schedule.EndOfDay, Schedule: schedule.Schedule{Frequency: schedule.Daily}},
+		{ID: 3, Name: "School", ToBeCompletedBy: schedule.EndOfDay, Schedule: schedule.Schedule{Frequency: schedule.Weekly, Weekdays: schedule.WorkDays}},
+	}}
+
+	// Summer time started 2024-03-31 and ended 2024-10-27, making those days
+	// 23 and 25 hours long
+	tests := []struct {
+		name    string
+		now     time.Time
+		started time.Time // when the evening routine was started
+		want    []string
+		overdue []string
+		// stored reports whether the evening routine counts as today's
+		stored bool
+	}{
+		{"just before Saturday", at(2024, 3, 29, 23, 50), at(2024, 3, 29, 0, 10), []string{"Breakfast", "Evening", "School"}, []string{"Breakfast"}, true},
+		{"just after midnight on Saturday", at(2024, 3, 30, 0, 30), at(2024, 3, 30, 0, 10), []string{"Breakfast", "Evening"}, nil, true},
+		{"started on Friday", at(2024, 3, 30, 0, 30), at(2024, 3, 29, 23, 50), []string{"Breakfast", "Evening"}, nil, false},
+		{"late on the 23 hour day", at(2024, 3, 31, 23, 30), at(2024, 3, 31, 0, 30), []string{"Breakfast", "Evening"}, []string{"Breakfast"}, true},
+		{"just after the 23 hour day", at(2024, 4, 1, 0, 30), at(2024, 3, 31, 23, 50), []string{"Breakfast", "Evening", "School"}, nil, false},
+		{"late on the 25 hour day", at(2024, 10, 27, 23, 30), at(2024, 10, 27, 0, 15), []string{"Breakfast", "Evening"}, []string{"Breakfast"}, true},
+		{"before the 25 hour day", at(2024, 10, 27, 23, 30), at(2024, 10, 26, 23, 45), []string{"Breakfast", "Evening"}, []string{"Breakfast"}, false},
+		{"past breakfast after the 25 hour day", at(2024, 10, 28, 8, 30), at(2024, 10, 28, 7, 0), []string{"Breakfast", "Evening", "School"}, []string{"Breakfast"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routines := fakeRoutines{routines: map[int64]models.Routine{
+				20: {ID: 20, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 2, Valid: true}, Created: models.NewTimestamp(tt.started), Day: schedule.DateOf(tt.started)},
+			}}
+			// The clock runs in UTC, so only the household decides where days start
+			service := NewRoutineService(routines, blueprints, fakeChoreRoutines{}, &fakeClock{now: tt.now.UTC()}, copenhagen)
+
+			displayed, err := service.GetRelevantRoutines(t.Context(), 2)
+			if err != nil {
+				t.Fatalf("Failed to get relevant routines: %v", err)
+			}
+
+			var names, overdue []string
+			stored := false
+			for _, routine := range displayed {
+				names = append(names, routine.Name)
+				if routine.Overdue {
+					overdue = append(overdue, routine.Name)
+				}
+				if routine.Name == "Evening" {
+					stored = routine.SourceType == models.DatabaseSource
+				}
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, names)
+			}
+			if !slices.Equal(overdue, tt.overdue) {
+				t.Errorf("Expected %v overdue, got %v", tt.overdue, overdue)
+			}
+			if stored != tt.stored {
+				t.Errorf("Expected the started evening routine to count as today's: %v, got %v", tt.stored, stored)
+			}
+		})
+	}
+}
+
+func TestGetRelevantRoutinesMultipleInstances(t *testing.T) {
+	daily := schedule.Schedule{Frequency: schedule.Daily}
+	blueprints := fakeBlueprints{blueprints: []models.RoutineBlueprint{
+		{ID: 1, Name: "Snack", ToBeCompletedBy: schedule.EndOfDay, AllowMultipleInstancesPerDay: true, Schedule: daily},
+		{ID: 2, Name: "Teeth", ToBeCompletedBy: schedule.EndOfDay, Schedule: daily},
+	}}
+
+	// Two snacks were started and the first one eaten, and teeth were brushed
+	started := models.NewTimestamp(familyMorning)
+	done := started
+	routines := fakeRoutines{routines: map[int64]models.Routine{
+		30: {ID: 30, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 1, Valid: true}, Created: started, Day: schedule.DateOf(started.Time)},
+		31: {ID: 31, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 1, Valid: true}, Created: started, Day: schedule.DateOf(started.Time)},
+		32: {ID: 32, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 1, Valid: true}, Created: started, Day: schedule.DateOf(started.Time)},
+		40: {ID: 40, OwnerID: 2, RoutineBlueprintID: sql.NullInt64{Int64: 2, Valid: true}, Created: started, Day: schedule.DateOf(started.Time)},
+	}}
+	choreRoutines := fakeChoreRoutines{choreRoutines: map[int64][]models.ChoreRoutine{
+		30: {{ID: 300, RoutineID: 30, ChoreID: 1, CompletedAt: &done}},
+		31: {{ID: 310, RoutineID: 31, ChoreID: 1}},
+		32: {{ID: 320, RoutineID: 32, ChoreID: 1}},
+		40: {{ID: 400, RoutineID: 40, ChoreID: 1, CompletedAt: &done}},
+	}}
+	service := NewRoutineService(routines, blueprints, choreRoutines, &fakeClock{now: familyMorning}, time.UTC)
+
+	displayed, err := service.GetRelevantRoutines(t.Context(), 2)
+	if err != nil {
+		t.Fatalf("Failed to get relevant routines: %v", err)
+	}
+
+	// The snacks in progress come in the order they were started, followed by
+	// a fresh card. The finished teeth routine stays and gets no new card
+	want := []int64{31, 32, -1, 40}
+	var ids []int64
+	for _, routine := range displayed {
+		ids = append(ids, routine.ID)
+	}
+	if !slices.Equal(ids, want) {
+		t.Errorf("Expected routines %v, got %v", want, ids)
+	}
+}
+
+// openMigratedStore opens a store on a new database with the real migrations
+// and their seed data
+func openMigratedStore(t *testing.T) *database.Store {
+	writer, reader, err := database.OpenFile(filepath.Join(t.TempDir(), "chores.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		reader.Close()
+		writer.Close()
+	})
+
+	if err := database.NewMigrationManager(writer, chores.Migrations()).RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	return database.NewStore(writer, reader)
+}
+
+func TestStartRoutine(t *testing.T) {
+	store := openMigratedStore(t)
+	copenhagen, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+	if _, err := store.DB.Exec("UPDATE routine_blueprints SET allow_multiple_instances_per_day = 1 WHERE id = 2"); err != nil {
+		t.Fatalf("Failed to update blueprint: %v", err)
+	}
+
+	// Half past midnight in Copenhagen is still the day before in UTC
+	clock := &fakeClock{now: time.Date(2024, 4, 1, 0, 30, 0, 0, copenhagen)}
+	service := NewRoutineService(store.Routines, store.Blueprints, store.ChoreRoutines, clock, copenhagen)
+
+	first, err := service.StartRoutine(t.Context(), 2, 1)
+	if err != nil {
+		t.Fatalf("Failed to start routine: %v", err)
+	}
+	if want := (schedule.Date{Year: 2024, Month: time.April, Day: 1}); first.Day != want {
+		t.Errorf("Expected the routine to be on %s, got %s", want, first.Day)
+	}
+
+	// Starting it again later the same day gives the same routine
+	clock.Advance(20 * time.Hour)
+	again, err := service.StartRoutine(t.Context(), 2, 1)
+	if err != nil || again.ID != first.ID {
+		t.Errorf("Expected routine %d again, got %+v (%v)", first.ID, again, err)
+	}
+
+	// The next day starts a new one
+	clock.Advance(4 * time.Hour)
+	next, err := service.StartRoutine(t.Context(), 2, 1)
+	if err != nil || next.ID == first.ID {
+		t.Errorf("Expected a new routine the next day, got %+v (%v)", next, err)
+	}
+
+	// Blueprint 2 allows several a day
+	one, err := service.StartRoutine(t.Context(), 2, 2)
+	if err != nil {
+		t.Fatalf("Failed to start routine: %v", err)
+	}
+	two, err := service.StartRoutine(t.Context(), 2, 2)
+	if err != nil || two.ID == one.ID {
+		t.Errorf("Expected a second routine, got %+v (%v)", two, err)
+	}
+
+	if _, err := service.StartRoutine(t.Context(), 2, 999); !errors.Is(err, ErrBlueprintNotFound) {
+		t.Errorf("Expected ErrBlueprintNotFound, got %v", err)
+	}
+}
+
+func TestGetChoresForRoutine(t *testing.T) {
+	service := NewChoreService(newFakeFamily())
+
+	choreRoutines, err := service.GetChoresForRoutine(t.Context(), 10)
+	if err != nil {
+		t.Fatalf("Failed to get chores for routine: %v", err)
+	}
+	if len(choreRoutines) != 2 {
+		t.Fatalf("Expected 2 chores, got %d", len(choreRoutines))
+	}
+
+	// The stored chore comes first, followed by the blueprint chore not yet started
+	if choreRoutines[0].ID != 100 || choreRoutines[0].CompletedAt == nil {
+		t.Errorf("Expected the completed chore routine first, got %+v", choreRoutines[0])
+	}
+	synthetic := choreRoutines[1]
+	if synthetic.ID != 0 || synthetic.ChoreID != 2 || synthetic.PointsAwarded != 3 || synthetic.CompletedAt != nil {
+		t.Errorf("Expected an unsaved chore routine for chore 2 worth 3 points, got %+v", synthetic)
+	}
+
+	if _, err := service.GetChoresForRoutine(t.Context(), 99); err != ErrRoutineNotFound {
+		t.Errorf("Expected ErrRoutineNotFound, got %v", err)
+	}
+}
+
+func TestGetRelevantRoutinesCancelled(t *testing.T) {
+	store := setupAuthorizationTestDB(t)
+	service := NewRoutineService(store.Routines, store.Blueprints, store.ChoreRoutines, SystemClock{}, time.UTC)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := service.GetRelevantRoutines(ctx, 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
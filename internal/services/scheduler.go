@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bagvendt/chores/internal/database"
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/schedule"
+)
+
+// Scheduler starts the routines of the day for every child when the
+// household's day begins, so routines nobody started are still recorded, and
+// marks the ones left unfinished as missed
+type Scheduler struct {
+	routines   database.RoutineRepository
+	blueprints database.BlueprintRepository
+	users      database.UserRepository
+	clock      Clock
+	household  *time.Location
+
+	// mu makes runs take turns, so the admin button and the background loop
+	// don't start the same routines at once
+	mu      sync.Mutex
+	lastRun models.SchedulerRun
+}
+
+// NewScheduler creates a Scheduler whose days start at midnight in household
+func NewScheduler(routines database.RoutineRepository, blueprints database.BlueprintRepository, users database.UserRepository, clock Clock, household *time.Location) *Scheduler {
+	return &Scheduler{
+		routines:   routines,
+		blueprints: blueprints,
+		users:      users,
+		clock:      clock,
+		household:  household,
+	}
+}
+
+// today returns the household's current date
+func (s *Scheduler) today() schedule.Date {
+	return schedule.DateOf(s.clock.Now().In(s.household))
+}
+
+// LastRun returns the last successful run, or a zero run if there has been
+// none
+func (s *Scheduler) LastRun() models.SchedulerRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastRun
+}
+
+// Run marks unfinished routines from earlier days as missed and starts a
+// routine from every blueprint due today for every active child. Routines
+// that already exist are kept, so running it again changes nothing
+func (s *Scheduler) Run(ctx context.Context) (models.SchedulerRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run := models.SchedulerRun{Day: s.today()}
+
+	missed, err := s.routines.MarkMissed(ctx, run.Day)
+	if err != nil {
+		return run, fmt.Errorf("marking missed routines: %w", err)
+	}
+	run.Missed = missed
+
+	blueprints, err := s.blueprints.List(ctx)
+	if err != nil {
+		return run, err
+	}
+	users, err := s.users.List(ctx)
+	if err != nil {
+		return run, err
+	}
+
+	// One child's failure shouldn't keep the others' routines from starting
+	var errs []error
+	for _, blueprint := range blueprints {
+		if !blueprint.Schedule.IsDue(run.Day) {
+			continue
+		}
+		for _, user := range users {
+			if user.Role != models.RoleChild || !user.Active {
+				continue
+			}
+			_, started, err := s.routines.Ensure(ctx, user.ID, blueprint.ID, run.Day)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("starting %q for %s: %w", blueprint.Name, user.Name, err))
+				continue
+			}
+			if started {
+				run.Started++
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return run, err
+	}
+
+	run.At = s.clock.Now()
+	s.lastRun = run
+	return run, nil
+}
+
+// runIfNewDay runs the scheduler unless it already ran for today, and
+// reports whether it ran. A failed run is tried again on the next call
+func (s *Scheduler) runIfNewDay(ctx context.Context) bool {
+	if s.LastRun().Day == s.today() {
+		return false
+	}
+
+	run, err := s.Run(ctx)
+	if err != nil {
+		log.Printf("Scheduler run for %s failed: %v", run.Day, err)
+		return true
+	}
+	log.Printf("Scheduler started %d routines for %s and marked %d missed", run.Started, run.Day, run.Missed)
+	return true
+}
+
+// Start runs the scheduler now and then checks every interval whether a new
+// household day has begun, until stop is called. Checking the date rather
+// than sleeping until midnight copes with DST and a machine that was asleep
+func (s *Scheduler) Start(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		s.runIfNewDay(context.Background())
+		for {
+			select {
+			case <-ticker.C:
+				s.runIfNewDay(context.Background())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bagvendt/chores/internal/schedule"
+)
+
+func TestScheduler(t *testing.T) {
+	store := openMigratedStore(t)
+	copenhagen, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+
+	// Morgen (1) and Eftermiddag (2) are due every day, and Eftermiddag may be
+	// done several times. Aften (3) is never due
+	_, err = store.DB.Exec(`
+		UPDATE routine_blueprints SET recurrence = 'Daily', starts_on = '2024-01-01' WHERE id IN (1, 2);
+		UPDATE routine_blueprints SET allow_multiple_instances_per_day = 1 WHERE id = 2;
+		UPDATE routine_blueprints SET recurrence = NULL WHERE id = 3;
+		UPDATE users SET active = 0 WHERE name = 'ulla';
+	`)
+	if err != nil {
+		t.Fatalf("Failed to set up blueprints: %v", err)
+	}
+
+	// Five past midnight in Copenhagen is still the day before in UTC
+	clock := &fakeClock{now: time.Date(2024, 4, 1, 0, 5, 0, 0, copenhagen)}
+	scheduler := NewScheduler(store.Routines, store.Blueprints, store.Users, clock, copenhagen)
+	monday := schedule.Date{Year: 2024, Month: time.April, Day: 1}
+
+	countRoutines := func(day schedule.Date) int {
+		t.Helper()
+		var count int
+		if err := store.DB.QueryRow("SELECT COUNT(*) FROM routines WHERE day = ?", day).Scan(&count); err != nil {
+			t.Fatalf("Failed to count routines: %v", err)
+		}
+		return count
+	}
+
+	// Only poul is an active child, so he gets one routine from each due blueprint
+	if !scheduler.runIfNewDay(t.Context()) {
+		t.Fatalf("Expected the first check to run the scheduler")
+	}
+	if run := scheduler.LastRun(); run.Day != monday || run.Started != 2 || run.Missed != 0 {
+		t.Errorf("Expected 2 routines started on %s, got %+v", monday, run)
+	}
+	if got := countRoutines(monday); got != 2 {
+		t.Errorf("Expected 2 routines on %s, got %d", monday, got)
+	}
+	var choreRoutines int
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM chore_routines cr JOIN routines r ON cr.routine_id = r.id WHERE r.day = ?", monday).Scan(&choreRoutines); err != nil {
+		t.Fatalf("Failed to count chore routines: %v", err)
+	}
+	if choreRoutines != 5+3 {
+		t.Errorf("Expected the routines to get their blueprints' 8 chores, got %d", choreRoutines)
+	}
+
+	// Later the same day nothing happens, and running it by hand starts nothing new
+	clock.Advance(12 * time.Hour)
+	if scheduler.runIfNewDay(t.Context()) {
+		t.Errorf("Expected no run later the same day")
+	}
+	run, err := scheduler.Run(t.Context())
+	if err != nil || run.Started != 0 || run.Missed != 0 {
+		t.Errorf("Expected running again to change nothing, got %+v (%v)", run, err)
+	}
+	if got := countRoutines(monday); got != 2 {
+		t.Errorf("Expected still 2 routines on %s, got %d", monday, got)
+	}
+
+	// poul finishes Morgen but not Eftermiddag
+	_, err = store.DB.Exec(`
+		UPDATE chore_routines SET completed_at = '2024-04-01T06:00:00Z', completed_by = 1
+		WHERE routine_id = (SELECT id FROM routines WHERE routine_blueprint_id = 1 AND day = ?)
+	`, monday)
+	if err != nil {
+		t.Fatalf("Failed to complete routine: %v", err)
+	}
+
+	// At midnight Eftermiddag is missed and Tuesday's routines start
+	clock.Advance(12 * time.Hour)
+	if !scheduler.runIfNewDay(t.Context()) {
+		t.Fatalf("Expected the next day to run the scheduler")
+	}
+	if run := scheduler.LastRun(); run.Day != monday.AddDays(1) || run.Started != 2 || run.Missed != 1 {
+		t.Errorf("Expected 2 routines started on Tuesday and 1 missed, got %+v", run)
+	}
+	var missedBlueprint int64
+	if err := store.DB.QueryRow("SELECT routine_blueprint_id FROM routines WHERE missed").Scan(&missedBlueprint); err != nil {
+		t.Fatalf("Failed to find the missed routine: %v", err)
+	}
+	if missedBlueprint != 2 {
+		t.Errorf("Expected Eftermiddag to be missed, got blueprint %d", missedBlueprint)
+	}
+
+	run, err = scheduler.Run(t.Context())
+	if err != nil || run.Started != 0 || run.Missed != 0 {
+		t.Errorf("Expected running again to change nothing, got %+v (%v)", run, err)
+	}
+}
+
+func TestSchedulerAcrossDST(t *testing.T) {
+	store := openMigratedStore(t)
+	copenhagen, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+	if _, err := store.DB.Exec("UPDATE routine_blueprints SET recurrence = 'Daily', starts_on = '2024-01-01'"); err != nil {
+		t.Fatalf("Failed to set up blueprints: %v", err)
+	}
+
+	// The day summer time starts has 23 hours, so 23 hours after its midnight
+	// is the next day
+	clock := &fakeClock{now: time.Date(2024, 3, 31, 0, 0, 0, 0, copenhagen)}
+	scheduler := NewScheduler(store.Routines, store.Blueprints, store.Users, clock, copenhagen)
+	if !scheduler.runIfNewDay(t.Context()) {
+		t.Fatalf("Expected the first check to run the scheduler")
+	}
+
+	clock.Advance(22*time.Hour + 59*time.Minute)
+	if scheduler.runIfNewDay(t.Context()) {
+		t.Errorf("Expected no run at 23:59 on the 23 hour day")
+	}
+	clock.Advance(time.Minute)
+	if !scheduler.runIfNewDay(t.Context()) {
+		t.Errorf("Expected a run at midnight after the 23 hour day")
+	}
+	if want := (schedule.Date{Year: 2024, Month: time.April, Day: 1}); scheduler.LastRun().Day != want {
+		t.Errorf("Expected the run to be for %s, got %s", want, scheduler.LastRun().Day)
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bagvendt/chores/internal/models"
+)
+
+// MemorySessionStore is a database.SessionRepository that keeps sessions in
+// memory. It is safe for concurrent use and is mostly useful in tests, since
+// sessions are lost on restart.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*models.Session
+	nextID   int64
+}
+
+// NewMemorySessionStore creates an empty in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*models.Session),
+	}
+}
+
+func (s *MemorySessionStore) Create(ctx context.Context, session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.nextID++
+	session.ID = s.nextID
+	session.Created = models.NewTimestamp(now)
+	session.LastSeen = session.Created
+
+	stored := *session
+	s.sessions[session.TokenHash] = &stored
+	return nil
+}
+
+func (s *MemorySessionStore) GetByTokenHash(ctx context.Context, tokenHash string, now time.Time) (*models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Sessions are keyed by the SHA-256 of their token, so how long the lookup
+	// takes says nothing about the token itself. That is the timing defence,
+	// the same as for the token_hash index in SQLite
+	session, exists := s.sessions[tokenHash]
+	if !exists {
+		return nil, nil
+	}
+	if !session.Expires.After(now) {
+		return nil, nil
+	}
+
+	// Return a copy so callers never race with Touch
+	found := *session
+	return &found, nil
+}
+
+func (s *MemorySessionStore) Touch(ctx context.Context, id int64, lastSeen time.Time, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.ID == id {
+			session.LastSeen = models.NewTimestamp(lastSeen)
+			session.Expires = models.NewTimestamp(expires)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(ctx context.Context, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteForUser(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for tokenHash, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, tokenHash)
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for tokenHash, session := range s.sessions {
+		if !session.Expires.After(now) {
+			delete(s.sessions, tokenHash)
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -1,11 +1,17 @@
 package services
 
 import (
-	"database/sql"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"strconv"
+	"log"
 	"time"
 
+	"github.com/bagvendt/chores/internal/database"
 	"github.com/bagvendt/chores/internal/models"
 	"github.com/bagvendt/chores/internal/utils/auth"
 )
@@ -13,88 +19,246 @@ import (
 var (
 	ErrInvalidCredentials = errors.New("invalid username or password")
 	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidPIN         = errors.New("invalid PIN")
+	ErrPINLocked          = errors.New("too many wrong PINs, try again later")
+	ErrPINNotAllowed      = errors.New("PIN login is not allowed for this user")
 )
 
-// Sessions is an in-memory session store
-var Sessions = make(map[string]*models.User)
+const (
+	// SessionTTL is how long a session stays valid without any activity
+	SessionTTL = 30 * 24 * time.Hour
 
-// GenerateSessionToken generates a simple session token
-// In a production environment, use a more secure method of token generation
-func GenerateSessionToken(userID int64) string {
-	// Simple implementation for a toy app - using timestamp and userID
-	return time.Now().Format("20060102150405") + "-" + strconv.FormatInt(userID, 10)
+	// sessionTouchInterval limits how often activity is written back to the sessions table
+	sessionTouchInterval = time.Minute
+
+	// sessionTokenBytes is the amount of randomness in a session token (256 bits)
+	sessionTokenBytes = 32
+
+	// MaxPINAttempts is how many wrong PINs in a row lock a user out of PIN login
+	MaxPINAttempts = 5
+
+	// PINLockoutDuration is how long PIN login stays locked after MaxPINAttempts
+	PINLockoutDuration = 15 * time.Minute
+
+	// dummyPasswordHash is checked when nobody has the username, so a failed
+	// login takes as long whether or not the user exists. It has the same cost
+	// as the hashes auth.HashPassword stores.
+	dummyPasswordHash = "$2a$12$V/EHlfxo7Sh3KR./nlUSauU37bO9kEIizfXXm1wFYU7u4WrOjovYG"
+)
+
+// AuthenticationService logs users in with passwords, PINs and API tokens
+// and keeps track of their sessions
+type AuthenticationService struct {
+	users     database.UserRepository
+	sessions  database.SessionRepository
+	apiTokens database.APITokenRepository
+}
+
+// NewAuthenticationService creates a new instance of AuthenticationService
+func NewAuthenticationService(users database.UserRepository, sessions database.SessionRepository, apiTokens database.APITokenRepository) *AuthenticationService {
+	return &AuthenticationService{
+		users:     users,
+		sessions:  sessions,
+		apiTokens: apiTokens,
+	}
+}
+
+// GenerateSessionToken generates a random, URL safe session token
+func GenerateSessionToken() (string, error) {
+	b := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a session or API token.
+// Only the hash is stored so a leaked database can't be used to log in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // AuthenticateUser authenticates a user with username and password
-func AuthenticateUser(db *sql.DB, username, password string) (*models.User, string, error) {
-	var user models.User
-	var hashedPassword string
-	var createdStr, modifiedStr string
-
-	err := db.QueryRow(`
-		SELECT id, created, modified, name, password
-		FROM users
-		WHERE name = ?
-	`, username).Scan(&user.ID, &createdStr, &modifiedStr, &user.Name, &hashedPassword)
-
-	if err == sql.ErrNoRows {
+func (s *AuthenticationService) AuthenticateUser(ctx context.Context, username, password, userAgent string) (*models.User, string, error) {
+	user, err := s.users.Credentials(ctx, username)
+	if err != nil {
+		return nil, "", err
+	}
+	if user == nil {
+		// Don't reveal through timing that the username is unknown
+		auth.ComparePasswords(dummyPasswordHash, password)
 		return nil, "", ErrUserNotFound
 	}
+
+	// Check password
+	err = auth.ComparePasswords(user.Password, password)
+	user.Password = ""
+	if err != nil {
+		return nil, "", ErrInvalidCredentials
+	}
+
+	sessionToken, err := s.startSession(ctx, user, userAgent)
 	if err != nil {
 		return nil, "", err
 	}
 
-	// Parse timestamps
-	user.Created, _ = time.Parse(time.RFC3339, createdStr)
-	user.Modified, _ = time.Parse(time.RFC3339, modifiedStr)
+	return user, sessionToken, nil
+}
 
-	// Check password
-	err = auth.ComparePasswords(hashedPassword, password)
+// AuthenticateUserPIN authenticates a non-admin user with their PIN. After
+// MaxPINAttempts wrong PINs in a row the user is locked out of PIN login for
+// PINLockoutDuration. Admins always have to log in with their password.
+func (s *AuthenticationService) AuthenticateUserPIN(ctx context.Context, userID int64, pin, userAgent string) (*models.User, string, error) {
+	user, hashedPIN, lockedUntil, err := s.users.PIN(ctx, userID)
 	if err != nil {
-		return nil, "", ErrInvalidCredentials
+		return nil, "", err
+	}
+	if user == nil {
+		return nil, "", ErrUserNotFound
 	}
 
-	// Generate session token
-	sessionToken := GenerateSessionToken(user.ID)
+	if user.IsAdmin || hashedPIN == "" {
+		return nil, "", ErrPINNotAllowed
+	}
 
-	// Store session
-	Sessions[sessionToken] = &user
+	now := time.Now().UTC()
+	if lockedUntil != nil && now.Before(lockedUntil.Time) {
+		return nil, "", ErrPINLocked
+	}
 
-	return &user, sessionToken, nil
+	// Check PIN
+	if err := auth.ComparePIN(hashedPIN, pin); err != nil {
+		locked, err := s.users.RecordPINFailure(ctx, user.ID, MaxPINAttempts, now.Add(PINLockoutDuration))
+		if err != nil {
+			return nil, "", err
+		}
+		if locked {
+			log.Printf("Locking PIN login for user %d after %d wrong PINs", user.ID, MaxPINAttempts)
+		}
+		return nil, "", ErrInvalidPIN
+	}
+
+	if err := s.users.ResetPINFailures(ctx, user.ID); err != nil {
+		return nil, "", err
+	}
+
+	sessionToken, err := s.startSession(ctx, user, userAgent)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, sessionToken, nil
 }
 
-// GetUserByID returns a user by ID
-func GetUserByID(db *sql.DB, id int64) (*models.User, error) {
-	var user models.User
-	var createdStr, modifiedStr string
+// startSession creates a new session for user and returns its token
+func (s *AuthenticationService) startSession(ctx context.Context, user *models.User, userAgent string) (string, error) {
+	sessionToken, err := GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	// The CSRF token is embedded in pages, so it must never be the session token
+	csrfToken, err := GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	session := &models.Session{
+		TokenHash: hashToken(sessionToken),
+		UserID:    user.ID,
+		Expires:   models.NewTimestamp(time.Now().Add(SessionTTL)),
+		UserAgent: userAgent,
+		CSRFToken: csrfToken,
+		User:      user,
+	}
+	if err := s.sessions.Create(ctx, session); err != nil {
+		return "", err
+	}
 
-	err := db.QueryRow(`
-		SELECT id, created, modified, name, password
-		FROM users
-		WHERE id = ?
-	`, id).Scan(&user.ID, &createdStr, &modifiedStr, &user.Name, &user.Password)
+	return sessionToken, nil
+}
 
-	if err == sql.ErrNoRows {
-		return nil, ErrUserNotFound
+// ValidateSession checks if a session token is valid and slides its expiry forward
+func (s *AuthenticationService) ValidateSession(ctx context.Context, sessionToken string) (*models.User, bool) {
+	session, ok := s.LookupSession(ctx, sessionToken)
+	if !ok {
+		return nil, false
 	}
+	return session.User, true
+}
+
+// LookupSession returns the session for a valid session token and slides its
+// expiry forward, marking the session Extended when it did
+func (s *AuthenticationService) LookupSession(ctx context.Context, sessionToken string) (*models.Session, bool) {
+	now := time.Now()
+	session, err := s.sessions.GetByTokenHash(ctx, hashToken(sessionToken), now)
 	if err != nil {
-		return nil, err
+		log.Printf("Error looking up session: %v", err)
+		return nil, false
+	}
+	if session == nil {
+		return nil, false
 	}
 
-	// Parse timestamps
-	user.Created, _ = time.Parse(time.RFC3339, createdStr)
-	user.Modified, _ = time.Parse(time.RFC3339, modifiedStr)
+	if now.Sub(session.LastSeen.Time) >= sessionTouchInterval {
+		if err := s.sessions.Touch(ctx, session.ID, now, now.Add(SessionTTL)); err != nil {
+			// The session is still valid, it just won't be extended this time
+			log.Printf("Error extending session %d: %v", session.ID, err)
+		} else {
+			session.LastSeen = models.NewTimestamp(now)
+			session.Expires = models.NewTimestamp(now.Add(SessionTTL))
+			session.Extended = true
+		}
+	}
 
-	return &user, nil
+	return session, true
 }
 
-// ValidateSession checks if a session token is valid
-func ValidateSession(sessionToken string) (*models.User, bool) {
-	user, exists := Sessions[sessionToken]
-	return user, exists
+// ValidCSRFToken reports whether token matches the session's CSRF token
+func ValidCSRFToken(session *models.Session, token string) bool {
+	if session.CSRFToken == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(session.CSRFToken), []byte(token)) == 1
 }
 
 // ClearSession removes a session from the session store
-func ClearSession(sessionToken string) {
-	delete(Sessions, sessionToken)
+func (s *AuthenticationService) ClearSession(ctx context.Context, sessionToken string) {
+	if err := s.sessions.Delete(ctx, hashToken(sessionToken)); err != nil {
+		log.Printf("Error clearing session: %v", err)
+	}
+}
+
+// ClearUserSessions removes every session belonging to a user, signing them
+// out everywhere
+func (s *AuthenticationService) ClearUserSessions(ctx context.Context, userID int64) error {
+	return s.sessions.DeleteForUser(ctx, userID)
+}
+
+// StartSessionSweeper periodically purges expired sessions until stop is called
+func StartSessionSweeper(sessions database.SessionRepository, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				removed, err := sessions.DeleteExpired(context.Background(), time.Now())
+				if err != nil {
+					log.Printf("Error purging expired sessions: %v", err)
+					continue
+				}
+				if removed > 0 {
+					log.Printf("Purged %d expired sessions", removed)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
 }
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bagvendt/chores/internal/database"
+	"github.com/bagvendt/chores/internal/models"
+	"github.com/bagvendt/chores/internal/utils/auth"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setupUserTestDB creates a temporary database with a single user
+func setupUserTestDB(t *testing.T, name, password string) *sql.DB {
+	tempDir, err := os.MkdirTemp("", "user_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tempDir)
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			name TEXT NOT NULL,
+			password TEXT NOT NULL,
+			is_admin BOOLEAN NOT NULL DEFAULT 0,
+			role TEXT NOT NULL DEFAULT 'child',
+			active BOOLEAN NOT NULL DEFAULT 1,
+			avatar TEXT,
+			pin TEXT,
+			pin_failed_attempts INTEGER NOT NULL DEFAULT 0,
+			pin_locked_until TIMESTAMP
+		);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create users table: %v", err)
+	}
+
+	// Use the cheapest cost so tests stay fast under the race detector
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (id, name, password) VALUES (1, ?, ?)", name, string(hashed)); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	return db
+}
+
+// newTestAuthentication returns an authentication service on top of db that
+// keeps sessions in a fresh in-memory store
+func newTestAuthentication(db *sql.DB) (*AuthenticationService, *MemorySessionStore) {
+	store := database.NewStore(db, db)
+	sessions := NewMemorySessionStore()
+	return NewAuthenticationService(store.Users, sessions, store.APITokens), sessions
+}
+
+func TestGenerateSessionToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token, err := GenerateSessionToken()
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+		// 32 random bytes base64 encoded without padding
+		if len(token) != 43 {
+			t.Errorf("Expected token of length 43, got %d (%q)", len(token), token)
+		}
+		if seen[token] {
+			t.Fatalf("Generated duplicate token %q", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestAuthenticateAndValidateSession(t *testing.T) {
+	db := setupUserTestDB(t, "poul", "secret")
+	authentication, store := newTestAuthentication(db)
+
+	if _, _, err := authentication.AuthenticateUser(t.Context(), "poul", "wrong", "test"); err != ErrInvalidCredentials {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, _, err := authentication.AuthenticateUser(t.Context(), "nobody", "secret", "test"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	user, token, err := authentication.AuthenticateUser(t.Context(), "poul", "secret", "test")
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+
+	// The raw token must never be used as the key
+	if _, exists := store.sessions[token]; exists {
+		t.Errorf("Expected session to be stored by token hash, found raw token")
+	}
+
+	validated, ok := authentication.ValidateSession(t.Context(), token)
+	if !ok {
+		t.Fatalf("Expected session to be valid")
+	}
+	if validated.ID != user.ID {
+		t.Errorf("Expected user %d, got %d", user.ID, validated.ID)
+	}
+
+	if _, ok := authentication.ValidateSession(t.Context(), token+"x"); ok {
+		t.Errorf("Expected tampered token to be invalid")
+	}
+
+	authentication.ClearSession(t.Context(), token)
+	if _, ok := authentication.ValidateSession(t.Context(), token); ok {
+		t.Errorf("Expected cleared session to be invalid")
+	}
+}
+
+// TestDummyPasswordHashCost guards against the dummy hash getting cheaper
+// than real ones, which would make unknown usernames fail faster again
+func TestDummyPasswordHashCost(t *testing.T) {
+	hashed, err := auth.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	want, _ := bcrypt.Cost([]byte(hashed))
+	got, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil {
+		t.Fatalf("Expected a valid dummy hash: %v", err)
+	}
+	if got != want {
+		t.Errorf("Expected the dummy hash to cost %d like stored hashes, got %d", want, got)
+	}
+}
+
+func TestAuthenticateUserPIN(t *testing.T) {
+	db := setupUserTestDB(t, "poul", "secret")
+	authentication, _ := newTestAuthentication(db)
+
+	if _, _, err := authentication.AuthenticateUserPIN(t.Context(), 1, "1234", "test"); err != ErrPINNotAllowed {
+		t.Errorf("Expected ErrPINNotAllowed without a PIN, got %v", err)
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash PIN: %v", err)
+	}
+	if _, err := db.Exec("UPDATE users SET pin = ? WHERE id = 1", string(hashed)); err != nil {
+		t.Fatalf("Failed to set PIN: %v", err)
+	}
+
+	user, token, err := authentication.AuthenticateUserPIN(t.Context(), 1, "1234", "test")
+	if err != nil {
+		t.Fatalf("Failed to authenticate with PIN: %v", err)
+	}
+	if validated, ok := authentication.ValidateSession(t.Context(), token); !ok || validated.ID != user.ID {
+		t.Errorf("Expected PIN login to create a valid session")
+	}
+
+	// A correct PIN resets the counter, so only consecutive failures lock
+	for i := 0; i < MaxPINAttempts-1; i++ {
+		if _, _, err := authentication.AuthenticateUserPIN(t.Context(), 1, "0000", "test"); err != ErrInvalidPIN {
+			t.Fatalf("Expected ErrInvalidPIN, got %v", err)
+		}
+	}
+	if _, _, err := authentication.AuthenticateUserPIN(t.Context(), 1, "1234", "test"); err != nil {
+		t.Fatalf("Expected correct PIN to still work, got %v", err)
+	}
+
+	for i := 0; i < MaxPINAttempts; i++ {
+		if _, _, err := authentication.AuthenticateUserPIN(t.Context(), 1, "0000", "test"); err != ErrInvalidPIN {
+			t.Fatalf("Expected ErrInvalidPIN, got %v", err)
+		}
+	}
+	if _, _, err := authentication.AuthenticateUserPIN(t.Context(), 1, "1234", "test"); err != ErrPINLocked {
+		t.Errorf("Expected ErrPINLocked after %d wrong PINs, got %v", MaxPINAttempts, err)
+	}
+
+	// The lockout expires
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	if _, err := db.Exec("UPDATE users SET pin_locked_until = ? WHERE id = 1", past); err != nil {
+		t.Fatalf("Failed to expire lockout: %v", err)
+	}
+	if _, _, err := authentication.AuthenticateUserPIN(t.Context(), 1, "1234", "test"); err != nil {
+		t.Errorf("Expected login after lockout expired, got %v", err)
+	}
+
+	// Admins have to use their password
+	if _, err := db.Exec("UPDATE users SET is_admin = 1 WHERE id = 1"); err != nil {
+		t.Fatalf("Failed to make user admin: %v", err)
+	}
+	if _, _, err := authentication.AuthenticateUserPIN(t.Context(), 1, "1234", "test"); err != ErrPINNotAllowed {
+		t.Errorf("Expected ErrPINNotAllowed for admin, got %v", err)
+	}
+}
+
+func TestMemorySessionStoreExpiry(t *testing.T) {
+	store := NewMemorySessionStore()
+	now := time.Now()
+
+	live := &models.Session{TokenHash: "live", UserID: 1, Expires: models.NewTimestamp(now.Add(time.Hour))}
+	dead := &models.Session{TokenHash: "dead", UserID: 1, Expires: models.NewTimestamp(now.Add(-time.Hour))}
+	for _, s := range []*models.Session{live, dead} {
+		if err := store.Create(t.Context(), s); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+	}
+
+	if s, _ := store.GetByTokenHash(t.Context(), "dead", now); s != nil {
+		t.Errorf("Expected expired session to be hidden")
+	}
+
+	// Sliding expiry keeps a touched session alive past its original expiry
+	if err := store.Touch(t.Context(), live.ID, now, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("Failed to touch session: %v", err)
+	}
+	if s, _ := store.GetByTokenHash(t.Context(), "live", now.Add(90*time.Minute)); s == nil {
+		t.Errorf("Expected touched session to still be valid")
+	}
+
+	removed, err := store.DeleteExpired(t.Context(), now)
+	if err != nil {
+		t.Fatalf("Failed to delete expired sessions: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 expired session removed, got %d", removed)
+	}
+}
+
+// TestSessionsConcurrent is meant to be run with -race
+func TestSessionsConcurrent(t *testing.T) {
+	db := setupUserTestDB(t, "poul", "secret")
+	authentication, store := newTestAuthentication(db)
+
+	const logins = 8
+	const validationsPerLogin = 50
+
+	var wg sync.WaitGroup
+	tokens := make(chan string, logins)
+	errs := make(chan error, logins)
+
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, token, err := authentication.AuthenticateUser(t.Context(), "poul", "secret", "test")
+			if err != nil {
+				errs <- err
+				return
+			}
+			tokens <- token
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("Failed to authenticate concurrently: %v", err)
+	}
+
+	var all []string
+	for token := range tokens {
+		all = append(all, token)
+	}
+
+	var validations sync.WaitGroup
+	failures := make(chan string, len(all)*validationsPerLogin)
+	for _, token := range all {
+		for i := 0; i < validationsPerLogin; i++ {
+			validations.Add(1)
+			go func(token string, i int) {
+				defer validations.Done()
+				if _, ok := authentication.ValidateSession(t.Context(), token); !ok {
+					failures <- token
+				}
+				// Mix in writes so the race detector sees readers and writers together
+				switch i % 10 {
+				case 0:
+					authentication.ClearSession(t.Context(), "unknown-"+token)
+				case 1:
+					now := time.Now()
+					store.Touch(t.Context(), int64(i%logins)+1, now, now.Add(SessionTTL))
+				}
+			}(token, i)
+		}
+	}
+	validations.Wait()
+	close(failures)
+
+	for token := range failures {
+		t.Errorf("Expected session %q to be valid", token)
+	}
+}
@@ -2,13 +2,30 @@
 package auth
 
 import (
+	"fmt"
+	"unicode/utf8"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	bcryptCost = 12 // Number of hashing iterations
+
+	// MinPasswordLength is the fewest characters a password may have
+	MinPasswordLength = 8
 )
 
+// ErrPasswordTooShort is returned when a password has fewer than MinPasswordLength characters
+var ErrPasswordTooShort = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+
+// ValidatePassword checks that a password has at least MinPasswordLength characters
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	return nil
+}
+
 // HashPassword hashes a password using bcrypt
 func HashPassword(password string) (string, error) {
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"fmt"
+)
+
+// PINLength is the number of digits in a login PIN
+const PINLength = 4
+
+// ErrInvalidPINFormat is returned when a PIN isn't exactly PINLength digits
+var ErrInvalidPINFormat = fmt.Errorf("PIN must be exactly %d digits", PINLength)
+
+// ValidatePIN checks that a PIN is exactly PINLength digits
+func ValidatePIN(pin string) error {
+	if len(pin) != PINLength {
+		return ErrInvalidPINFormat
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return ErrInvalidPINFormat
+		}
+	}
+	return nil
+}
+
+// HashPIN validates and hashes a PIN using bcrypt
+func HashPIN(pin string) (string, error) {
+	if err := ValidatePIN(pin); err != nil {
+		return "", err
+	}
+	return HashPassword(pin)
+}
+
+// ComparePIN compares a hashed PIN with a plain-text PIN
+func ComparePIN(hashedPIN, plainPIN string) error {
+	return ComparePasswords(hashedPIN, plainPIN)
+}
@@ -1,16 +1,23 @@
 package utils
 
 import (
+	"io/fs"
 	"log"
-	"os"
-	"path/filepath"
+	"path"
+
+	"github.com/bagvendt/chores"
 )
 
-// GetImageFiles reads the static/img directory and returns a list of image filenames.
+// GetImageFiles returns the filenames of the images in static/img.
 func GetImageFiles() ([]string, error) {
+	return ImageFiles(chores.Static())
+}
+
+// ImageFiles returns the filenames of the .avif images in the img directory of fsys.
+func ImageFiles(fsys fs.FS) ([]string, error) {
 	var files []string
-	imgDir := "./static/img"
-	items, err := os.ReadDir(imgDir)
+	imgDir := "img"
+	items, err := fs.ReadDir(fsys, imgDir)
 	if err != nil {
 		log.Printf("Error reading image directory %s: %v", imgDir, err)
 		return nil, err
@@ -18,7 +25,7 @@ func GetImageFiles() ([]string, error) {
 
 	for _, item := range items {
 		if !item.IsDir() {
-			ext := filepath.Ext(item.Name())
+			ext := path.Ext(item.Name())
 			if ext == ".avif" {
 				files = append(files, item.Name())
 			}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestImageFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"img/morning.avif":     {},
+		"img/bedtime.avif":     {},
+		"img/README.md":        {},
+		"img/old/unused.avif":  {},
+		"css/main.css":         {},
+		"not-an-image.avif.md": {},
+	}
+
+	files, err := ImageFiles(fsys)
+	if err != nil {
+		t.Fatalf("Failed to list images: %v", err)
+	}
+
+	expected := []string{"bedtime.avif", "morning.avif"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+}
@@ -0,0 +1,2 @@
+-- Both layouts are still read, so there is nothing to undo
+SELECT 1;
@@ -0,0 +1,35 @@
+-- Rows written by SQL defaults (CURRENT_TIMESTAMP) are stored as
+-- "YYYY-MM-DD HH:MM:SS" and rows written by the driver may carry fractional
+-- seconds and an offset. Rewrite every timestamp as RFC3339 in UTC, which is
+-- what the app writes. Values SQLite can't parse are left for the app to report.
+UPDATE users SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+UPDATE users SET modified = strftime('%Y-%m-%dT%H:%M:%SZ', modified) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', modified) IS NOT NULL;
+UPDATE users SET pin_locked_until = strftime('%Y-%m-%dT%H:%M:%SZ', pin_locked_until) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', pin_locked_until) IS NOT NULL;
+
+UPDATE chores SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+UPDATE chores SET modified = strftime('%Y-%m-%dT%H:%M:%SZ', modified) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', modified) IS NOT NULL;
+
+UPDATE routine_blueprints SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+UPDATE routine_blueprints SET modified = strftime('%Y-%m-%dT%H:%M:%SZ', modified) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', modified) IS NOT NULL;
+
+UPDATE routine_blueprint_chores SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+UPDATE routine_blueprint_chores SET modified = strftime('%Y-%m-%dT%H:%M:%SZ', modified) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', modified) IS NOT NULL;
+
+UPDATE routines SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+UPDATE routines SET modified = strftime('%Y-%m-%dT%H:%M:%SZ', modified) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', modified) IS NOT NULL;
+
+UPDATE chore_routines SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+UPDATE chore_routines SET modified = strftime('%Y-%m-%dT%H:%M:%SZ', modified) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', modified) IS NOT NULL;
+UPDATE chore_routines SET completed_at = strftime('%Y-%m-%dT%H:%M:%SZ', completed_at) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', completed_at) IS NOT NULL;
+
+UPDATE sessions SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+UPDATE sessions SET last_seen = strftime('%Y-%m-%dT%H:%M:%SZ', last_seen) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', last_seen) IS NOT NULL;
+UPDATE sessions SET expires = strftime('%Y-%m-%dT%H:%M:%SZ', expires) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', expires) IS NOT NULL;
+
+UPDATE user_children SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+
+UPDATE login_attempts SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+
+UPDATE api_tokens SET created = strftime('%Y-%m-%dT%H:%M:%SZ', created) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', created) IS NOT NULL;
+UPDATE api_tokens SET last_used = strftime('%Y-%m-%dT%H:%M:%SZ', last_used) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', last_used) IS NOT NULL;
+UPDATE api_tokens SET revoked = strftime('%Y-%m-%dT%H:%M:%SZ', revoked) WHERE strftime('%Y-%m-%dT%H:%M:%SZ', revoked) IS NOT NULL;
@@ -0,0 +1,14 @@
+ALTER TABLE routine_blueprints ADD COLUMN old_recurrence TEXT CHECK (old_recurrence IN ('Daily', 'Weekly', "Weekday") OR old_recurrence IS NULL);
+
+-- Only the frequency survives. Monday to Friday goes back to Weekday and
+-- monthly schedules are dropped
+UPDATE routine_blueprints SET old_recurrence = 'Daily' WHERE recurrence = 'Daily';
+UPDATE routine_blueprints SET old_recurrence = CASE weekdays WHEN 62 THEN 'Weekday' ELSE 'Weekly' END WHERE recurrence = 'Weekly';
+
+ALTER TABLE routine_blueprints DROP COLUMN recurrence;
+ALTER TABLE routine_blueprints RENAME COLUMN old_recurrence TO recurrence;
+ALTER TABLE routine_blueprints DROP COLUMN ends_on;
+ALTER TABLE routine_blueprints DROP COLUMN starts_on;
+ALTER TABLE routine_blueprints DROP COLUMN month_day;
+ALTER TABLE routine_blueprints DROP COLUMN recurrence_interval;
+ALTER TABLE routine_blueprints DROP COLUMN weekdays;
@@ -0,0 +1,20 @@
+-- Blueprints get a full schedule. recurrence becomes the frequency and can now
+-- be Monthly. Its CHECK can't be altered, so the column is swapped for a new one.
+ALTER TABLE routine_blueprints ADD COLUMN frequency TEXT CHECK (frequency IN ('Daily', 'Weekly', 'Monthly') OR frequency IS NULL);
+-- Weekdays is a bit set with bit 0 for Sunday, like Go's time.Weekday
+ALTER TABLE routine_blueprints ADD COLUMN weekdays INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE routine_blueprints ADD COLUMN recurrence_interval INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE routine_blueprints ADD COLUMN month_day INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE routine_blueprints ADD COLUMN starts_on TEXT;
+ALTER TABLE routine_blueprints ADD COLUMN ends_on TEXT;
+
+-- Existing schedules count from the day the blueprint was made
+UPDATE routine_blueprints SET starts_on = date(created) WHERE recurrence IS NOT NULL;
+UPDATE routine_blueprints SET frequency = 'Daily' WHERE recurrence = 'Daily';
+-- Weekday meant Monday to Friday
+UPDATE routine_blueprints SET frequency = 'Weekly', weekdays = 62 WHERE recurrence = 'Weekday';
+-- Weekly never had a day, so it becomes the weekday the blueprint was made on
+UPDATE routine_blueprints SET frequency = 'Weekly', weekdays = 1 << CAST(strftime('%w', created) AS INTEGER) WHERE recurrence = 'Weekly';
+
+ALTER TABLE routine_blueprints DROP COLUMN recurrence;
+ALTER TABLE routine_blueprints RENAME COLUMN frequency TO recurrence;
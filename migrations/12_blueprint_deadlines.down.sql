@@ -0,0 +1,3 @@
+-- Deadlines stay in HH:MM, which the old code read as well
+ALTER TABLE routine_blueprints DROP COLUMN hide_when_overdue;
+ALTER TABLE routine_blueprints DROP COLUMN available_from;
@@ -0,0 +1,21 @@
+-- Deadlines were free text. Turn them into HH:MM, which the app now requires.
+-- The home screen used to rank the words morning 10, breakfast 20, noon 30,
+-- lunch 40, afternoon 50, evening 60, dinner 70, night 80 and bedtime 90. They
+-- become morning and breakfast 08:00, noon and lunch 12:00, afternoon 15:00,
+-- evening and dinner 18:00 and night and bedtime 20:00, which keeps their
+-- order. Clock times are kept, and anything else becomes the end of the day.
+UPDATE routine_blueprints SET to_be_completed_by = CASE
+    WHEN lower(trim(to_be_completed_by)) IN ('morning', 'breakfast') THEN '08:00'
+    WHEN lower(trim(to_be_completed_by)) IN ('noon', 'lunch') THEN '12:00'
+    WHEN lower(trim(to_be_completed_by)) = 'afternoon' THEN '15:00'
+    WHEN lower(trim(to_be_completed_by)) IN ('evening', 'dinner') THEN '18:00'
+    WHEN lower(trim(to_be_completed_by)) IN ('night', 'bedtime') THEN '20:00'
+    WHEN time(trim(to_be_completed_by)) IS NOT NULL THEN strftime('%H:%M', trim(to_be_completed_by))
+    WHEN time('0' || trim(to_be_completed_by)) IS NOT NULL THEN strftime('%H:%M', '0' || trim(to_be_completed_by))
+    ELSE '23:59'
+END;
+
+-- A routine can't be started before available_from, and unfinished routines
+-- are either shown as overdue after the deadline or hidden
+ALTER TABLE routine_blueprints ADD COLUMN available_from TEXT;
+ALTER TABLE routine_blueprints ADD COLUMN hide_when_overdue BOOLEAN NOT NULL DEFAULT 0;
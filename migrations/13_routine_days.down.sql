@@ -0,0 +1,3 @@
+DROP INDEX routines_once_per_day;
+ALTER TABLE routines DROP COLUMN once_per_day;
+ALTER TABLE routines DROP COLUMN day;
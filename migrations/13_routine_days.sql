@@ -0,0 +1,10 @@
+-- A routine belongs to the household day it was started on. Older routines
+-- get the day they were created on in UTC, the best guess there is
+ALTER TABLE routines ADD COLUMN day TEXT;
+UPDATE routines SET day = date(created);
+
+-- Blueprints that don't allow several routines a day get at most one per
+-- child and day. The flag is copied from the blueprint when a routine is
+-- started; older routines keep 0, as there may be duplicates among them
+ALTER TABLE routines ADD COLUMN once_per_day BOOLEAN NOT NULL DEFAULT 0;
+CREATE UNIQUE INDEX routines_once_per_day ON routines (owner_id, routine_blueprint_id, day) WHERE once_per_day;
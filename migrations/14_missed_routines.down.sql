@@ -0,0 +1 @@
+ALTER TABLE routines DROP COLUMN missed;
@@ -0,0 +1,2 @@
+-- The scheduler marks routines that weren't finished on their day as missed
+ALTER TABLE routines ADD COLUMN missed BOOLEAN NOT NULL DEFAULT 0;
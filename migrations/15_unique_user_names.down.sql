@@ -0,0 +1 @@
+DROP INDEX users_name;
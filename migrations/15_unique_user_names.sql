@@ -0,0 +1,6 @@
+-- User names are used to log in, so no two users may share one. Existing
+-- duplicates keep the oldest user's name and the others get their ID appended
+UPDATE users SET name = name || ' (' || id || ')'
+WHERE id NOT IN (SELECT MIN(id) FROM users GROUP BY name);
+
+CREATE UNIQUE INDEX users_name ON users (name);
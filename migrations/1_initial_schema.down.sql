@@ -0,0 +1,8 @@
+-- Drop everything the initial schema created, children before parents.
+-- The migrations table stays since it tracks what is applied.
+DROP TABLE IF EXISTS chore_routines;
+DROP TABLE IF EXISTS routines;
+DROP TABLE IF EXISTS routine_blueprint_chores;
+DROP TABLE IF EXISTS routine_blueprints;
+DROP TABLE IF EXISTS chores;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,8 @@
+-- Put the 'img/' prefix back on image columns
+UPDATE chores
+SET image = 'img/' || image
+WHERE image IS NOT NULL AND image != '' AND image NOT LIKE 'img/%';
+
+UPDATE routine_blueprints
+SET image = 'img/' || image
+WHERE image != '' AND image NOT LIKE 'img/%';
@@ -0,0 +1,14 @@
+-- Create sessions table
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY,
+    token_hash TEXT UNIQUE NOT NULL,
+    user_id INTEGER NOT NULL,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires TIMESTAMP NOT NULL,
+    user_agent TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Index used by the expired session sweeper
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions (expires);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_children;
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,21 @@
+-- Every user is either a parent or a child. Admins are the parents of the household.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'child' CHECK (role IN ('parent', 'child'));
+
+UPDATE users SET role = 'parent' WHERE is_admin = 1;
+
+-- Create user_children table linking parents to the children they act for
+CREATE TABLE IF NOT EXISTS user_children (
+    id INTEGER PRIMARY KEY,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    parent_id INTEGER NOT NULL,
+    child_id INTEGER NOT NULL,
+    UNIQUE (parent_id, child_id),
+    FOREIGN KEY (parent_id) REFERENCES users(id),
+    FOREIGN KEY (child_id) REFERENCES users(id)
+);
+
+-- Seed: every existing parent looks after every existing child
+INSERT INTO user_children (parent_id, child_id)
+SELECT p.id, c.id
+FROM users p, users c
+WHERE p.role = 'parent' AND c.role = 'child';
@@ -0,0 +1,2 @@
+-- Deactivated users can't log in but keep their history
+ALTER TABLE users ADD COLUMN active BOOLEAN NOT NULL DEFAULT 1;
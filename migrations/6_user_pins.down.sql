@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN pin_locked_until;
+ALTER TABLE users DROP COLUMN pin_failed_attempts;
+ALTER TABLE users DROP COLUMN pin;
+ALTER TABLE users DROP COLUMN avatar;
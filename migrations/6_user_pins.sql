@@ -0,0 +1,5 @@
+-- Children can log in by tapping their avatar and entering a short PIN
+ALTER TABLE users ADD COLUMN avatar TEXT;
+ALTER TABLE users ADD COLUMN pin TEXT; -- bcrypt hash, NULL when PIN login is disabled
+ALTER TABLE users ADD COLUMN pin_failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN pin_locked_until TIMESTAMP;
@@ -0,0 +1,11 @@
+-- Audit log of failed and rate limited logins
+CREATE TABLE login_attempts (
+    id INTEGER PRIMARY KEY,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    username TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT,
+    reason TEXT NOT NULL
+);
+
+CREATE INDEX idx_login_attempts_created ON login_attempts(created);
@@ -0,0 +1,4 @@
+-- Every session gets its own CSRF token. Existing sessions get a random one
+-- so nobody is logged out.
+ALTER TABLE sessions ADD COLUMN csrf_token TEXT NOT NULL DEFAULT '';
+UPDATE sessions SET csrf_token = lower(hex(randomblob(32)));
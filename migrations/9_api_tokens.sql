@@ -0,0 +1,12 @@
+-- Personal access tokens for scripts and home automation. Only the hash of a
+-- token is stored.
+CREATE TABLE api_tokens (
+    id INTEGER PRIMARY KEY,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    last_used TIMESTAMP,
+    revoked TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
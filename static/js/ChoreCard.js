@@ -621,10 +621,12 @@ class ChoreCard extends HTMLElement {
    * @param {boolean} completed - New completion status
    */
   updateChoreCompletionStatus(routineId, choreId, completed) {
+    const csrfMeta = document.querySelector('meta[name="csrf-token"]');
     fetch(`/api/routine/${routineId}/chore/${choreId}`, {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
+        'X-CSRF-Token': csrfMeta ? csrfMeta.content : '',
       },
       body: JSON.stringify({
         completed: completed